@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+// closeCommand returns the lncli command cooperatively closing the channel
+// with the given funding outpoint.
+func closeCommand(channelPoint string) (string, error) {
+	op, err := chanleak.ParseOutPoint(channelPoint)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("lncli closechannel --funding_txid %v "+
+		"--output_index %v", op.Hash, op.Index), nil
+}
+
+// writeSuggestedActions writes the lncli command closing each of the open
+// invalid channels of the report to the given writer. These are merely
+// suggestions, so they're clearly labeled as such for the operator to review
+// before running any of them.
+func writeSuggestedActions(w io.Writer, report *jsonReport) error {
+	var lines []string
+	for _, channel := range report.InvalidChannels {
+		// A channel that was closed already needs no further action.
+		if channel.Closed {
+			continue
+		}
+
+		cmd, err := closeCommand(channel.ChannelPoint)
+		if err != nil {
+			log.Warnf("Unable to suggest how to close %v: %v",
+				channel.ShortChanID, err)
+			continue
+		}
+
+		peer := channel.RemotePubkey
+		if channel.PeerAlias != "" {
+			peer = fmt.Sprintf("%v (%v)", peer, channel.PeerAlias)
+		}
+		lines = append(lines, fmt.Sprintf("# %v with %v, capacity %v",
+			channel.ShortChanID, peer,
+			btcutil.Amount(channel.SubjectiveCapacity)))
+		lines = append(lines, cmd)
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(w, "\nSUGGESTED ACTIONS, review each before "+
+		"running it, as closing a channel can't be undone:\n")
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "If a peer is offline, add --force to close "+
+		"its channel unilaterally.\n\n")
+
+	return err
+}
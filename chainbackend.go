@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+const (
+	// chainBackendBitcoind verifies funding outputs against the JSON-RPC
+	// interface of a bitcoind or btcd node.
+	chainBackendBitcoind = "bitcoind"
+
+	// chainBackendEsplora verifies funding outputs against the HTTP API
+	// of an Esplora block explorer.
+	chainBackendEsplora = "esplora"
+)
+
+// defaultEsploraURLs maps each network to the public Esplora API used if
+// -esploraurl isn't set.
+var defaultEsploraURLs = map[string]string{
+	"mainnet": "https://blockstream.info/api",
+	"testnet": "https://blockstream.info/testnet/api",
+	"signet":  "https://mempool.space/signet/api",
+}
+
+// chainBackend is a chain backend that holds resources which must be released
+// once we're done with it.
+type chainBackend interface {
+	chanleak.ChainBackend
+
+	// Stop releases all resources held by the backend.
+	Stop()
+}
+
+// newChainBackend creates the chain backend selected with -chainbackend.
+func newChainBackend() (chainBackend, error) {
+	switch *chainBackendType {
+	case chainBackendBitcoind:
+		backend, err := chanleak.NewBitcoindBackend(
+			*chainRPCHost, *chainRPCUser, *chainRPCPass,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return backend, nil
+
+	case chainBackendEsplora:
+		url, err := esploraURL()
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Verifying funding outputs against the Esplora API "+
+			"at %v", url)
+
+		return chanleak.NewEsploraBackend(url), nil
+
+	default:
+		return nil, fmt.Errorf("unknown chain backend %q",
+			*chainBackendType)
+	}
+}
+
+// esploraURL returns the Esplora API to use, which defaults to the public API
+// of the selected network.
+func esploraURL() (string, error) {
+	if *esploraAPI != "" {
+		return *esploraAPI, nil
+	}
+
+	url, ok := defaultEsploraURLs[*network]
+	if !ok {
+		return "", fmt.Errorf("there's no public Esplora API for %v, "+
+			"set -esploraurl", *network)
+	}
+
+	return url, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lightninglabs/neutrino/cache/lru"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+const (
+	// defaultChanInfoTTL is the default time a cached GetChanInfo result is
+	// reused for in serve mode, which doesn't scan at a fixed interval.
+	defaultChanInfoTTL = 30 * time.Minute
+
+	// chanInfoTTLIntervals is the number of scan intervals a cached
+	// GetChanInfo result is reused for by default in watch and follow
+	// mode, so the results of one scan are still fresh by the next.
+	chanInfoTTLIntervals = 3
+
+	// chanInfoCacheSize is the maximum number of GetChanInfo results we'll
+	// cache, which comfortably covers the channels of the largest nodes.
+	chanInfoCacheSize = 10000
+)
+
+// cachedEdge is a GetChanInfo result held by the cache.
+type cachedEdge struct {
+	edge *lnrpc.ChannelEdge
+
+	// fetchedAt is the time the edge was fetched at.
+	fetchedAt time.Time
+
+	// height is the node's block height at the time the edge was fetched.
+	height uint32
+
+	// settled is true if the edge's last update predates the node's best
+	// block at the time the edge was fetched.
+	settled bool
+}
+
+// Size returns the size of the edge within the cache. As the cache is bounded
+// by the number of edges it holds, each edge counts as one.
+func (c *cachedEdge) Size() (uint64, error) {
+	return 1, nil
+}
+
+// chanInfoCacheClient wraps a node client, reusing the result of GetChanInfo
+// for the same channel across repeated scans, such as those of watch mode.
+// The capacity and funding outpoint of a channel never change, only its
+// routing policies do, each of which carries the time of its last update. An
+// edge whose last update predates the node's best block at the time it was
+// fetched has settled, and is reused across new blocks until its TTL expires.
+// An edge updated since is still propagating through the network, so it's
+// only reused until the node's chain advances past the height it was fetched
+// at. All other calls are passed through.
+type chanInfoCacheClient struct {
+	nodeClient
+
+	// ttl is the time a cached result is reused for.
+	ttl time.Duration
+
+	cache *lru.Cache
+
+	// height is the node's block height as of the start of the latest
+	// scan.
+	height uint32
+
+	// blockTime is the time of the node's best block as of the start of
+	// the latest scan.
+	blockTime time.Time
+
+	// now returns the current time. It's only replaced by tests.
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+// newChanInfoCacheClient returns a client caching GetChanInfo results for the
+// given time.
+func newChanInfoCacheClient(client nodeClient,
+	ttl time.Duration) *chanInfoCacheClient {
+
+	return &chanInfoCacheClient{
+		nodeClient: client,
+		ttl:        ttl,
+		cache:      lru.NewCache(chanInfoCacheSize),
+		now:        time.Now,
+	}
+}
+
+// chanInfoCacheTTL returns the time GetChanInfo results are cached for. Unless
+// set with -chaninfo-ttl, the results of a scan in watch or follow mode are
+// cached for several scan intervals, as they'd never be reused by the next
+// scan otherwise.
+func chanInfoCacheTTL() time.Duration {
+	if flagIsSet("chaninfo-ttl") {
+		return *chanInfoTTL
+	}
+
+	switch {
+	case *watch:
+		return chanInfoTTLIntervals * *interval
+
+	case *follow && flagIsSet("interval"):
+		return chanInfoTTLIntervals * *interval
+
+	case *follow:
+		return chanInfoTTLIntervals * followScanInterval
+
+	default:
+		return *chanInfoTTL
+	}
+}
+
+// edgeLastUpdate returns the time of the latest update of either routing
+// policy of the given edge.
+func edgeLastUpdate(edge *lnrpc.ChannelEdge) time.Time {
+	var lastUpdate uint32
+	for _, policy := range []*lnrpc.RoutingPolicy{
+		edge.Node1Policy, edge.Node2Policy,
+	} {
+		if policy != nil && policy.LastUpdate > lastUpdate {
+			lastUpdate = policy.LastUpdate
+		}
+	}
+
+	return time.Unix(int64(lastUpdate), 0)
+}
+
+// ListChannels returns the node's open channels. As every scan starts out by
+// listing them, we'll also learn the node's current height here, rather than
+// for every single GetChanInfo call.
+func (c *chanInfoCacheClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	info, err := c.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get node info: %v", err)
+	}
+
+	c.mu.Lock()
+	c.height = info.BlockHeight
+	c.blockTime = time.Unix(info.BestHeaderTimestamp, 0)
+	c.mu.Unlock()
+
+	return c.nodeClient.ListChannels(ctx, in, opts...)
+}
+
+// GetChanInfo returns the channel graph's view of a single channel from the
+// cache if it's still fresh, and fetches it from the node otherwise.
+func (c *chanInfoCacheClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	c.mu.Lock()
+	height, blockTime := c.height, c.blockTime
+	c.mu.Unlock()
+
+	if value, err := c.cache.Get(in.ChanId); err == nil {
+		cached := value.(*cachedEdge)
+		fresh := c.now().Sub(cached.fetchedAt) <= c.ttl
+		if fresh && (cached.settled || cached.height >= height) {
+			return cached.edge, nil
+		}
+	}
+
+	// Errors aren't cached, so a channel that isn't part of the graph yet
+	// is picked up as soon as it's announced.
+	edge, err := c.nodeClient.GetChanInfo(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.cache.Put(in.ChanId, &cachedEdge{
+		edge:      edge,
+		fetchedAt: c.now(),
+		height:    height,
+		settled:   edgeLastUpdate(edge).Before(blockTime),
+	})
+	if err != nil {
+		log.Warnf("Unable to cache channel %v: %v", in.ChanId, err)
+	}
+
+	return edge, nil
+}
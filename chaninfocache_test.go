@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// chanInfoNode is a node client serving a fixed set of channel edges at a
+// given height, which counts the GetChanInfo calls it serves. Any other call
+// isn't expected and panics.
+type chanInfoNode struct {
+	nodeClient
+
+	height    uint32
+	blockTime time.Time
+	edges     map[uint64]*lnrpc.ChannelEdge
+
+	numChanInfo int
+}
+
+// GetInfo returns the node's current height and best block time.
+func (n *chanInfoNode) GetInfo(_ context.Context, _ *lnrpc.GetInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+
+	return &lnrpc.GetInfoResponse{
+		BlockHeight:         n.height,
+		BestHeaderTimestamp: n.blockTime.Unix(),
+	}, nil
+}
+
+// ListChannels returns no channels, as only the height it's accompanied by
+// matters to the cache.
+func (n *chanInfoNode) ListChannels(_ context.Context,
+	_ *lnrpc.ListChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	return &lnrpc.ListChannelsResponse{}, nil
+}
+
+// GetChanInfo returns the edge of the requested channel.
+func (n *chanInfoNode) GetChanInfo(_ context.Context,
+	in *lnrpc.ChanInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	n.numChanInfo++
+	return n.edges[in.ChanId], nil
+}
+
+// TestChanInfoCacheWatch makes sure that with the default settings of watch
+// mode, the next scan reuses the edges looked up by the previous one, even
+// though a block was mined in between, unless their policies were updated
+// after the node's best block.
+func TestChanInfoCacheWatch(t *testing.T) {
+	origWatch := *watch
+	*watch = true
+	defer func() {
+		*watch = origWatch
+	}()
+
+	start := time.Unix(1600000000, 0)
+	edgeWithUpdate := func(chanID uint64,
+		lastUpdate time.Time) *lnrpc.ChannelEdge {
+
+		return &lnrpc.ChannelEdge{
+			ChannelId: chanID,
+			Capacity:  1000000,
+			Node1Policy: &lnrpc.RoutingPolicy{
+				LastUpdate: uint32(lastUpdate.Unix()),
+			},
+		}
+	}
+
+	// The edge of channel 1 was last updated well before the node's best
+	// block, while the policy of channel 2 was updated since.
+	node := &chanInfoNode{
+		height:    600000,
+		blockTime: start.Add(-time.Minute),
+		edges: map[uint64]*lnrpc.ChannelEdge{
+			1: edgeWithUpdate(1, start.Add(-24*time.Hour)),
+			2: edgeWithUpdate(2, start.Add(-30*time.Second)),
+		},
+	}
+
+	now := start
+	client := newChanInfoCacheClient(node, chanInfoCacheTTL())
+	client.now = func() time.Time {
+		return now
+	}
+
+	ctx := context.Background()
+	scan := func() {
+		t.Helper()
+
+		_, err := client.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+		if err != nil {
+			t.Fatalf("unable to list channels: %v", err)
+		}
+		for chanID := range node.edges {
+			req := &lnrpc.ChanInfoRequest{ChanId: chanID}
+			_, err := client.GetChanInfo(ctx, req)
+			if err != nil {
+				t.Fatalf("unable to get channel %v: %v", chanID,
+					err)
+			}
+		}
+	}
+	assertLookups := func(expected int) {
+		t.Helper()
+
+		if node.numChanInfo != expected {
+			t.Fatalf("expected %v lookups, got %v", expected,
+				node.numChanInfo)
+		}
+	}
+
+	scan()
+	assertLookups(2)
+
+	// By the next scan of watch mode, a block has been mined. Only the
+	// edge updated after the previous best block is looked up again.
+	now = now.Add(*interval)
+	node.height++
+	node.blockTime = now.Add(-time.Minute)
+	scan()
+	assertLookups(3)
+
+	// As of its latest lookup, the update of channel 2 predates the best
+	// block as well, so neither edge is dropped by the next block.
+	now = now.Add(*interval)
+	node.height++
+	node.blockTime = now.Add(-time.Minute)
+	scan()
+	assertLookups(3)
+
+	// Once the TTL has expired, both edges are looked up again.
+	now = now.Add(chanInfoCacheTTL())
+	scan()
+	assertLookups(5)
+}
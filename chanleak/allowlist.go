@@ -0,0 +1,124 @@
+package chanleak
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// Allowlist is a set of channels an operator has confirmed to be valid, such
+// as channels that were flagged due to a quirk of the node's graph sync. Any
+// channel on the allowlist is left out of the invalid channels of a scan.
+type Allowlist struct {
+	// chanIDs maps the short channel ID of each allowlisted channel to
+	// the entry it was listed as.
+	chanIDs map[lnwire.ShortChannelID]string
+
+	// chanPoints maps the funding outpoint of each allowlisted channel to
+	// the entry it was listed as.
+	chanPoints map[wire.OutPoint]string
+}
+
+// ParseAllowlist reads an allowlist with one channel per line, given either as
+// a short channel ID (block:tx:output or its uint64 form) or a funding
+// outpoint (txid:index). Empty lines are ignored, as is anything following a
+// #, so entries can be annotated with the reason they were allowlisted.
+func ParseAllowlist(r io.Reader) (*Allowlist, error) {
+	allowlist := &Allowlist{
+		chanIDs:    make(map[lnwire.ShortChannelID]string),
+		chanPoints: make(map[wire.OutPoint]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		entry := scanner.Text()
+		if i := strings.Index(entry, "#"); i != -1 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Outpoints carry a single colon, while short channel IDs in
+		// their block:tx:output form carry two, and none in their
+		// compact form.
+		if strings.Count(entry, ":") == 1 {
+			op, err := ParseOutPoint(entry)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum,
+					err)
+			}
+			allowlist.chanPoints[*op] = entry
+
+			continue
+		}
+
+		cid, err := ParseShortChanID(entry)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		allowlist.chanIDs[cid] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return allowlist, nil
+}
+
+// Len returns the number of channels on the allowlist.
+func (a *Allowlist) Len() int {
+	return len(a.chanIDs) + len(a.chanPoints)
+}
+
+// lookup returns the entry of the allowlist matching the channel with the
+// given short channel ID or funding outpoint, if any. It's safe to call on a
+// nil allowlist, which never matches.
+func (a *Allowlist) lookup(cid lnwire.ShortChannelID,
+	chanPoint string) (string, bool) {
+
+	if a == nil {
+		return "", false
+	}
+
+	if entry, ok := a.chanIDs[cid]; ok {
+		return entry, true
+	}
+
+	op, err := ParseOutPoint(chanPoint)
+	if err != nil {
+		return "", false
+	}
+	entry, ok := a.chanPoints[*op]
+
+	return entry, ok
+}
+
+// unmatched returns the entries of the allowlist that aren't part of the given
+// set of matched entries, in sorted order.
+func (a *Allowlist) unmatched(matched map[string]struct{}) []string {
+	if a == nil {
+		return nil
+	}
+
+	var entries []string
+	for _, entry := range a.chanIDs {
+		if _, ok := matched[entry]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range a.chanPoints {
+		if _, ok := matched[entry]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+
+	return entries
+}
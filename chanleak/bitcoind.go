@@ -0,0 +1,117 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BitcoindBackend is a ChainBackend that is backed by the JSON-RPC interface
+// of a bitcoind or btcd node.
+type BitcoindBackend struct {
+	client *rpcclient.Client
+}
+
+// A compile-time check to ensure BitcoindBackend satisfies ChainBackend.
+var _ ChainBackend = (*BitcoindBackend)(nil)
+
+// NewBitcoindBackend connects to the JSON-RPC interface of the bitcoind or btcd
+// node at the given host, authenticating with the given credentials.
+//
+// NOTE: Determining whether a missing output was spent rather than never
+// existed in the first place requires the node to run with txindex enabled.
+func NewBitcoindBackend(host, user, pass string) (*BitcoindBackend, error) {
+	client, err := rpcclient.New(&rpcclient.ConnConfig{
+		Host:         host,
+		User:         user,
+		Pass:         pass,
+		DisableTLS:   true,
+		HTTPPostMode: true,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to chain backend: %v",
+			err)
+	}
+
+	return &BitcoindBackend{
+		client: client,
+	}, nil
+}
+
+// FetchOutput returns the value of the output referenced by the outpoint,
+// along with its current state.
+//
+// NOTE: This is part of the ChainBackend interface.
+func (b *BitcoindBackend) FetchOutput(ctx context.Context,
+	op *wire.OutPoint) (btcutil.Amount, OutputState, error) {
+
+	// The RPC client doesn't support contexts, so the best we can do is
+	// to not issue any new requests once the context is canceled.
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	txOut, err := b.client.GetTxOut(&op.Hash, op.Index, false)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// If the output is part of the UTXO set, then we can read its value
+	// directly.
+	if txOut != nil {
+		value, err := btcutil.NewAmount(txOut.Value)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return value, OutputUnspent, nil
+	}
+
+	// Otherwise the output was either spent or never existed. To tell the
+	// two apart, we'll look up the transaction itself. Only the node
+	// reporting that it doesn't know of the transaction means it doesn't
+	// exist, any other error leaves us none the wiser. Without txindex,
+	// the node only knows of the transactions within its mempool, so
+	// it can't tell a spent output from a missing one.
+	tx, err := b.client.GetRawTransaction(&op.Hash)
+	if isTxNotFound(err) {
+		if requiresTxIndex(err) {
+			return 0, OutputNotIndexed, nil
+		}
+		return 0, OutputNotFound, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	txOuts := tx.MsgTx().TxOut
+	if int(op.Index) >= len(txOuts) {
+		return 0, OutputIndexOutOfRange, nil
+	}
+
+	return btcutil.Amount(txOuts[op.Index].Value), OutputSpent, nil
+}
+
+// isTxNotFound returns true if the given error is the node reporting that it
+// doesn't know of the transaction that was looked up.
+func isTxNotFound(err error) bool {
+	rpcErr, ok := err.(*btcjson.RPCError)
+	return ok && rpcErr.Code == btcjson.ErrRPCInvalidAddressOrKey
+}
+
+// requiresTxIndex returns true if the given error of a transaction lookup
+// points to the node not running with txindex, as both bitcoind and btcd
+// mention it in their error.
+func requiresTxIndex(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "txindex")
+}
+
+// Stop shuts down the connection to the chain backend.
+func (b *BitcoindBackend) Stop() {
+	b.client.Shutdown()
+}
@@ -0,0 +1,151 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// OutputState describes the on-chain state of a funding output.
+type OutputState uint8
+
+const (
+	// OutputUnspent indicates that the output exists within the UTXO set.
+	OutputUnspent OutputState = iota
+
+	// OutputSpent indicates that the output exists, but has already been
+	// spent.
+	OutputSpent
+
+	// OutputNotFound indicates that the output doesn't exist on-chain.
+	OutputNotFound
+
+	// OutputIndexOutOfRange indicates that the transaction of the output
+	// exists on-chain, but has no output at its index. A channel point
+	// pointing past the outputs of its funding transaction is a sign of
+	// fabricated channel data rather than an honest mistake.
+	OutputIndexOutOfRange
+
+	// OutputNotIndexed indicates that the output isn't part of the UTXO
+	// set, but the backend can't tell whether it was spent or never
+	// existed, as it doesn't index all transactions.
+	OutputNotIndexed
+)
+
+// String returns a human readable description of the output state.
+func (s OutputState) String() string {
+	switch s {
+	case OutputUnspent:
+		return "unspent"
+
+	case OutputSpent:
+		return "spent"
+
+	case OutputNotFound:
+		return "not found"
+
+	case OutputIndexOutOfRange:
+		return "index out of range"
+
+	case OutputNotIndexed:
+		return "spent or not found"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// ChainBackend provides access to the on-chain state of transaction outputs.
+type ChainBackend interface {
+	// FetchOutput returns the value of the output referenced by the
+	// outpoint, along with its current state. If the output doesn't exist,
+	// the value is zero and the state is OutputNotFound.
+	FetchOutput(ctx context.Context, op *wire.OutPoint) (btcutil.Amount,
+		OutputState, error)
+}
+
+// ChainMismatch describes a channel whose funding output on-chain doesn't
+// match what the channel graph claims.
+type ChainMismatch struct {
+	// FundingOutpoint is the funding outpoint of the channel according to
+	// the channel graph.
+	FundingOutpoint wire.OutPoint
+
+	// State is the on-chain state of the funding output. Any state other
+	// than OutputUnspent is a mismatch for an open channel.
+	State OutputState
+
+	// ChainValue is the value of the funding output on-chain. This is
+	// zero if the output wasn't found.
+	ChainValue btcutil.Amount
+}
+
+// ParseOutPoint parses an outpoint in the txid:index format used by lnd. As
+// the channel points lnd reports are always in canonical form, anything else,
+// such as a truncated txid or an index with leading zeros, is rejected rather
+// than interpreted.
+func ParseOutPoint(s string) (*wire.OutPoint, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("outpoint %q should be of the form "+
+			"txid:index", s)
+	}
+
+	// A shorter txid would be silently padded with zeros, so we'll insist
+	// on the full length.
+	if len(parts[0]) != chainhash.MaxHashStringSize {
+		return nil, fmt.Errorf("invalid txid in outpoint %q: must be "+
+			"%v hex characters long", s, chainhash.MaxHashStringSize)
+	}
+	txid, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid txid in outpoint %q: %v", s,
+			err)
+	}
+
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output index in outpoint "+
+			"%q: %v", s, err)
+	}
+	if strconv.FormatUint(index, 10) != parts[1] {
+		return nil, fmt.Errorf("invalid output index in outpoint "+
+			"%q: must not have leading zeros", s)
+	}
+
+	return wire.NewOutPoint(txid, uint32(index)), nil
+}
+
+// verifyFundingOutput checks that the funding output of a channel exists
+// on-chain, is unspent and carries the capacity the channel graph claims. If
+// everything checks out, nil is returned.
+func verifyFundingOutput(ctx context.Context, backend ChainBackend,
+	chanPoint string, graphCapacity btcutil.Amount) (*ChainMismatch,
+	error) {
+
+	op, err := ParseOutPoint(chanPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	value, state, err := backend.FetchOutput(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch funding output %v: %v",
+			op, err)
+	}
+
+	if state == OutputUnspent && value == graphCapacity {
+		return nil, nil
+	}
+
+	return &ChainMismatch{
+		FundingOutpoint: *op,
+		State:           state,
+		ChainValue:      value,
+	}, nil
+}
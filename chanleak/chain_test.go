@@ -0,0 +1,108 @@
+package chanleak
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseOutPoint makes sure only channel points in the canonical txid:index
+// form lnd reports are accepted.
+func TestParseOutPoint(t *testing.T) {
+	txid := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name      string
+		chanPoint string
+		index     uint32
+		valid     bool
+	}{
+		{
+			name:      "first output",
+			chanPoint: txid + ":0",
+			index:     0,
+			valid:     true,
+		},
+		{
+			name:      "largest index",
+			chanPoint: txid + ":4294967295",
+			index:     4294967295,
+			valid:     true,
+		},
+		{
+			name:      "empty",
+			chanPoint: "",
+		},
+		{
+			name:      "missing index",
+			chanPoint: txid,
+		},
+		{
+			name:      "empty index",
+			chanPoint: txid + ":",
+		},
+		{
+			name:      "extra separator",
+			chanPoint: txid + ":0:1",
+		},
+		{
+			name:      "truncated txid",
+			chanPoint: txid[:62] + ":0",
+		},
+		{
+			name:      "overlong txid",
+			chanPoint: txid + "ab:0",
+		},
+		{
+			name:      "non-hex txid",
+			chanPoint: strings.Repeat("zz", 32) + ":0",
+		},
+		{
+			name:      "negative index",
+			chanPoint: txid + ":-1",
+		},
+		{
+			name:      "signed index",
+			chanPoint: txid + ":+1",
+		},
+		{
+			name:      "leading zeros",
+			chanPoint: txid + ":01",
+		},
+		{
+			name:      "index out of range",
+			chanPoint: txid + ":4294967296",
+		},
+		{
+			name:      "non-numeric index",
+			chanPoint: txid + ":one",
+		},
+		{
+			name:      "whitespace",
+			chanPoint: txid + ": 1",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			op, err := ParseOutPoint(test.chanPoint)
+			switch {
+			case test.valid && err != nil:
+				t.Fatalf("unable to parse %q: %v",
+					test.chanPoint, err)
+
+			case !test.valid && err == nil:
+				t.Fatalf("expected %q to be rejected, got %v",
+					test.chanPoint, op)
+
+			case !test.valid:
+				return
+			}
+
+			if op.Hash.String() != txid || op.Index != test.index {
+				t.Fatalf("expected %v:%v, got %v", txid,
+					test.index, op)
+			}
+		})
+	}
+}
@@ -0,0 +1,1073 @@
+// Package chanleak implements the detection of channels that were accepted by
+// an lnd node despite being invalid, as described by CVE-2019-12999, as well
+// as the quantification of the coins lost due to forwards over such channels.
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// incompleteGraphMinChannels is the number of public channels a node
+	// must have before we judge the completeness of the channel graph by
+	// the share of its channels missing from it. Fewer channels may all
+	// just be too fresh to have been announced yet.
+	incompleteGraphMinChannels = 4
+
+	// incompleteGraphMissingRatio is the share of the node's public
+	// channels that may be missing from the channel graph before the
+	// graph is considered incomplete.
+	incompleteGraphMissingRatio = 0.5
+
+	// DefaultNumWorkers is the default number of channels that are
+	// verified against the channel graph concurrently.
+	DefaultNumWorkers = 8
+)
+
+// Config houses all the items the Checker needs to carry out its duties.
+type Config struct {
+	// Client is the connection to the target lnd node. This'll be our
+	// source for all the information of the target node. The gRPC client
+	// returned by lndclient.NewBasicClient satisfies this interface.
+	Client LndClient
+
+	// ChannelSource provides the set of open channels that are verified
+	// against the channel graph. If nil, they're obtained through the
+	// ListChannels RPC of Client.
+	ChannelSource ChannelSource
+
+	// FallbackChannels, if set, provides the set of open channels
+	// whenever ChannelSource fails, so a node that restricts ListChannels
+	// can still be verified against the operator's own records.
+	FallbackChannels ChannelSource
+
+	// GraphMode determines how the channel graph is queried. This must be
+	// one of GraphModeDescribe or GraphModeLookup. If empty,
+	// GraphModeDescribe is used.
+	GraphMode string
+
+	// NumWorkers is the number of channels that are verified against the
+	// channel graph concurrently, which also bounds the number of peers
+	// ResolvePeers looks up concurrently. If zero, DefaultNumWorkers is
+	// used.
+	NumWorkers int
+
+	// ChainBackend is an optional source of on-chain data. If set, the
+	// funding output of each channel found within the channel graph is
+	// verified on-chain as well, which protects against a poisoned graph.
+	ChainBackend ChainBackend
+
+	// ChannelFilter, if set, restricts the scan to the open channels for
+	// which it returns true.
+	ChannelFilter ChannelFilter
+
+	// ChannelState restricts the scan to the open channels in the given
+	// state. This must be one of ChannelStateAll, ChannelStateActive or
+	// ChannelStateInactive. If empty, ChannelStateAll is used. Closed
+	// channels are never active, so they aren't affected by this.
+	//
+	// NOTE: The channels are selected by their active flag within the
+	// checker, rather than through the ActiveOnly and InactiveOnly
+	// options of ListChannels. The full set of open channels is still
+	// needed to match the allowlist against, and to find the counterparty
+	// of each forward, and selecting them here works just as well with
+	// a ChannelSource that isn't backed by lnd.
+	ChannelState string
+
+	// IncludePrivate determines whether private channels are verified.
+	// As private channels are never announced, they can't be compared
+	// against the public channel graph, so they're skipped by default. If
+	// set, they're verified against their funding output on-chain instead,
+	// which requires a ChainBackend.
+	IncludePrivate bool
+
+	// IncludeClosed determines whether the node's closed channels are
+	// verified as well. A fake channel may have been used to drain the
+	// node before it was closed. As closed channels are pruned from the
+	// channel graph, they're best verified against their funding output
+	// on-chain, so a ChainBackend should be configured along with this.
+	IncludeClosed bool
+
+	// CrossClient is an optional client of a second, independent lnd
+	// node. If set, every channel found within the local channel graph is
+	// also looked up within the graph of the second node, and flagged if
+	// the two graphs disagree on its capacity. This guards against the
+	// local graph itself having been poisoned.
+	CrossClient LndClient
+
+	// ClosedChainBackend is an optional source of on-chain data used only
+	// to verify closed channels, such as a block explorer. This allows
+	// verifying closed channels on-chain without verifying the open ones
+	// as well. If unset, ChainBackend is used for closed channels.
+	ClosedChainBackend ChainBackend
+
+	// CapacityTolerance is the largest difference between the graph's
+	// and our own view of a channel's capacity that is still considered
+	// valid. Any non-zero tolerance may let a fake channel slip through,
+	// so it defaults to zero for strict detection, and is only meant as
+	// an escape hatch for environments that report capacities slightly
+	// differently.
+	CapacityTolerance btcutil.Amount
+
+	// StrictGraph determines how a channel graph that looks implausibly
+	// incomplete is handled, such as a graph holding fewer edges than the
+	// node has public channels, or one missing most of them. As all of
+	// the node's channels would be reported as missing from such a graph,
+	// the scan is aborted with an error if set. Otherwise, the scan
+	// completes with ScanResult.IncompleteGraph set.
+	StrictGraph bool
+
+	// Allowlist, if set, is the set of channels the operator has
+	// confirmed to be valid. These are still verified, but left out of
+	// the invalid channels and the channels missing from the graph, so
+	// triaged false positives aren't reported again by every scan.
+	Allowlist *Allowlist
+
+	// MaxRetries is the number of times an RPC that failed due to a
+	// transient error, such as lnd being briefly unreachable, is retried
+	// before the scan is aborted. Retries are spaced out with exponential
+	// backoff. If zero, failed RPCs aren't retried.
+	MaxRetries int
+
+	// RateLimit is the maximum number of RPCs per second issued to lnd,
+	// shared by all workers and including retries, so a scan doesn't
+	// degrade the node it's auditing. If zero, RPCs aren't rate limited.
+	RateLimit float64
+
+	// Progress, if set, is called each time a channel has been verified,
+	// with the number of channels verified so far, the total number of
+	// channels to verify, and the number of invalid channels found so
+	// far. It's always called from the same goroutine, but must not block
+	// for long as it holds up the scan.
+	Progress func(numChecked, numChannels, numInvalid int)
+
+	// ChannelChecked, if set, is called each time an open channel has
+	// been verified, right before Progress. If the channel was found to
+	// be invalid or couldn't be found within the channel graph, its
+	// details are passed along, otherwise the channel is nil. Channels
+	// missing from the graph have their LookupErr set. Like Progress,
+	// it's always called from the same goroutine.
+	ChannelChecked func(cid lnwire.ShortChannelID,
+		channel *InvalidChannel)
+
+	// OnInvalidChannel, if set, is called with each invalid channel as
+	// soon as it's detected, including invalid closed channels if
+	// IncludeClosed is set. As the peers of invalid channels are only
+	// resolved by ResolvePeers, PeerAlias and PeerAddresses are still
+	// empty. The callback runs on the scanning goroutine, so it must not
+	// block for long as it holds up the scan.
+	OnInvalidChannel func(channel InvalidChannel)
+
+	// OnLossComputed, if set, is called with the loss report once
+	// QuantifyLoss has computed it, right before it's returned. Like
+	// OnInvalidChannel, it runs on the scanning goroutine.
+	OnLossComputed func(report LossReport)
+
+	// ForwardingStartTime, if set, excludes all forwards before this time
+	// from the loss calculation.
+	ForwardingStartTime time.Time
+
+	// ForwardingEndTime, if set, excludes all forwards after this time
+	// from the loss calculation.
+	ForwardingEndTime time.Time
+
+	// RecordForwards, if set, has QuantifyLoss keep every forwarding
+	// event it attributed to an invalid channel, so the loss can be
+	// audited. As a busy channel may have forwarded a great many HTLCs,
+	// they're discarded by default.
+	RecordForwards bool
+}
+
+// Checker checks an lnd node for invalid channels, and quantifies the amount
+// of coins lost due to them.
+type Checker struct {
+	cfg *Config
+
+	// rpcCounter counts the RPCs the Checker issues to lnd.
+	rpcCounter *countingClient
+}
+
+// capacityMatches returns true if the graph's capacity of a channel matches
+// our own view of it within the configured tolerance. Discrepancies within the
+// tolerance are still logged, so they aren't hidden entirely.
+func (c *Checker) capacityMatches(cid lnwire.ShortChannelID, graphCapacity,
+	subjectiveCapacity btcutil.Amount) bool {
+
+	diff := graphCapacity - subjectiveCapacity
+	if diff < 0 {
+		diff = -diff
+	}
+
+	switch {
+	case diff == 0:
+		return true
+
+	case diff <= c.cfg.CapacityTolerance:
+		log.Debugf("Capacity of cid(%v) differs by %v within the "+
+			"tolerance of %v: graph_capacity=%v, "+
+			"subjective_capacity=%v", cid, diff,
+			c.cfg.CapacityTolerance, int64(graphCapacity),
+			int64(subjectiveCapacity))
+		return true
+
+	default:
+		return false
+	}
+}
+
+// invalidChannelFound notifies the OnInvalidChannel callback of an invalid
+// channel, if one was set.
+func (c *Checker) invalidChannelFound(channel InvalidChannel) {
+	if c.cfg.OnInvalidChannel != nil {
+		c.cfg.OnInvalidChannel(channel)
+	}
+}
+
+// NewChecker returns a new Checker backed by the given config.
+func NewChecker(cfg *Config) (*Checker, error) {
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("an lnd client must be provided")
+	}
+
+	switch cfg.GraphMode {
+	case "":
+		cfg.GraphMode = GraphModeDescribe
+
+	case GraphModeDescribe, GraphModeLookup:
+
+	default:
+		return nil, fmt.Errorf("unknown graph mode: %v", cfg.GraphMode)
+	}
+
+	switch cfg.ChannelState {
+	case "":
+		cfg.ChannelState = ChannelStateAll
+
+	case ChannelStateAll, ChannelStateActive, ChannelStateInactive:
+
+	default:
+		return nil, fmt.Errorf("unknown channel state: %v",
+			cfg.ChannelState)
+	}
+
+	if !cfg.ForwardingStartTime.IsZero() &&
+		!cfg.ForwardingEndTime.IsZero() &&
+		!cfg.ForwardingStartTime.Before(cfg.ForwardingEndTime) {
+
+		return nil, fmt.Errorf("forwarding start time %v must be "+
+			"before end time %v", cfg.ForwardingStartTime,
+			cfg.ForwardingEndTime)
+	}
+
+	if cfg.CapacityTolerance < 0 {
+		return nil, fmt.Errorf("capacity tolerance must not be " +
+			"negative")
+	}
+
+	if cfg.IncludePrivate && cfg.ChainBackend == nil {
+		return nil, fmt.Errorf("private channels can only be verified " +
+			"on-chain, a chain backend must be provided")
+	}
+
+	if cfg.NumWorkers == 0 {
+		cfg.NumWorkers = DefaultNumWorkers
+	}
+
+	// We'll count the RPCs right where they leave for lnd, so each retry
+	// counts as one of its own.
+	rpcCounter := newCountingClient(cfg.Client)
+	cfg.Client = rpcCounter
+
+	// Each retry is an RPC of its own, so the rate limit applies to the
+	// client the retries are issued through.
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("rate limit must not be negative")
+	}
+	if cfg.RateLimit > 0 {
+		cfg.Client = newRateLimitClient(cfg.Client, cfg.RateLimit)
+	}
+
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("max retries must not be negative")
+	}
+	if cfg.MaxRetries > 0 {
+		cfg.Client = newRetryClient(cfg.Client, cfg.MaxRetries)
+	}
+
+	// The second node is only queried for the public channels found in
+	// the local graph, so retries are all the protection it needs.
+	if cfg.CrossClient != nil && cfg.MaxRetries > 0 {
+		cfg.CrossClient = newRetryClient(
+			cfg.CrossClient, cfg.MaxRetries,
+		)
+	}
+
+	// The open channels are obtained through the same client as the
+	// rest, so they're subject to the same rate limit and retries.
+	if cfg.ChannelSource == nil {
+		cfg.ChannelSource = NewClientChannelSource(cfg.Client)
+	}
+	if cfg.FallbackChannels != nil {
+		cfg.ChannelSource = newFallbackChannelSource(
+			cfg.ChannelSource, cfg.FallbackChannels,
+		)
+	}
+
+	return &Checker{
+		cfg:        cfg,
+		rpcCounter: rpcCounter,
+	}, nil
+}
+
+// InvalidChannel describes a channel that we believe to be invalid.
+type InvalidChannel struct {
+	// ChanID is the short channel ID of the channel.
+	ChanID lnwire.ShortChannelID
+
+	// RemotePubkey is the hex encoded public key of the channel's remote
+	// peer, taken from our set of open channels.
+	RemotePubkey string
+
+	// ChannelPoint is the funding outpoint of the channel in txid:index
+	// form, taken from our set of open channels, or from the closed
+	// channels for a closed one.
+	ChannelPoint string
+
+	// Initiator is the side that opened the channel. A fake channel our
+	// own node opened points to a different attack than one a peer had
+	// us accept.
+	Initiator Initiator
+
+	// Inactive is true if the open channel is currently inactive, such as
+	// its peer being offline, which makes it harder to act on. Closed
+	// channels are never marked as inactive.
+	Inactive bool
+
+	// PeerAlias is the alias the remote peer advertises within the
+	// channel graph. This is only set once ResolvePeers has been called,
+	// and remains empty if the peer isn't known to the graph.
+	PeerAlias string
+
+	// PeerAddresses is the set of network addresses the remote peer
+	// advertises within the channel graph. Like PeerAlias, this is only
+	// set by ResolvePeers.
+	PeerAddresses []string
+
+	// SubjectiveCapacity is the capacity of the channel as we believe it
+	// to be, taken from our set of open channels.
+	SubjectiveCapacity btcutil.Amount
+
+	// GraphCapacity is the capacity of the channel as recorded within the
+	// channel graph. This is zero if the channel wasn't found.
+	GraphCapacity btcutil.Amount
+
+	// InGraph is true if the channel was found within the channel graph.
+	InGraph bool
+
+	// CapacityMismatch is true if the channel's graph capacity differs
+	// from our own view of it by more than the capacity tolerance.
+	CapacityMismatch bool
+
+	// Private is true if the channel is private. Private channels are
+	// only verified on-chain, so they're never looked up in the graph.
+	Private bool
+
+	// LookupErr is the error returned while looking up the channel within
+	// the channel graph, if it wasn't found or the lookup failed.
+	LookupErr error
+
+	// CheckFailed is true if the lookup of the channel within the channel
+	// graph failed for an operational reason, such as the connection to
+	// lnd being lost, rather than the channel missing from the graph. The
+	// channel is then neither known to be missing nor verified.
+	CheckFailed bool
+
+	// InCrossGraph is true if the channel was found within the channel
+	// graph of the cross-check node. This is only checked if a
+	// CrossClient was configured, and never for private channels.
+	InCrossGraph bool
+
+	// CrossGraphCapacity is the capacity of the channel according to the
+	// channel graph of the cross-check node, if it was found there.
+	CrossGraphCapacity btcutil.Amount
+
+	// CrossMismatch is true if the local channel graph and the one of the
+	// cross-check node disagree on the capacity of the channel, so at
+	// least one of them can't be trusted for it.
+	CrossMismatch bool
+
+	// ChainMismatch is set if the funding output of the channel on-chain
+	// is absent, spent, or doesn't carry the capacity the channel graph
+	// claims. This is only checked if a chain backend was configured.
+	ChainMismatch *ChainMismatch
+
+	// PolicyMismatches is the set of routing policies of the channel
+	// within the channel graph that advertise a larger maximum HTLC size
+	// than the channel's graph capacity allows for. This reveals a
+	// channel whose graph capacity is wrong even if it matches our own
+	// view of the channel.
+	PolicyMismatches []PolicyMismatch
+
+	// Malformed describes why the channel's graph capacity can't possibly
+	// be right, such as a capacity of zero or one beyond the Bitcoin
+	// supply, or why its channel point, either ours or the graph's, can't
+	// be parsed. This reveals corrupt channel data rather than a mismatch
+	// with our own view of the channel. If all of them are plausible, this
+	// is empty.
+	Malformed string
+
+	// Closed is true if the channel has since been closed. Closed
+	// channels are only verified if IncludeClosed is set.
+	Closed bool
+}
+
+// ScanResult is the outcome of verifying the node's channels against the
+// channel graph.
+type ScanResult struct {
+	// InvalidChannels is the set of channels we confirmed to be invalid:
+	// channels found within the channel graph whose capacity doesn't
+	// match our own view of them, whose funding output doesn't match
+	// on-chain, or whose routing policies don't fit their capacity. If
+	// IncludeClosed is set, this includes closed channels that were found
+	// to be invalid.
+	InvalidChannels []InvalidChannel
+
+	// NotInGraph is the set of channels that couldn't be found within the
+	// channel graph. This is expected for channels that are private or
+	// were only recently opened, so these aren't confirmed to be invalid.
+	NotInGraph []InvalidChannel
+
+	// CheckFailed is the set of channels whose lookup within the channel
+	// graph kept failing for an operational reason, such as the
+	// connection to lnd being lost or a missing permission. These are
+	// neither confirmed to be invalid nor known to be missing from the
+	// graph.
+	CheckFailed []InvalidChannel
+
+	// NumChecked is the number of channels that were verified against the
+	// channel graph.
+	NumChecked int
+
+	// NumChannels is the total number of open channels of the node that
+	// were selected for the scan.
+	NumChannels int
+
+	// NumPrivateSkipped is the number of private channels that were left
+	// out of the scan, as IncludePrivate wasn't set.
+	NumPrivateSkipped int
+
+	// NumVerified is the number of open channels whose capacity could be
+	// verified, either against the channel graph or on-chain, regardless
+	// of whether they turned out to be valid. Channels missing from the
+	// graph couldn't be verified.
+	NumVerified int
+
+	// TotalCapacity is the sum of the capacities of all open channels
+	// selected for the scan, from our point of view.
+	TotalCapacity btcutil.Amount
+
+	// VerifiedCapacity is the sum of the capacities of the channels
+	// counted by NumVerified, from our point of view.
+	VerifiedCapacity btcutil.Amount
+
+	// PrivateSkippedCapacity is the sum of the capacities of the private
+	// channels counted by NumPrivateSkipped.
+	PrivateSkippedCapacity btcutil.Amount
+
+	// NumClosedChecked is the number of closed channels that were
+	// verified, if IncludeClosed was set.
+	NumClosedChecked int
+
+	// NumClosedUnverified is the number of closed channels that couldn't
+	// be verified, as they've been pruned from the channel graph and no
+	// chain backend was configured.
+	NumClosedUnverified int
+
+	// NumAllowlisted is the number of channels that were left out of the
+	// invalid channels and the channels missing from the graph, as they
+	// are on the configured allowlist.
+	NumAllowlisted int
+
+	// IncompleteGraph describes why the channel graph looks implausibly
+	// incomplete, such as when the node's graph is only half-synced. If
+	// set, the channels missing from the graph are most likely valid. If
+	// the graph looks complete, this is empty.
+	IncompleteGraph string
+
+	// Collisions is the set of short channel IDs and funding outpoints
+	// shared by several of the open channels selected for the scan,
+	// along with all channels sharing them.
+	Collisions []ChannelCollision
+}
+
+// ScanAbortedError is returned by CheckChannels and FindInvalidChannels if
+// the context was canceled before all channels could be verified.
+type ScanAbortedError struct {
+	// NumChecked is the number of channels that were verified against the
+	// channel graph before the scan was aborted.
+	NumChecked int
+
+	// NumChannels is the total number of channels that were to be
+	// verified.
+	NumChannels int
+
+	// Err is the reason the scan was aborted.
+	Err error
+}
+
+// Error returns a human readable description of the error.
+func (e *ScanAbortedError) Error() string {
+	return fmt.Sprintf("channel verification aborted after checking %d "+
+		"of %d channels: %v", e.NumChecked, e.NumChannels, e.Err)
+}
+
+// FindInvalidChannels compares the node's open channels against the channel
+// graph, and returns all channels for which the two views disagree. Channels
+// that couldn't be found within the channel graph at all aren't included, as
+// they can't be confirmed to be invalid.
+//
+// If the context is canceled while channels are being verified, a
+// *ScanAbortedError is returned along with the invalid channels found so far.
+func (c *Checker) FindInvalidChannels(ctx context.Context) ([]InvalidChannel,
+	error) {
+
+	result, err := c.CheckChannels(ctx)
+	if result == nil {
+		return nil, err
+	}
+
+	return result.InvalidChannels, err
+}
+
+// CheckChannels compares the node's open channels against the channel graph,
+// and returns the full result of the scan.
+//
+// If the context is canceled while channels are being verified, a
+// *ScanAbortedError is returned along with the partial result.
+func (c *Checker) CheckChannels(ctx context.Context) (*ScanResult, error) {
+
+	// In order to check if any invalid channels are accepted we'll compare
+	// the how big we think the channel is (our subjective view) to the
+	// _actual_ size of the channel in lnd's local channel graph. The
+	// channel graph has the correct channel values, but a node could be
+	// tricked into setting the wrong value/outpoint where it stores the
+	// actual channel data.
+	//
+	// So first we'll obtain all the node's current open channels to check
+	// against the channel graph shortly below.
+	selection, err := c.selectChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		subjectiveChanView = selection.subjectiveChanView
+		openChans          = selection.openChans
+		privateChans       = selection.privateChans
+	)
+
+	lookupEdge, numEdges, err := newEdgeLookup(
+		ctx, c.cfg.Client, c.cfg.GraphMode,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query channel graph: %v", err)
+	}
+
+	// Every valid public channel of the node is part of the graph, so a
+	// graph holding fewer edges than that is clearly incomplete.
+	var incompleteGraph string
+	numPublic := len(subjectiveChanView) - len(privateChans)
+	if numEdges >= 0 && numEdges < numPublic {
+		incompleteGraph = fmt.Sprintf("the channel graph holds only %v "+
+			"edges, fewer than the node's %v public channels",
+			numEdges, numPublic)
+		if c.cfg.StrictGraph {
+			return nil, fmt.Errorf("channel graph looks "+
+				"incomplete: %v", incompleteGraph)
+		}
+	}
+
+	// Any private channels that remain will be verified against their
+	// funding output on-chain, using our own view of the channel in place
+	// of the channel graph's.
+	if len(privateChans) > 0 {
+		lookupEdge = newPrivateChanLookup(lookupEdge, privateChans)
+	}
+
+	// If a second node was configured, we'll look up every channel within
+	// its graph as well, so a poisoned local graph doesn't go unnoticed.
+	var crossLookup edgeLookup
+	if c.cfg.CrossClient != nil {
+		crossLookup = newCrossLookup(c.cfg.CrossClient, privateChans)
+	}
+
+	// Now that we have our subjective view of channels, we'll check
+	// against the objective channel graph (properly reject invalid
+	// channels and fully derives their full value from the chain) to see
+	// if things match up. If they don't, then we've accepted a fake
+	// channel.
+	//
+	// Given a channel ID, we'll query the channel graph for the actual
+	// information concerning that channel. To speed things up on large
+	// nodes, these queries are dispatched to a pool of workers.
+	//
+	// If any of the on-chain verifications fail, we'll cancel all
+	// remaining work as we won't be able to deliver a complete result.
+	verifyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		invalidChannels []InvalidChannel
+		notInGraph      []InvalidChannel
+		checkFailed     []InvalidChannel
+		numChecked      int
+		numAllowlisted  int
+		numVerified     int
+		verifiedCap     btcutil.Amount
+		chainErr        error
+		crossErr        error
+	)
+
+	// allowlisted returns true if the given channel, which would be
+	// reported for the given reason, is on the allowlist.
+	allowlisted := func(cid lnwire.ShortChannelID, reason string) bool {
+		entry, ok := c.cfg.Allowlist.lookup(
+			cid, openChans[cid].ChannelPoint,
+		)
+		if ok {
+			log.Infof("Skipping cid(%v) %v, as it's allowlisted "+
+				"as %v", cid, reason, entry)
+			numAllowlisted++
+		}
+
+		return ok
+	}
+	channelChecked := func(cid lnwire.ShortChannelID,
+		channel *InvalidChannel) {
+
+		if c.cfg.ChannelChecked != nil {
+			c.cfg.ChannelChecked(cid, channel)
+		}
+		if c.cfg.Progress != nil {
+			c.cfg.Progress(
+				numChecked, len(subjectiveChanView),
+				len(invalidChannels),
+			)
+		}
+	}
+
+	edgeResults := lookupEdges(
+		verifyCtx, lookupEdge, crossLookup, c.cfg.ChainBackend,
+		subjectiveChanView, c.cfg.NumWorkers,
+	)
+	for result := range edgeResults {
+		// A lookup that failed because the scan was aborted doesn't
+		// tell us anything about the channel, so we won't count it.
+		cid, subjectiveSize := result.cid, result.subjectiveSize
+		graphChan, err := result.edge, result.err
+		aborted := err != nil || result.chainErr != nil ||
+			result.crossErr != nil
+		if aborted && verifyCtx.Err() != nil {
+			continue
+		}
+
+		if result.chainErr != nil {
+			if chainErr == nil {
+				chainErr = fmt.Errorf("unable to verify cid(%v) "+
+					"on-chain: %v", cid, result.chainErr)
+				cancel()
+			}
+			continue
+		}
+
+		// Likewise, a cross-check that failed for any other reason
+		// than the channel missing from the second graph would leave
+		// us with an incomplete result.
+		crossFailed := result.crossErr != nil &&
+			!isEdgeNotFound(result.crossErr)
+		if crossFailed {
+			if crossErr == nil {
+				crossErr = fmt.Errorf("unable to cross-check "+
+					"cid(%v): %v", cid, result.crossErr)
+				cancel()
+			}
+			continue
+		}
+		numChecked++
+
+		_, private := privateChans[cid]
+		remotePubkey := openChans[cid].RemotePubkey
+		channelPoint := openChans[cid].ChannelPoint
+		initiator := channelInitiator(openChans[cid])
+		inactive := !openChans[cid].Active
+
+		// A lookup that failed for any other reason than the channel
+		// missing from the graph tells us nothing about the channel,
+		// so we'll report it as unchecked rather than missing. Any
+		// transient error was already retried by the client.
+		if err != nil && !isEdgeNotFound(err) {
+			if allowlisted(cid, "whose lookup failed") {
+				channelChecked(cid, nil)
+				continue
+			}
+
+			failedChannel := InvalidChannel{
+				ChanID:             cid,
+				RemotePubkey:       remotePubkey,
+				ChannelPoint:       channelPoint,
+				Initiator:          initiator,
+				Inactive:           inactive,
+				SubjectiveCapacity: subjectiveSize,
+				LookupErr:          err,
+				CheckFailed:        true,
+			}
+			checkFailed = append(checkFailed, failedChannel)
+			channelChecked(cid, &failedChannel)
+			continue
+		}
+
+		if err != nil {
+			// If we can't find the channel in the channel graph,
+			// then it may be invalid, but it may just as well be
+			// private or too fresh to have been announced yet. As
+			// we have nothing to compare it against, we'll report
+			// it separately from the confirmed invalid channels.
+			if allowlisted(cid, "missing from the graph") {
+				channelChecked(cid, nil)
+				continue
+			}
+
+			missingChannel := InvalidChannel{
+				ChanID:             cid,
+				RemotePubkey:       remotePubkey,
+				ChannelPoint:       channelPoint,
+				Initiator:          initiator,
+				Inactive:           inactive,
+				SubjectiveCapacity: subjectiveSize,
+				LookupErr:          err,
+			}
+			notInGraph = append(notInGraph, missingChannel)
+			channelChecked(cid, &missingChannel)
+			continue
+		}
+
+		numVerified++
+		verifiedCap += subjectiveSize
+
+		// This is where we hold our breath...
+		//
+		// If size of the channel from the PoV of the channel graph
+		// doesn't match how big _we_ think the channel is, then it's
+		// invalid.
+		//
+		// Similarly, if we verified the channel on-chain and the
+		// funding output doesn't match the graph, then the graph
+		// itself can't be trusted for this channel. For private
+		// channels, this is the only check we're able to carry out.
+		//
+		// Finally, the routing policies both ends of a public channel
+		// advertise must fit within the graph's capacity. If they
+		// don't, then the graph is internally inconsistent, which
+		// catches a channel whose capacity is wrong in both our view
+		// and the graph's. So does a graph capacity that no channel
+		// could ever have.
+		var (
+			policyMismatches []PolicyMismatch
+			malformed        string
+			checkedChannel   *InvalidChannel
+		)
+		if !private {
+			policyMismatches = findPolicyMismatches(graphChan)
+			malformed = joinMalformed(
+				malformedCapacity(
+					btcutil.Amount(graphChan.Capacity),
+				),
+				malformedChannelPoint(
+					"graph", graphChan.ChanPoint,
+				),
+			)
+		}
+		malformed = joinMalformed(
+			malformed, malformedChannelPoint("local", channelPoint),
+		)
+		capacityMismatch := !c.capacityMatches(
+			cid, btcutil.Amount(graphChan.Capacity), subjectiveSize,
+		)
+
+		// If we cross-checked the channel, the second graph must agree
+		// with ours. Otherwise, ours may have been poisoned to match
+		// our view of the channel, or the other one to conflict with
+		// it, either of which the operator needs to know about.
+		var (
+			inCrossGraph  bool
+			crossCapacity btcutil.Amount
+			crossMismatch bool
+		)
+		if crossLookup != nil && !private {
+			if result.crossErr == nil {
+				inCrossGraph = true
+				crossCapacity = btcutil.Amount(
+					result.crossEdge.Capacity,
+				)
+				crossMismatch = !c.graphsAgree(
+					cid, btcutil.Amount(graphChan.Capacity),
+					crossCapacity,
+				)
+			} else {
+				log.Warnf("Unable to cross-check cid(%v), as "+
+					"it's missing from the second graph",
+					cid)
+			}
+		}
+
+		invalid := capacityMismatch || crossMismatch ||
+			result.chainMismatch != nil ||
+			len(policyMismatches) > 0 || malformed != ""
+		if invalid && !allowlisted(cid, "flagged as invalid") {
+
+			invalidChannel := InvalidChannel{
+				ChanID:             cid,
+				RemotePubkey:       remotePubkey,
+				ChannelPoint:       channelPoint,
+				Initiator:          initiator,
+				Inactive:           inactive,
+				SubjectiveCapacity: subjectiveSize,
+				InGraph:            !private,
+				CapacityMismatch:   capacityMismatch,
+				InCrossGraph:       inCrossGraph,
+				CrossGraphCapacity: crossCapacity,
+				CrossMismatch:      crossMismatch,
+				Private:            private,
+				ChainMismatch:      result.chainMismatch,
+				PolicyMismatches:   policyMismatches,
+				Malformed:          malformed,
+			}
+			if !private {
+				invalidChannel.GraphCapacity = btcutil.Amount(
+					graphChan.Capacity,
+				)
+			}
+
+			invalidChannels = append(invalidChannels, invalidChannel)
+			checkedChannel = &invalidChannel
+			c.invalidChannelFound(invalidChannel)
+		}
+
+		channelChecked(cid, checkedChannel)
+	}
+
+	if chainErr != nil {
+		return nil, chainErr
+	}
+	if crossErr != nil {
+		return nil, crossErr
+	}
+
+	// Even if the graph is large enough, it may still be missing most of
+	// the node's channels if it's only partially synced.
+	if incompleteGraph == "" && ctx.Err() == nil &&
+		numPublic >= incompleteGraphMinChannels &&
+		float64(len(notInGraph)) >
+			incompleteGraphMissingRatio*float64(numPublic) {
+
+		incompleteGraph = fmt.Sprintf("%v of the node's %v public "+
+			"channels are missing from the channel graph",
+			len(notInGraph), numPublic)
+		if c.cfg.StrictGraph {
+			return nil, fmt.Errorf("channel graph looks "+
+				"incomplete: %v", incompleteGraph)
+		}
+	}
+	if incompleteGraph != "" {
+		log.Warnf("Channel graph looks incomplete: %v",
+			incompleteGraph)
+	}
+
+	result := &ScanResult{
+		InvalidChannels:   invalidChannels,
+		NotInGraph:        notInGraph,
+		CheckFailed:       checkFailed,
+		NumChecked:        numChecked,
+		NumChannels:       len(subjectiveChanView),
+		NumPrivateSkipped: selection.numPrivateSkipped,
+		NumAllowlisted:    numAllowlisted,
+		NumVerified:       numVerified,
+		VerifiedCapacity:  verifiedCap,
+		IncompleteGraph:   incompleteGraph,
+		Collisions:        selection.collisions,
+
+		PrivateSkippedCapacity: selection.privateSkippedCapacity,
+	}
+	for _, capacity := range subjectiveChanView {
+		result.TotalCapacity += capacity
+	}
+
+	// With the open channels verified, we'll move on to the closed ones
+	// if we were asked to. We'll skip these if the scan was already
+	// aborted, and report the partial result below.
+	if c.cfg.IncludeClosed && ctx.Err() == nil {
+		closed, err := c.checkClosedChannels(ctx, openChans)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if closed != nil {
+			result.InvalidChannels = append(
+				result.InvalidChannels,
+				closed.invalidChannels...,
+			)
+			result.NumClosedChecked = closed.numChecked
+			result.NumClosedUnverified = closed.numUnverified
+			result.NumAllowlisted += closed.numAllowlisted
+
+			for entry := range closed.allowlistMatches {
+				selection.allowlistMatches[entry] = struct{}{}
+			}
+		}
+	}
+
+	// An allowlist entry that doesn't match any of the node's channels is
+	// most likely a typo, which would leave the channel the operator
+	// meant to allowlist reported.
+	if ctx.Err() == nil {
+		unmatched := c.cfg.Allowlist.unmatched(
+			selection.allowlistMatches,
+		)
+		kind := "open channel"
+		if c.cfg.IncludeClosed {
+			kind = "open or closed channel"
+		}
+		for _, entry := range unmatched {
+			log.Warnf("Allowlist entry %v doesn't match any %v of "+
+				"the node", entry, kind)
+		}
+	}
+
+	// If the context was canceled while we were verifying channels, then
+	// our results are incomplete. We'll still hand back what we found so
+	// far, so the caller can report it.
+	if err := ctx.Err(); err != nil {
+		return result, &ScanAbortedError{
+			NumChecked:  numChecked,
+			NumChannels: len(subjectiveChanView),
+			Err:         err,
+		}
+	}
+
+	return result, nil
+}
+
+// channelSelection is the set of open channels of the node that were selected
+// for a scan.
+type channelSelection struct {
+	// subjectiveChanView maps each selected channel to its capacity from
+	// our point of view.
+	subjectiveChanView map[lnwire.ShortChannelID]btcutil.Amount
+
+	// openChans maps each selected channel to the node's view of it.
+	openChans map[lnwire.ShortChannelID]*lnrpc.Channel
+
+	// privateChans is the subset of selected channels that are private,
+	// and thus only verified on-chain.
+	privateChans map[lnwire.ShortChannelID]*lnrpc.Channel
+
+	// numPrivateSkipped is the number of private channels that were left
+	// out, as IncludePrivate wasn't set.
+	numPrivateSkipped int
+
+	// privateSkippedCapacity is the sum of the capacities of the private
+	// channels that were left out.
+	privateSkippedCapacity btcutil.Amount
+
+	// allowlistMatches is the set of allowlist entries matching any of
+	// the node's open channels, whether selected or not.
+	allowlistMatches map[string]struct{}
+
+	// collisions is the set of short channel IDs and funding outpoints
+	// shared by several of the selected channels, including private ones
+	// that were left out.
+	collisions []ChannelCollision
+}
+
+// selectChannels obtains the node's open channels, and selects the ones that
+// should be scanned according to the configured channel filter and the
+// handling of private channels.
+func (c *Checker) selectChannels(ctx context.Context) (*channelSelection,
+	error) {
+
+	openChannels, err := c.cfg.ChannelSource.OpenChannels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain channels: %v", err)
+	}
+
+	// Now that we have our channels, we'll now construct our subjective
+	// view of a channels existence as well as its total capacity.
+	//
+	// Private channels are never announced, so we won't be able to verify
+	// them against the public channel graph. Unless we were asked to
+	// verify them on-chain, we'll leave them out of the scan entirely.
+	selection := &channelSelection{
+		subjectiveChanView: make(
+			map[lnwire.ShortChannelID]btcutil.Amount,
+		),
+		openChans: make(
+			map[lnwire.ShortChannelID]*lnrpc.Channel,
+		),
+		privateChans: make(
+			map[lnwire.ShortChannelID]*lnrpc.Channel,
+		),
+		allowlistMatches: make(map[string]struct{}),
+	}
+	detector := newCollisionDetector()
+	for _, channel := range openChannels {
+		// We'll note which allowlist entries match any of the node's
+		// channels before filtering, so only entries that don't match
+		// any channel at all are reported as unknown.
+		entry, ok := c.cfg.Allowlist.lookup(
+			lnwire.NewShortChanIDFromInt(channel.ChanId),
+			channel.ChannelPoint,
+		)
+		if ok {
+			selection.allowlistMatches[entry] = struct{}{}
+		}
+
+		if c.cfg.ChannelFilter != nil && !c.cfg.ChannelFilter(channel) {
+			continue
+		}
+		if !c.matchesChannelState(channel) {
+			continue
+		}
+
+		cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+
+		// As the selection is keyed by short channel ID, a channel
+		// sharing its ID with another one would silently replace it,
+		// so we'll note every such collision before it does.
+		detector.add(channel)
+
+		if channel.Private {
+			if !c.cfg.IncludePrivate {
+				log.Debugf("Skipping private channel cid(%v)",
+					cid)
+				selection.numPrivateSkipped++
+				selection.privateSkippedCapacity +=
+					btcutil.Amount(channel.Capacity)
+				continue
+			}
+
+			selection.privateChans[cid] = channel
+		}
+
+		selection.subjectiveChanView[cid] = btcutil.Amount(
+			channel.Capacity,
+		)
+		selection.openChans[cid] = channel
+	}
+	selection.collisions = detector.collisions()
+
+	return selection, nil
+}
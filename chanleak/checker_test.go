@@ -0,0 +1,298 @@
+package chanleak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// TestCheckChannelsFakeClient makes sure a full scan can be driven by the fake
+// client, flagging the channel whose capacity the graph disagrees with.
+func TestCheckChannelsFakeClient(t *testing.T) {
+	client := &fakeClient{
+		channels: []*lnrpc.Channel{
+			fakeChannel(1, 1000000),
+			fakeChannel(2, 16000000),
+		},
+		edges: []*lnrpc.ChannelEdge{
+			fakeEdge(1, 1000000),
+			fakeEdge(2, 20000),
+		},
+	}
+
+	checker, err := NewChecker(&Config{Client: client})
+	if err != nil {
+		t.Fatalf("unable to create checker: %v", err)
+	}
+	result, err := checker.CheckChannels(context.Background())
+	if err != nil {
+		t.Fatalf("unable to check channels: %v", err)
+	}
+
+	if result.NumChecked != 2 {
+		t.Fatalf("expected 2 channels to be checked, got %v",
+			result.NumChecked)
+	}
+	ids := chanIDs(result.InvalidChannels)
+	if !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Fatalf("expected cid 2 to be invalid, got %v", ids)
+	}
+	invalid := result.InvalidChannels[0]
+	if !invalid.CapacityMismatch || invalid.GraphCapacity != 20000 ||
+		invalid.SubjectiveCapacity != 16000000 {
+
+		t.Fatalf("unexpected invalid channel: %+v", invalid)
+	}
+}
+
+// invalidSummary is the short channel ID of an invalid channel, along with the
+// reasons it was flagged.
+type invalidSummary struct {
+	chanID           uint64
+	capacityMismatch bool
+	chainMismatch    bool
+	malformed        bool
+}
+
+// summarizeInvalid returns the summary of each of the given invalid channels,
+// sorted by short channel ID.
+func summarizeInvalid(channels []InvalidChannel) []invalidSummary {
+	summaries := make([]invalidSummary, 0, len(channels))
+	for _, id := range chanIDs(channels) {
+		for _, channel := range channels {
+			if channel.ChanID.ToUint64() != id {
+				continue
+			}
+			summaries = append(summaries, invalidSummary{
+				chanID:           id,
+				capacityMismatch: channel.CapacityMismatch,
+				chainMismatch:    channel.ChainMismatch != nil,
+				malformed:        channel.Malformed != "",
+			})
+		}
+	}
+
+	return summaries
+}
+
+// TestCheckChannels asserts the exact set of channels a scan flags as invalid
+// or missing from the graph, for each way our view of a channel may relate to
+// the graph's.
+func TestCheckChannels(t *testing.T) {
+	tests := []struct {
+		name string
+
+		channels []*lnrpc.Channel
+		edges    []*lnrpc.ChannelEdge
+
+		// outputs, if set, is the on-chain state of the funding
+		// outputs, which are then verified against it.
+		outputs map[wire.OutPoint]fakeOutput
+
+		includePrivate bool
+
+		invalid           []invalidSummary
+		notInGraph        []uint64
+		numChecked        int
+		numPrivateSkipped int
+	}{
+		{
+			name: "exact match",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				fakeChannel(2, 250000),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+				fakeEdge(2, 250000),
+			},
+			invalid:    []invalidSummary{},
+			notInGraph: []uint64{},
+			numChecked: 2,
+		},
+		{
+			name: "capacity mismatch",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				fakeChannel(2, 16000000),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+				fakeEdge(2, 20000),
+			},
+			invalid: []invalidSummary{
+				{chanID: 2, capacityMismatch: true},
+			},
+			notInGraph: []uint64{},
+			numChecked: 2,
+		},
+		{
+			name: "missing from graph",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				fakeChannel(2, 16000000),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+			},
+			invalid:    []invalidSummary{},
+			notInGraph: []uint64{2},
+			numChecked: 2,
+		},
+		{
+			name: "private channel skipped",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				privateChannel(fakeChannel(2, 16000000)),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+			},
+			invalid:           []invalidSummary{},
+			notInGraph:        []uint64{},
+			numChecked:        1,
+			numPrivateSkipped: 1,
+		},
+		{
+			name: "private channel verified on-chain",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				privateChannel(fakeChannel(2, 16000000)),
+				privateChannel(fakeChannel(3, 500000)),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+			},
+			outputs: map[wire.OutPoint]fakeOutput{
+				fakeOutPoint(1): {1000000, OutputUnspent},
+				fakeOutPoint(2): {20000, OutputUnspent},
+				fakeOutPoint(3): {500000, OutputUnspent},
+			},
+			includePrivate: true,
+			invalid: []invalidSummary{
+				{chanID: 2, chainMismatch: true},
+			},
+			notInGraph: []uint64{},
+			numChecked: 3,
+		},
+		{
+			name: "zero capacity",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				fakeChannel(2, 0),
+				fakeChannel(3, 500000),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+				fakeEdge(2, 0),
+				fakeEdge(3, 0),
+			},
+			invalid: []invalidSummary{
+				{chanID: 2, malformed: true},
+				{
+					chanID:           3,
+					capacityMismatch: true,
+					malformed:        true,
+				},
+			},
+			notInGraph: []uint64{},
+			numChecked: 3,
+		},
+		{
+			name: "malformed channel point",
+			channels: []*lnrpc.Channel{
+				fakeChannel(1, 1000000),
+				withChanPoint(
+					fakeChannel(2, 1000000), "garbage",
+				),
+				fakeChannel(3, 1000000),
+			},
+			edges: []*lnrpc.ChannelEdge{
+				fakeEdge(1, 1000000),
+				fakeEdge(2, 1000000),
+				{
+					ChannelId: 3,
+					ChanPoint: fakeChanPoint(3) + "0",
+					Capacity:  1000000,
+				},
+			},
+			invalid: []invalidSummary{
+				{chanID: 2, malformed: true},
+				{chanID: 3, malformed: true},
+			},
+			notInGraph: []uint64{},
+			numChecked: 3,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{
+				Client: &fakeClient{
+					channels: test.channels,
+					edges:    test.edges,
+				},
+				IncludePrivate: test.includePrivate,
+			}
+			if test.outputs != nil {
+				cfg.ChainBackend = &fakeChainBackend{
+					outputs: test.outputs,
+				}
+			}
+
+			checker, err := NewChecker(cfg)
+			if err != nil {
+				t.Fatalf("unable to create checker: %v", err)
+			}
+			result, err := checker.CheckChannels(
+				context.Background(),
+			)
+			if err != nil {
+				t.Fatalf("unable to check channels: %v", err)
+			}
+
+			invalid := summarizeInvalid(result.InvalidChannels)
+			if !reflect.DeepEqual(invalid, test.invalid) {
+				t.Fatalf("expected invalid channels %+v, "+
+					"got %+v", test.invalid, invalid)
+			}
+			notInGraph := chanIDs(result.NotInGraph)
+			if !reflect.DeepEqual(notInGraph, test.notInGraph) {
+				t.Fatalf("expected channels %v missing from "+
+					"the graph, got %v", test.notInGraph,
+					notInGraph)
+			}
+			if len(result.CheckFailed) != 0 {
+				t.Fatalf("expected no failed checks, got %v",
+					chanIDs(result.CheckFailed))
+			}
+			if result.NumChecked != test.numChecked {
+				t.Fatalf("expected %v channels to be checked, "+
+					"got %v", test.numChecked,
+					result.NumChecked)
+			}
+			if result.NumPrivateSkipped != test.numPrivateSkipped {
+				t.Fatalf("expected %v private channels to be "+
+					"skipped, got %v",
+					test.numPrivateSkipped,
+					result.NumPrivateSkipped)
+			}
+		})
+	}
+}
+
+// privateChannel marks the given channel as private.
+func privateChannel(channel *lnrpc.Channel) *lnrpc.Channel {
+	channel.Private = true
+	return channel
+}
+
+// withChanPoint replaces the funding outpoint of the given channel.
+func withChanPoint(channel *lnrpc.Channel, chanPoint string) *lnrpc.Channel {
+	channel.ChannelPoint = chanPoint
+	return channel
+}
@@ -0,0 +1,224 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// closedScanResult is the outcome of verifying the node's closed channels.
+type closedScanResult struct {
+	// invalidChannels is the set of closed channels we confirmed to be
+	// invalid.
+	invalidChannels []InvalidChannel
+
+	// numChecked is the number of closed channels that were verified.
+	numChecked int
+
+	// numUnverified is the number of closed channels that couldn't be
+	// verified, as they've already been pruned from the channel graph and
+	// no chain backend was configured.
+	numUnverified int
+
+	// numAllowlisted is the number of invalid closed channels that were
+	// left out, as they're on the allowlist.
+	numAllowlisted int
+
+	// allowlistMatches is the set of allowlist entries matching any of
+	// the node's closed channels.
+	allowlistMatches map[string]struct{}
+}
+
+// checkClosedChannels verifies the capacity the node recorded for each of its
+// closed channels. A channel that is closed by now may still have been used to
+// drain the node while it was open, so it's just as relevant to the loss
+// calculation as an open one.
+//
+// Closed channels are pruned from the channel graph, so if a chain backend is
+// configured for them, we'll verify each channel against its funding output
+// on-chain instead. Otherwise, we'll fall back to the channel graph, which
+// only helps for channels that were closed recently.
+//
+// Closed channels that are also among the given open channels, which happens
+// if a channel is closed while the scan is running, have already been verified
+// and are skipped.
+func (c *Checker) checkClosedChannels(ctx context.Context,
+	openChans map[lnwire.ShortChannelID]*lnrpc.Channel) (*closedScanResult,
+	error) {
+
+	closedResp, err := c.cfg.Client.ClosedChannels(
+		ctx, &lnrpc.ClosedChannelsRequest{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain closed channels: %v",
+			err)
+	}
+
+	result := &closedScanResult{
+		allowlistMatches: make(map[string]struct{}),
+	}
+	for _, summary := range closedResp.Channels {
+		// Channels whose funding transaction never confirmed were
+		// never usable for forwards, so there's nothing to verify.
+		switch summary.CloseType {
+		case lnrpc.ChannelCloseSummary_FUNDING_CANCELED,
+			lnrpc.ChannelCloseSummary_ABANDONED:
+
+			continue
+		}
+		if summary.ChanId == 0 {
+			continue
+		}
+
+		cid := lnwire.NewShortChanIDFromInt(summary.ChanId)
+		allowEntry, allowlisted := c.cfg.Allowlist.lookup(
+			cid, summary.ChannelPoint,
+		)
+		if allowlisted {
+			result.allowlistMatches[allowEntry] = struct{}{}
+		}
+
+		if _, ok := openChans[cid]; ok {
+			log.Debugf("Skipping closed cid(%v), it was already "+
+				"verified as open", cid)
+			continue
+		}
+
+		// We'll apply the channel filter to the closed channel as if
+		// it were still open, so the same selectors work for both.
+		if c.cfg.ChannelFilter != nil {
+			channel := &lnrpc.Channel{
+				RemotePubkey: summary.RemotePubkey,
+				ChannelPoint: summary.ChannelPoint,
+				ChanId:       summary.ChanId,
+				Capacity:     summary.Capacity,
+			}
+			if !c.cfg.ChannelFilter(channel) {
+				continue
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		invalidChannel, verified, err := c.verifyClosedChannel(
+			ctx, summary,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			result.numUnverified++
+			continue
+		}
+
+		result.numChecked++
+		if invalidChannel != nil && allowlisted {
+			log.Infof("Skipping closed cid(%v) flagged as invalid, "+
+				"as it's allowlisted as %v", cid, allowEntry)
+			result.numAllowlisted++
+			continue
+		}
+		if invalidChannel != nil {
+			result.invalidChannels = append(
+				result.invalidChannels, *invalidChannel,
+			)
+			c.invalidChannelFound(*invalidChannel)
+		}
+	}
+
+	return result, nil
+}
+
+// verifyClosedChannel verifies the capacity of a single closed channel. If the
+// channel is invalid, it's returned. The boolean return value reports whether
+// the channel could be verified at all.
+func (c *Checker) verifyClosedChannel(ctx context.Context,
+	summary *lnrpc.ChannelCloseSummary) (*InvalidChannel, bool, error) {
+
+	cid := lnwire.NewShortChanIDFromInt(summary.ChanId)
+	subjectiveSize := btcutil.Amount(summary.Capacity)
+
+	invalidChannel := &InvalidChannel{
+		ChanID:             cid,
+		RemotePubkey:       summary.RemotePubkey,
+		ChannelPoint:       summary.ChannelPoint,
+		SubjectiveCapacity: subjectiveSize,
+		Closed:             true,
+	}
+
+	// If we have a chain backend, then we'll check that the funding
+	// output exists and carries the capacity we recorded. Unlike for open
+	// channels, we expect the output to have been spent by now.
+	chainBackend := c.cfg.ClosedChainBackend
+	if chainBackend == nil {
+		chainBackend = c.cfg.ChainBackend
+	}
+	if chainBackend != nil {
+		op, err := ParseOutPoint(summary.ChannelPoint)
+		if err != nil {
+			invalidChannel.Malformed = malformedChannelPoint(
+				"local", summary.ChannelPoint,
+			)
+			return invalidChannel, true, nil
+		}
+
+		value, state, err := chainBackend.FetchOutput(ctx, op)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to verify "+
+				"closed cid(%v) on-chain: %v", cid, err)
+		}
+
+		log.Debugf("Closed cid(%v) funding output %v is %v with "+
+			"value %v", cid, op, state, value)
+
+		// The funding output of a closed channel is expected to be
+		// spent, so a backend that can't look up spent outputs
+		// can't verify the channel at all.
+		if state == OutputNotIndexed {
+			return nil, false, nil
+		}
+
+		if state != OutputNotFound && value == subjectiveSize {
+			return nil, true, nil
+		}
+
+		invalidChannel.ChainMismatch = &ChainMismatch{
+			FundingOutpoint: *op,
+			State:           state,
+			ChainValue:      value,
+		}
+		return invalidChannel, true, nil
+	}
+
+	// Otherwise, the channel graph is all we have. Most closed channels
+	// will have been pruned from it already, in which case we can't tell
+	// whether they were valid.
+	edge, err := c.cfg.Client.GetChanInfo(
+		ctx, &lnrpc.ChanInfoRequest{ChanId: summary.ChanId},
+	)
+	if err != nil {
+		log.Debugf("Unable to obtain graph channel for closed "+
+			"cid(%v): %v", cid, err)
+		return nil, false, nil
+	}
+
+	graphCapacity := btcutil.Amount(edge.Capacity)
+	malformed := malformedCapacity(graphCapacity)
+	capacityMismatch := !c.capacityMatches(
+		cid, graphCapacity, subjectiveSize,
+	)
+	if malformed == "" && !capacityMismatch {
+		return nil, true, nil
+	}
+
+	invalidChannel.InGraph = true
+	invalidChannel.GraphCapacity = graphCapacity
+	invalidChannel.CapacityMismatch = capacityMismatch
+	invalidChannel.Malformed = malformed
+	return invalidChannel, true, nil
+}
@@ -0,0 +1,133 @@
+package chanleak
+
+import (
+	"sort"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// CollisionKind is what the channels of a collision share.
+type CollisionKind string
+
+const (
+	// CollisionChanID means the channels share the same short channel ID.
+	CollisionChanID CollisionKind = "chanid"
+
+	// CollisionOutpoint means the channels share the same funding
+	// outpoint.
+	CollisionOutpoint CollisionKind = "outpoint"
+)
+
+// ChannelCollision describes several of the node's open channels that share
+// the same short channel ID or funding outpoint. No two genuine channels ever
+// do, so a collision hints at a peer having poisoned the node's view of its
+// channels. As the scan keys channels by their short channel ID, only one of
+// the channels sharing one is verified against the channel graph.
+type ChannelCollision struct {
+	// Kind is what the colliding channels share.
+	Kind CollisionKind
+
+	// Key is the shared short channel ID in block:tx:output form, or the
+	// shared funding outpoint.
+	Key string
+
+	// Channels is the set of all channels sharing the key, in the order
+	// the node listed them.
+	Channels []CollidingChannel
+}
+
+// CollidingChannel is a single channel of a collision, as listed by the node.
+type CollidingChannel struct {
+	// ChanID is the short channel ID of the channel.
+	ChanID lnwire.ShortChannelID
+
+	// ChannelPoint is the funding outpoint of the channel.
+	ChannelPoint string
+
+	// RemotePubkey is the hex encoded public key of the channel's remote
+	// peer.
+	RemotePubkey string
+
+	// Capacity is the capacity of the channel from our point of view.
+	Capacity btcutil.Amount
+}
+
+// collisionDetector tracks the channels listed by the node by their short
+// channel ID and funding outpoint, so the ones sharing either can be reported.
+type collisionDetector struct {
+	byChanID   map[lnwire.ShortChannelID][]*lnrpc.Channel
+	byOutpoint map[string][]*lnrpc.Channel
+}
+
+// newCollisionDetector returns a new, empty collision detector.
+func newCollisionDetector() *collisionDetector {
+	return &collisionDetector{
+		byChanID:   make(map[lnwire.ShortChannelID][]*lnrpc.Channel),
+		byOutpoint: make(map[string][]*lnrpc.Channel),
+	}
+}
+
+// add records a channel listed by the node.
+func (d *collisionDetector) add(channel *lnrpc.Channel) {
+	cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+	d.byChanID[cid] = append(d.byChanID[cid], channel)
+	d.byOutpoint[channel.ChannelPoint] = append(
+		d.byOutpoint[channel.ChannelPoint], channel,
+	)
+}
+
+// collisions returns every short channel ID and funding outpoint shared by
+// more than one of the recorded channels. They're sorted by kind and key, so
+// they're reported in the same order across runs.
+func (d *collisionDetector) collisions() []ChannelCollision {
+	var collisions []ChannelCollision
+	for cid, channels := range d.byChanID {
+		if len(channels) > 1 {
+			collisions = append(collisions, newCollision(
+				CollisionChanID, cid.String(), channels,
+			))
+		}
+	}
+	for outpoint, channels := range d.byOutpoint {
+		if len(channels) > 1 {
+			collisions = append(collisions, newCollision(
+				CollisionOutpoint, outpoint, channels,
+			))
+		}
+	}
+
+	sort.Slice(collisions, func(i, j int) bool {
+		if collisions[i].Kind != collisions[j].Kind {
+			return collisions[i].Kind < collisions[j].Kind
+		}
+
+		return collisions[i].Key < collisions[j].Key
+	})
+
+	return collisions
+}
+
+// newCollision returns the collision of the given channels sharing the given
+// key.
+func newCollision(kind CollisionKind, key string,
+	channels []*lnrpc.Channel) ChannelCollision {
+
+	collision := ChannelCollision{
+		Kind: kind,
+		Key:  key,
+	}
+	for _, channel := range channels {
+		collision.Channels = append(collision.Channels, CollidingChannel{
+			ChanID: lnwire.NewShortChanIDFromInt(
+				channel.ChanId,
+			),
+			ChannelPoint: channel.ChannelPoint,
+			RemotePubkey: channel.RemotePubkey,
+			Capacity:     btcutil.Amount(channel.Capacity),
+		})
+	}
+
+	return collision
+}
@@ -0,0 +1,83 @@
+package chanleak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestCheckChannelsCollisions makes sure channels sharing a short channel ID
+// or funding outpoint are all reported as a collision, rather than one of them
+// silently replacing the other.
+func TestCheckChannelsCollisions(t *testing.T) {
+	// Channel 2 is listed twice with different funding outpoints, while
+	// channels 3 and 4 share the funding outpoint of channel 3.
+	duplicate := fakeChannel(2, 16000000)
+	duplicate.ChannelPoint = fakeChanPoint(7)
+	sharedOutpoint := fakeChannel(4, 1000000)
+	sharedOutpoint.ChannelPoint = fakeChanPoint(3)
+
+	client := &fakeClient{
+		channels: []*lnrpc.Channel{
+			fakeChannel(1, 1000000),
+			fakeChannel(2, 1000000),
+			duplicate,
+			fakeChannel(3, 1000000),
+			sharedOutpoint,
+		},
+		edges: []*lnrpc.ChannelEdge{
+			fakeEdge(1, 1000000),
+			fakeEdge(2, 1000000),
+			fakeEdge(3, 1000000),
+			fakeEdge(4, 1000000),
+		},
+	}
+
+	checker, err := NewChecker(&Config{Client: client})
+	if err != nil {
+		t.Fatalf("unable to create checker: %v", err)
+	}
+	result, err := checker.CheckChannels(context.Background())
+	if err != nil {
+		t.Fatalf("unable to check channels: %v", err)
+	}
+
+	colliding := func(channel *lnrpc.Channel) CollidingChannel {
+		return CollidingChannel{
+			ChanID: lnwire.NewShortChanIDFromInt(
+				channel.ChanId,
+			),
+			ChannelPoint: channel.ChannelPoint,
+			RemotePubkey: channel.RemotePubkey,
+			Capacity:     1000000,
+		}
+	}
+	duplicateCollision := colliding(duplicate)
+	duplicateCollision.Capacity = 16000000
+
+	expected := []ChannelCollision{
+		{
+			Kind: CollisionChanID,
+			Key:  lnwire.NewShortChanIDFromInt(2).String(),
+			Channels: []CollidingChannel{
+				colliding(client.channels[1]),
+				duplicateCollision,
+			},
+		},
+		{
+			Kind: CollisionOutpoint,
+			Key:  fakeChanPoint(3),
+			Channels: []CollidingChannel{
+				colliding(client.channels[3]),
+				colliding(sharedOutpoint),
+			},
+		},
+	}
+	if !reflect.DeepEqual(result.Collisions, expected) {
+		t.Fatalf("expected collisions %+v, got %+v", expected,
+			result.Collisions)
+	}
+}
@@ -0,0 +1,47 @@
+package chanleak
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// newCrossLookup returns the edgeLookup querying the channel graph of the
+// cross-check node for each public channel individually. The private channels
+// are never announced, so they're served from our own view instead, just like
+// for the local graph.
+func newCrossLookup(crossClient LndClient,
+	privateChans map[lnwire.ShortChannelID]*lnrpc.Channel) edgeLookup {
+
+	crossLookup := newChanInfoLookup(crossClient)
+	if len(privateChans) > 0 {
+		crossLookup = newPrivateChanLookup(crossLookup, privateChans)
+	}
+
+	return crossLookup
+}
+
+// graphsAgree returns true if the local channel graph and the one of the
+// cross-check node agree on the capacity of a channel within the configured
+// tolerance. If they don't, at least one of the graphs can't be trusted for
+// the channel, whichever way our own view of it points.
+func (c *Checker) graphsAgree(cid lnwire.ShortChannelID, graphCapacity,
+	crossCapacity btcutil.Amount) bool {
+
+	diff := absAmount(graphCapacity - crossCapacity)
+	switch {
+	case diff == 0:
+		return true
+
+	case diff <= c.cfg.CapacityTolerance:
+		log.Debugf("Graph capacities of cid(%v) differ by %v within "+
+			"the tolerance of %v: graph_capacity=%v, "+
+			"cross_graph_capacity=%v", cid, diff,
+			c.cfg.CapacityTolerance, int64(graphCapacity),
+			int64(crossCapacity))
+		return true
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,159 @@
+package chanleak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// esploraTimeout is the maximum time we'll wait for a single request
+	// to the Esplora API to complete.
+	esploraTimeout = 30 * time.Second
+
+	// esploraMaxBodySize is the largest response body we'll read from the
+	// Esplora API. Transactions are limited in size by consensus, so any
+	// legitimate response fits comfortably.
+	esploraMaxBodySize = 10 << 20
+)
+
+// esploraTx is the subset of a transaction returned by the Esplora API we
+// care about.
+type esploraTx struct {
+	Vout []struct {
+		Value int64 `json:"value"`
+	} `json:"vout"`
+
+	Status struct {
+		Confirmed bool `json:"confirmed"`
+	} `json:"status"`
+}
+
+// esploraOutspend is the spend state of an output returned by the Esplora API.
+type esploraOutspend struct {
+	Spent bool `json:"spent"`
+}
+
+// EsploraBackend is a ChainBackend that is backed by the HTTP API of an
+// Esplora block explorer, such as blockstream.info or mempool.space. It allows
+// verifying funding outputs without running a full node, at the cost of
+// trusting the explorer.
+type EsploraBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+// A compile-time check to ensure EsploraBackend satisfies ChainBackend.
+var _ ChainBackend = (*EsploraBackend)(nil)
+
+// NewEsploraBackend returns a backend querying the Esplora API at the given
+// base URL, e.g. https://blockstream.info/api.
+func NewEsploraBackend(baseURL string) *EsploraBackend {
+	return &EsploraBackend{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+			Timeout: esploraTimeout,
+		},
+	}
+}
+
+// FetchOutput returns the value of the output referenced by the outpoint,
+// along with its current state. Outputs of unconfirmed transactions are
+// considered not found, as they can't fund a valid channel yet.
+//
+// NOTE: This is part of the ChainBackend interface.
+func (b *EsploraBackend) FetchOutput(ctx context.Context,
+	op *wire.OutPoint) (btcutil.Amount, OutputState, error) {
+
+	var tx esploraTx
+	found, err := b.get(ctx, "/tx/"+op.Hash.String(), &tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found || !tx.Status.Confirmed {
+		return 0, OutputNotFound, nil
+	}
+	if int(op.Index) >= len(tx.Vout) {
+		return 0, OutputIndexOutOfRange, nil
+	}
+	value := btcutil.Amount(tx.Vout[op.Index].Value)
+
+	// With the output known to exist, we'll check whether it has been
+	// spent since.
+	var outspend esploraOutspend
+	path := fmt.Sprintf("/tx/%v/outspend/%d", op.Hash, op.Index)
+	found, err = b.get(ctx, path, &outspend)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, OutputNotFound, nil
+	}
+
+	if outspend.Spent {
+		return value, OutputSpent, nil
+	}
+
+	return value, OutputUnspent, nil
+}
+
+// get fetches the given path of the API and decodes the JSON response into
+// the target. If the API reports that the resource doesn't exist, false is
+// returned without an error.
+func (b *EsploraBackend) get(ctx context.Context, path string,
+	target interface{}) (bool, error) {
+
+	req, err := http.NewRequest(http.MethodGet, b.baseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(
+		io.LimitReader(resp.Body, esploraMaxBodySize),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// Esplora answers lookups of unknown transactions with a 404, while
+	// malformed ones, which can't exist either, are answered with a 400.
+	switch {
+	case resp.StatusCode == http.StatusNotFound,
+		resp.StatusCode == http.StatusBadRequest:
+
+		return false, nil
+
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("esplora returned status %v: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.Unmarshal(body, target); err != nil {
+		return false, fmt.Errorf("unable to decode esplora response: "+
+			"%v", err)
+	}
+
+	return true, nil
+}
+
+// Stop releases the idle connections to the Esplora API.
+func (b *EsploraBackend) Stop() {
+	b.client.Transport.(*http.Transport).CloseIdleConnections()
+}
@@ -0,0 +1,257 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClient is an LndClient that serves a scripted set of channels, graph
+// edges and forwarding events, so the detection logic can be driven without
+// a running lnd.
+type fakeClient struct {
+	// channels is the set of open channels of the node.
+	channels []*lnrpc.Channel
+
+	// closedChannels is the set of channels the node has closed.
+	closedChannels []*lnrpc.ChannelCloseSummary
+
+	// edges is the node's channel graph.
+	edges []*lnrpc.ChannelEdge
+
+	// fwdEvents is the node's forwarding history, in the order it was
+	// settled.
+	fwdEvents []*lnrpc.ForwardingEvent
+
+	// latency, if set, is how long each graph lookup takes, to mimic a
+	// node that's farther away.
+	latency time.Duration
+}
+
+// A compile-time check to ensure fakeClient satisfies LndClient.
+var _ LndClient = (*fakeClient)(nil)
+
+// ListChannels returns the scripted open channels.
+func (f *fakeClient) ListChannels(_ context.Context,
+	_ *lnrpc.ListChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	return &lnrpc.ListChannelsResponse{Channels: f.channels}, nil
+}
+
+// ClosedChannels returns the scripted closed channels.
+func (f *fakeClient) ClosedChannels(_ context.Context,
+	_ *lnrpc.ClosedChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	return &lnrpc.ClosedChannelsResponse{Channels: f.closedChannels}, nil
+}
+
+// GetChanInfo returns the scripted graph edge of the channel, or the error lnd
+// returns for a channel that's missing from the graph.
+func (f *fakeClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, edge := range f.edges {
+		if edge.ChannelId == in.ChanId {
+			return edge, nil
+		}
+	}
+
+	return nil, status.Error(codes.NotFound, "edge not found")
+}
+
+// DescribeGraph returns all scripted graph edges.
+func (f *fakeClient) DescribeGraph(ctx context.Context,
+	_ *lnrpc.ChannelGraphRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return &lnrpc.ChannelGraph{Edges: f.edges}, nil
+}
+
+// GetNodeInfo returns a bare node carrying just the given public key.
+func (f *fakeClient) GetNodeInfo(_ context.Context, in *lnrpc.NodeInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	return &lnrpc.NodeInfo{
+		Node: &lnrpc.LightningNode{PubKey: in.PubKey},
+	}, nil
+}
+
+// ForwardingHistory returns a page of the scripted forwarding events settled
+// within the requested time range, in the same way lnd pages them.
+func (f *fakeClient) ForwardingHistory(_ context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	_ ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	var events []*lnrpc.ForwardingEvent
+	for _, event := range f.fwdEvents {
+		if event.Timestamp < in.StartTime ||
+			event.Timestamp > in.EndTime {
+
+			continue
+		}
+		events = append(events, event)
+	}
+
+	resp := &lnrpc.ForwardingHistoryResponse{
+		LastOffsetIndex: in.IndexOffset,
+	}
+	if int(in.IndexOffset) >= len(events) {
+		return resp, nil
+	}
+
+	events = events[in.IndexOffset:]
+	if in.NumMaxEvents > 0 && len(events) > int(in.NumMaxEvents) {
+		events = events[:in.NumMaxEvents]
+	}
+
+	resp.ForwardingEvents = events
+	resp.LastOffsetIndex = in.IndexOffset + uint32(len(events))
+
+	return resp, nil
+}
+
+// wait blocks for the configured latency, or until the context is canceled.
+func (f *fakeClient) wait(ctx context.Context) error {
+	if f.latency == 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(f.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fakeOutput is the on-chain state of a single funding output.
+type fakeOutput struct {
+	value btcutil.Amount
+	state OutputState
+}
+
+// fakeChainBackend is a ChainBackend serving a scripted set of outputs. Any
+// other output is reported as not found.
+type fakeChainBackend struct {
+	outputs map[wire.OutPoint]fakeOutput
+}
+
+// A compile-time check to ensure fakeChainBackend satisfies ChainBackend.
+var _ ChainBackend = (*fakeChainBackend)(nil)
+
+// FetchOutput returns the scripted state of the output.
+func (f *fakeChainBackend) FetchOutput(_ context.Context,
+	op *wire.OutPoint) (btcutil.Amount, OutputState, error) {
+
+	output, ok := f.outputs[*op]
+	if !ok {
+		return 0, OutputNotFound, nil
+	}
+
+	return output.value, output.state, nil
+}
+
+// fakeChanPoint returns a well formed funding outpoint unique to the short
+// channel ID.
+func fakeChanPoint(chanID uint64) string {
+	return fmt.Sprintf("%064x:0", chanID)
+}
+
+// fakeOutPoint returns the parsed form of fakeChanPoint.
+func fakeOutPoint(chanID uint64) wire.OutPoint {
+	op, err := ParseOutPoint(fakeChanPoint(chanID))
+	if err != nil {
+		panic(err)
+	}
+
+	return *op
+}
+
+// fakePubkey returns a hex encoded public key unique to the short channel ID,
+// for the remote peer of the channel.
+func fakePubkey(chanID uint64) string {
+	return fmt.Sprintf("02%064x", chanID)
+}
+
+// fakeChannel returns an open public channel of the given capacity.
+func fakeChannel(chanID uint64, capacity btcutil.Amount) *lnrpc.Channel {
+	return &lnrpc.Channel{
+		Active:       true,
+		RemotePubkey: fakePubkey(chanID),
+		ChannelPoint: fakeChanPoint(chanID),
+		ChanId:       chanID,
+		Capacity:     int64(capacity),
+	}
+}
+
+// fakeEdge returns the graph edge of a channel of the given capacity.
+func fakeEdge(chanID uint64, capacity btcutil.Amount) *lnrpc.ChannelEdge {
+	return &lnrpc.ChannelEdge{
+		ChannelId: chanID,
+		ChanPoint: fakeChanPoint(chanID),
+		Capacity:  int64(capacity),
+	}
+}
+
+// fakeClosedChannel returns the summary of a closed channel of the given
+// capacity.
+func fakeClosedChannel(chanID uint64,
+	capacity btcutil.Amount) *lnrpc.ChannelCloseSummary {
+
+	return &lnrpc.ChannelCloseSummary{
+		ChannelPoint: fakeChanPoint(chanID),
+		ChanId:       chanID,
+		RemotePubkey: fakePubkey(chanID),
+		Capacity:     int64(capacity),
+		CloseType:    lnrpc.ChannelCloseSummary_COOPERATIVE_CLOSE,
+	}
+}
+
+// fakeForward returns a forward settled at the given Unix time, which came in
+// over chanIn and went out over chanOut.
+func fakeForward(timestamp, chanIn, chanOut uint64, amtIn,
+	amtOut btcutil.Amount) *lnrpc.ForwardingEvent {
+
+	return &lnrpc.ForwardingEvent{
+		Timestamp: timestamp,
+		ChanIdIn:  chanIn,
+		ChanIdOut: chanOut,
+		AmtIn:     uint64(amtIn),
+		AmtOut:    uint64(amtOut),
+		Fee:       uint64(amtIn - amtOut),
+		FeeMsat:   uint64(amtIn-amtOut) * 1000,
+	}
+}
+
+// chanIDs returns the sorted short channel IDs of the given channels. The
+// channels are verified concurrently, so the order they're found in varies.
+func chanIDs(channels []InvalidChannel) []uint64 {
+	ids := make([]uint64, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.ChanID.ToUint64())
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
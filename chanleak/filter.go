@@ -0,0 +1,161 @@
+package chanleak
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/routing/route"
+)
+
+const (
+	// ChannelStateAll scans all open channels, whether active or not.
+	ChannelStateAll = "all"
+
+	// ChannelStateActive only scans the open channels that are currently
+	// active.
+	ChannelStateActive = "active"
+
+	// ChannelStateInactive only scans the open channels that are
+	// currently inactive, such as those whose peer is offline.
+	ChannelStateInactive = "inactive"
+)
+
+// matchesChannelState returns true if the given open channel is in the state
+// the scan is restricted to.
+func (c *Checker) matchesChannelState(channel *lnrpc.Channel) bool {
+	switch c.cfg.ChannelState {
+	case ChannelStateActive:
+		return channel.Active
+
+	case ChannelStateInactive:
+		return !channel.Active
+
+	default:
+		return true
+	}
+}
+
+// ChannelFilter decides whether an open channel should be part of a scan.
+type ChannelFilter func(channel *lnrpc.Channel) bool
+
+// ChanIDFilter returns a ChannelFilter that only matches the channel with the
+// given short channel ID.
+func ChanIDFilter(cid lnwire.ShortChannelID) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		return channel.ChanId == cid.ToUint64()
+	}
+}
+
+// ChanPointFilter returns a ChannelFilter that only matches the channel with
+// the given funding outpoint.
+func ChanPointFilter(op *wire.OutPoint) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		chanPoint, err := ParseOutPoint(channel.ChannelPoint)
+		if err != nil {
+			return false
+		}
+
+		return *chanPoint == *op
+	}
+}
+
+// MinCapacityFilter returns a ChannelFilter that only matches channels with a
+// capacity of at least the given amount. A fake channel below the threshold
+// may go unnoticed, so this trades the completeness of a scan for its speed.
+func MinCapacityFilter(minCapacity btcutil.Amount) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		return btcutil.Amount(channel.Capacity) >= minCapacity
+	}
+}
+
+// MinHeightFilter returns a ChannelFilter that only matches channels whose
+// funding transaction was confirmed at or above the given block height, as
+// encoded within their short channel ID. This restricts a scan to the channels
+// opened since an earlier one, so incremental audits only verify the delta.
+func MinHeightFilter(height uint32) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+		return cid.BlockHeight >= height
+	}
+}
+
+// PeerFilter returns a ChannelFilter that only matches the channels with the
+// given remote peer.
+func PeerFilter(peer route.Vertex) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		return channel.RemotePubkey == peer.String()
+	}
+}
+
+// ParsePeer parses the hex encoded public key of a peer, and ensures it's a
+// valid point on the curve.
+func ParsePeer(s string) (route.Vertex, error) {
+	pubKeyBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return route.Vertex{}, fmt.Errorf("public key isn't valid hex")
+	}
+
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+	if err != nil {
+		return route.Vertex{}, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	return route.NewVertex(pubKey), nil
+}
+
+// AllFilters returns a ChannelFilter that only matches the channels matched by
+// all of the given filters. Nil filters are ignored.
+func AllFilters(filters ...ChannelFilter) ChannelFilter {
+	return func(channel *lnrpc.Channel) bool {
+		for _, filter := range filters {
+			if filter != nil && !filter(channel) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// ParseShortChanID parses a short channel ID, either in its block:tx:output
+// form or as the compact uint64 used by lnd's RPC interface.
+func ParseShortChanID(s string) (lnwire.ShortChannelID, error) {
+	if compact, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return lnwire.NewShortChanIDFromInt(compact), nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return lnwire.ShortChannelID{}, fmt.Errorf("short channel ID "+
+			"%q should be of the form block:tx:output", s)
+	}
+
+	blockHeight, err := strconv.ParseUint(parts[0], 10, 24)
+	if err != nil {
+		return lnwire.ShortChannelID{}, fmt.Errorf("invalid block "+
+			"height in short channel ID %q: %v", s, err)
+	}
+	txIndex, err := strconv.ParseUint(parts[1], 10, 24)
+	if err != nil {
+		return lnwire.ShortChannelID{}, fmt.Errorf("invalid tx index "+
+			"in short channel ID %q: %v", s, err)
+	}
+	txPosition, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return lnwire.ShortChannelID{}, fmt.Errorf("invalid output "+
+			"index in short channel ID %q: %v", s, err)
+	}
+
+	return lnwire.ShortChannelID{
+		BlockHeight: uint32(blockHeight),
+		TxIndex:     uint32(txIndex),
+		TxPosition:  uint16(txPosition),
+	}, nil
+}
@@ -0,0 +1,47 @@
+package chanleak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// TestMinCapacityFilter makes sure channels below the minimum capacity are
+// left out of a scan, even if they're fake, while those right at it are kept.
+func TestMinCapacityFilter(t *testing.T) {
+	client := &fakeClient{
+		channels: []*lnrpc.Channel{
+			fakeChannel(1, 1000000),
+			fakeChannel(2, 99999),
+			fakeChannel(3, 100000),
+		},
+		edges: []*lnrpc.ChannelEdge{
+			fakeEdge(1, 1000000),
+			fakeEdge(2, 20000),
+			fakeEdge(3, 50000),
+		},
+	}
+
+	checker, err := NewChecker(&Config{
+		Client:        client,
+		ChannelFilter: MinCapacityFilter(100000),
+	})
+	if err != nil {
+		t.Fatalf("unable to create checker: %v", err)
+	}
+	result, err := checker.CheckChannels(context.Background())
+	if err != nil {
+		t.Fatalf("unable to check channels: %v", err)
+	}
+
+	if result.NumChannels != 2 || result.NumChecked != 2 {
+		t.Fatalf("expected 2 of 2 channels to be checked, got %v of %v",
+			result.NumChecked, result.NumChannels)
+	}
+	ids := chanIDs(result.InvalidChannels)
+	if !reflect.DeepEqual(ids, []uint64{3}) {
+		t.Fatalf("expected only cid 3 to be invalid, got %v", ids)
+	}
+}
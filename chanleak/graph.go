@@ -0,0 +1,275 @@
+package chanleak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// GraphModeDescribe fetches the entire channel graph with a single
+	// DescribeGraph call, and looks up each channel locally.
+	GraphModeDescribe = "describe"
+
+	// GraphModeLookup issues a GetChanInfo call for each channel. This is
+	// much slower on large nodes, but avoids holding the full graph in
+	// memory.
+	GraphModeLookup = "lookup"
+)
+
+// errEdgeNotFound is returned by an edgeLookup if the channel isn't part of
+// the channel graph. It carries the same message as lnd's own error.
+var errEdgeNotFound = errors.New("edge not found")
+
+// isEdgeNotFound returns true if the given error of a channel graph lookup
+// means the channel isn't part of the graph, as opposed to the lookup failing
+// for an operational reason. lnd doesn't send a NotFound status code along
+// with a missing edge, and the REST client doesn't carry status codes at all,
+// so we'll also look for the message of lnd's error.
+func isEdgeNotFound(err error) bool {
+	if err == errEdgeNotFound || status.Code(err) == codes.NotFound {
+		return true
+	}
+
+	return strings.Contains(err.Error(), errEdgeNotFound.Error())
+}
+
+// edgeLookup returns the channel graph's view of the channel with the given
+// short channel ID. An error is returned if the channel couldn't be found.
+type edgeLookup func(ctx context.Context,
+	cid lnwire.ShortChannelID) (*lnrpc.ChannelEdge, error)
+
+// newEdgeLookup returns an edgeLookup for the given graph mode, along with the
+// number of edges within the channel graph. If the graph mode doesn't fetch the
+// full graph, the number of edges is unknown and -1 is returned instead.
+func newEdgeLookup(ctx context.Context, lndClient LndClient,
+	graphMode string) (edgeLookup, int, error) {
+
+	switch graphMode {
+	case GraphModeDescribe:
+		return newDescribeGraphLookup(ctx, lndClient)
+
+	case GraphModeLookup:
+		return newChanInfoLookup(lndClient), -1, nil
+
+	default:
+		return nil, 0, fmt.Errorf("unknown graph mode: %v", graphMode)
+	}
+}
+
+// newDescribeGraphLookup fetches the full channel graph once, and returns an
+// edgeLookup that serves all queries from the in-memory copy, along with the
+// number of edges within the graph.
+func newDescribeGraphLookup(ctx context.Context,
+	lndClient LndClient) (edgeLookup, int, error) {
+
+	// We include unannounced channels, as GetChanInfo would also return
+	// those, and we don't want to flag them as missing from the graph.
+	graph, err := lndClient.DescribeGraph(ctx, &lnrpc.ChannelGraphRequest{
+		IncludeUnannounced: true,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to describe graph: %v", err)
+	}
+
+	edges := make(map[uint64]*lnrpc.ChannelEdge, len(graph.Edges))
+	for _, edge := range graph.Edges {
+		edges[edge.ChannelId] = edge
+	}
+
+	return func(_ context.Context,
+		cid lnwire.ShortChannelID) (*lnrpc.ChannelEdge, error) {
+
+		edge, ok := edges[cid.ToUint64()]
+		if !ok {
+			return nil, errEdgeNotFound
+		}
+
+		return edge, nil
+	}, len(edges), nil
+}
+
+// newChanInfoLookup returns an edgeLookup that queries lnd for each channel
+// individually.
+func newChanInfoLookup(lndClient LndClient) edgeLookup {
+	return func(ctx context.Context,
+		cid lnwire.ShortChannelID) (*lnrpc.ChannelEdge, error) {
+
+		return lndClient.GetChanInfo(ctx, &lnrpc.ChanInfoRequest{
+			ChanId: cid.ToUint64(),
+		})
+	}
+}
+
+// newPrivateChanLookup returns an edgeLookup that serves the given private
+// channels from our own view of them, and defers all other channels to the
+// wrapped edgeLookup. As the capacity of the returned edge is our subjective
+// capacity, the on-chain verification of the funding output then checks the
+// channel against the chain directly.
+func newPrivateChanLookup(lookupEdge edgeLookup,
+	privateChans map[lnwire.ShortChannelID]*lnrpc.Channel) edgeLookup {
+
+	return func(ctx context.Context,
+		cid lnwire.ShortChannelID) (*lnrpc.ChannelEdge, error) {
+
+		channel, ok := privateChans[cid]
+		if !ok {
+			return lookupEdge(ctx, cid)
+		}
+
+		return &lnrpc.ChannelEdge{
+			ChannelId: channel.ChanId,
+			ChanPoint: channel.ChannelPoint,
+			Capacity:  channel.Capacity,
+		}, nil
+	}
+}
+
+// edgeResult is the result of looking up a single channel within the channel
+// graph.
+type edgeResult struct {
+	// cid is the short channel ID of the channel that was looked up.
+	cid lnwire.ShortChannelID
+
+	// subjectiveSize is the capacity of the channel from our point of
+	// view.
+	subjectiveSize btcutil.Amount
+
+	// edge is the channel graph's view of the channel, if it was found.
+	edge *lnrpc.ChannelEdge
+
+	// err is the error returned by the lookup, if any.
+	err error
+
+	// chainMismatch is set if the funding output of the channel on-chain
+	// doesn't match the channel graph. This is only checked if a chain
+	// backend was provided.
+	chainMismatch *ChainMismatch
+
+	// chainErr is the error returned while verifying the funding output
+	// on-chain, if any.
+	chainErr error
+
+	// crossEdge is the cross-check graph's view of the channel, if it was
+	// found there. This is only looked up if a cross-check lookup was
+	// provided.
+	crossEdge *lnrpc.ChannelEdge
+
+	// crossErr is the error returned by the lookup within the cross-check
+	// graph, if any.
+	crossErr error
+}
+
+// lookupEdges looks up every channel of the subjective view within the channel
+// graph using a bounded pool of numWorkers goroutines. If a chain backend is
+// given, the funding output of each channel found in the graph is verified
+// on-chain as well, and if a cross-check lookup is given, the channel is also
+// looked up within the graph it serves. The results are delivered over the
+// returned channel, which is closed once all lookups have completed or the
+// context has been canceled.
+func lookupEdges(ctx context.Context, lookupEdge, crossLookup edgeLookup,
+	chain ChainBackend,
+	subjectiveChanView map[lnwire.ShortChannelID]btcutil.Amount,
+	numWorkers int) <-chan edgeResult {
+
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	type job struct {
+		cid            lnwire.ShortChannelID
+		subjectiveSize btcutil.Amount
+	}
+
+	jobs := make(chan job)
+	results := make(chan edgeResult)
+
+	// First, we'll launch a goroutine to feed all channels to the workers,
+	// bailing out early if the context is canceled.
+	go func() {
+		defer close(jobs)
+
+		for cid, subjectiveSize := range subjectiveChanView {
+			select {
+			case jobs <- job{cid, subjectiveSize}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Next, we'll launch the workers themselves. Each of them will query
+	// the graph for a channel, and hand the result back to the caller.
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				edge, err := lookupEdge(ctx, j.cid)
+				if err != nil {
+					log.Debugf("Lookup of cid(%v) "+
+						"failed: %v", j.cid, err)
+				} else {
+					log.Debugf("Lookup of cid(%v): "+
+						"graph_capacity=%v, "+
+						"subjective_capacity=%v",
+						j.cid, edge.Capacity,
+						int64(j.subjectiveSize))
+				}
+
+				result := edgeResult{
+					cid:            j.cid,
+					subjectiveSize: j.subjectiveSize,
+					edge:           edge,
+					err:            err,
+				}
+
+				// A malformed channel point doesn't refer
+				// to any output we could look up, so it's
+				// only flagged as such by the caller.
+				validChanPoint := err == nil &&
+					malformedChannelPoint(
+						"graph", edge.ChanPoint,
+					) == ""
+				if validChanPoint && chain != nil {
+					mismatch, err := verifyFundingOutput(
+						ctx, chain, edge.ChanPoint,
+						btcutil.Amount(edge.Capacity),
+					)
+					result.chainMismatch = mismatch
+					result.chainErr = err
+				}
+
+				if err == nil && crossLookup != nil {
+					result.crossEdge, result.crossErr =
+						crossLookup(ctx, j.cid)
+				}
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Once all workers have exited, we'll close the results channel to
+	// signal the caller that we're done.
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
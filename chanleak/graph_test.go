@@ -0,0 +1,61 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// BenchmarkCheckChannels measures a scan of a node with many channels, each of
+// which is looked up individually from a client that takes a while to answer,
+// for a growing number of workers.
+func BenchmarkCheckChannels(b *testing.B) {
+	const (
+		numChannels = 64
+		capacity    = btcutil.Amount(1000000)
+	)
+
+	client := &fakeClient{
+		latency: time.Millisecond,
+	}
+	for chanID := uint64(1); chanID <= numChannels; chanID++ {
+		client.channels = append(
+			client.channels, fakeChannel(chanID, capacity),
+		)
+		client.edges = append(client.edges, fakeEdge(chanID, capacity))
+	}
+
+	for _, numWorkers := range []int{1, 8, 32} {
+		numWorkers := numWorkers
+		name := fmt.Sprintf("workers=%v", numWorkers)
+		b.Run(name, func(b *testing.B) {
+			checker, err := NewChecker(&Config{
+				Client:     client,
+				GraphMode:  GraphModeLookup,
+				NumWorkers: numWorkers,
+			})
+			if err != nil {
+				b.Fatalf("unable to create checker: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := checker.CheckChannels(
+					context.Background(),
+				)
+				if err != nil {
+					b.Fatalf("unable to check channels: %v",
+						err)
+				}
+				if result.NumChecked != numChannels {
+					b.Fatalf("expected %v channels to be "+
+						"checked, got %v", numChannels,
+						result.NumChecked)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package chanleak
+
+import "github.com/lightningnetwork/lnd/lnrpc"
+
+// Initiator describes which side of a channel opened it.
+type Initiator uint8
+
+const (
+	// InitiatorUnknown indicates that it isn't known which side opened
+	// the channel, such as for closed channels, as lnd doesn't record
+	// the initiator within their close summaries.
+	InitiatorUnknown Initiator = iota
+
+	// InitiatorLocal indicates that our node opened the channel.
+	InitiatorLocal
+
+	// InitiatorRemote indicates that the remote peer opened the channel.
+	// This is the CVE-2019-12999 scenario, in which a peer has our node
+	// accept a channel it never funded properly.
+	InitiatorRemote
+)
+
+// String returns a human readable description of the initiator.
+func (i Initiator) String() string {
+	switch i {
+	case InitiatorLocal:
+		return "local"
+
+	case InitiatorRemote:
+		return "remote"
+
+	default:
+		return "unknown"
+	}
+}
+
+// channelInitiator returns which side opened the given open channel.
+func channelInitiator(channel *lnrpc.Channel) Initiator {
+	if channel.Initiator {
+		return InitiatorLocal
+	}
+
+	return InitiatorRemote
+}
@@ -0,0 +1,44 @@
+package chanleak
+
+import (
+	"context"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// LndClient is the subset of lnd's gRPC interface that the Checker relies on.
+// It is satisfied by lnrpc.LightningClient, but keeping it small allows the
+// detection logic to be driven by any other source of channel data.
+type LndClient interface {
+	// ListChannels returns the set of currently open channels of the
+	// node.
+	ListChannels(ctx context.Context, in *lnrpc.ListChannelsRequest,
+		opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error)
+
+	// ClosedChannels returns the set of channels the node has closed in
+	// the past.
+	ClosedChannels(ctx context.Context, in *lnrpc.ClosedChannelsRequest,
+		opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error)
+
+	// GetChanInfo returns the channel graph's view of a single channel.
+	GetChanInfo(ctx context.Context, in *lnrpc.ChanInfoRequest,
+		opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error)
+
+	// DescribeGraph returns the full channel graph of the node.
+	DescribeGraph(ctx context.Context, in *lnrpc.ChannelGraphRequest,
+		opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error)
+
+	// GetNodeInfo returns the channel graph's view of a single node.
+	GetNodeInfo(ctx context.Context, in *lnrpc.NodeInfoRequest,
+		opts ...grpc.CallOption) (*lnrpc.NodeInfo, error)
+
+	// ForwardingHistory returns the set of HTLCs forwarded by the node.
+	ForwardingHistory(ctx context.Context,
+		in *lnrpc.ForwardingHistoryRequest,
+		opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse,
+		error)
+}
+
+// A compile-time check to ensure the lnd gRPC client satisfies LndClient.
+var _ LndClient = (lnrpc.LightningClient)(nil)
@@ -0,0 +1,485 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/routing/route"
+)
+
+const (
+	// ForwardingHistoryPageSize is the number of forwarding events
+	// requested from lnd at a time.
+	ForwardingHistoryPageSize = 10000
+
+	// lossCancelCheckInterval is the number of forwarding events we'll
+	// go through between two checks of whether the context was canceled.
+	// Checking for every event would be wasteful on huge histories, while
+	// this still aborts well within a blink.
+	lossCancelCheckInterval = 1000
+)
+
+// LossAbortedError is returned by QuantifyLoss if the context was canceled
+// before the full forwarding history could be fetched and gone through. The
+// partial LossReport returned along with it only accounts for the forwarding
+// events gone through so far, so its loss is incomplete.
+type LossAbortedError struct {
+	// NumProcessed is the number of forwarding events gone through
+	// before the computation was aborted.
+	NumProcessed int
+
+	// NumFetched is the number of forwarding events fetched before the
+	// computation was aborted. If the history was still being fetched,
+	// there may be more events that were never fetched at all.
+	NumFetched int
+
+	// Err is the reason the computation was aborted.
+	Err error
+}
+
+// Error returns a human readable description of the error.
+func (e *LossAbortedError) Error() string {
+	return fmt.Sprintf("loss computation aborted after going through %d "+
+		"of %d fetched forwarding events: %v", e.NumProcessed,
+		e.NumFetched, e.Err)
+}
+
+// LossReport describes the amount of coins lost due to forwards over a set of
+// invalid channels.
+//
+// The loss model is as follows: the coins within an invalid channel are fake,
+// while the coins within all other channels are real. A forward that came _in_
+// over an invalid channel drained real coins from the node, as it paid out
+// real coins on the outgoing channel in exchange for fake ones. A forward that
+// went _out_ over an invalid channel recovered real coins, as the node was
+// paid real coins on the incoming channel in exchange for fake ones. A forward
+// between two invalid channels exchanged fake coins for fake ones, so it
+// neither drained nor recovered any real coins and isn't counted.
+type LossReport struct {
+	// ChannelLosses is the net amount of real coins lost over each invalid
+	// channel that was involved in at least one forward. A positive value
+	// means coins were drained from the node through the channel, while a
+	// negative value means more real coins were recovered through the
+	// channel than were drained.
+	ChannelLosses map[lnwire.ShortChannelID]btcutil.Amount
+
+	// TotalLoss is the net amount of real coins lost across all invalid
+	// channels. As coins recovered over one channel offset the coins
+	// drained over another, this is the sum of all per-channel losses. It
+	// is never negative: a node that recovered more than it lost didn't
+	// lose any funds.
+	TotalLoss btcutil.Amount
+
+	// PeerLosses is the net amount of real coins lost to each remote peer,
+	// summed across all of the peer's invalid channels that were involved
+	// in at least one forward. A single malicious peer may have opened
+	// several fake channels, so this shows which peer drained the node.
+	PeerLosses map[route.Vertex]btcutil.Amount
+
+	// ChannelCloses holds the close details of each invalid channel that
+	// has since been closed. The forwards over a channel only tell us the
+	// loss it could have caused, while its close tells us how its balance
+	// was actually resolved on-chain.
+	ChannelCloses map[lnwire.ShortChannelID]ChannelClose
+
+	// BalanceChecks holds the reconciliation of the balance of each
+	// invalid channel that is still open with the forwards over it. It's
+	// empty if the forwarding history was restricted to a time range.
+	BalanceChecks map[lnwire.ShortChannelID]BalanceCheck
+
+	// ChannelForwards holds the forwards attributed to each invalid
+	// channel in ChannelLosses, in the order they were forwarded. It's
+	// only populated if RecordForwards is set within the config.
+	ChannelForwards map[lnwire.ShortChannelID][]AttributedForward
+}
+
+// AttributedForward is a single forward that contributed to the loss over an
+// invalid channel.
+type AttributedForward struct {
+	// Timestamp is the time the forward was settled at.
+	Timestamp time.Time
+
+	// ChanIn is the channel the HTLC came in over.
+	ChanIn lnwire.ShortChannelID
+
+	// ChanOut is the channel the HTLC went out over.
+	ChanOut lnwire.ShortChannelID
+
+	// AmtIn is the amount that came in over ChanIn.
+	AmtIn btcutil.Amount
+
+	// AmtOut is the amount that went out over ChanOut.
+	AmtOut btcutil.Amount
+
+	// Fee is the fee the node earned for the forward.
+	Fee btcutil.Amount
+
+	// Loss is the amount the forward added to the loss over the invalid
+	// channel. It's negative if real coins were recovered by it.
+	Loss btcutil.Amount
+
+	// Counterparty is the hex encoded public key of the remote peer of
+	// the other, valid channel of the forward, which received the real
+	// coins or paid them in. It's empty if the channel is no longer
+	// known to the node.
+	Counterparty string
+}
+
+// ChannelClose describes how an invalid channel was closed.
+type ChannelClose struct {
+	// CloseType is the way the channel was closed, e.g. cooperatively or
+	// by a force or breach close.
+	CloseType lnrpc.ChannelCloseSummary_ClosureType
+
+	// ClosingTxHash is the hash of the transaction that closed the
+	// channel.
+	ClosingTxHash string
+
+	// CloseHeight is the height at which the channel was closed.
+	CloseHeight uint32
+
+	// SettledBalance is our balance of the channel that was settled
+	// on-chain by the close.
+	SettledBalance btcutil.Amount
+
+	// TimeLockedBalance is our balance of the channel that was still
+	// time-locked on-chain by the close, and thus not yet swept.
+	TimeLockedBalance btcutil.Amount
+}
+
+// QuantifyLoss computes the amount of coins that may have been drained using
+// the given set of invalid channels, based on the node's forwarding history.
+// The forwarding history covers channels that have since been closed as well,
+// so the set may include invalid closed channels, as found by CheckChannels
+// if IncludeClosed is set. Forwards over a closed channel that isn't part of
+// the set aren't counted, as we can't tell whether it was valid.
+//
+// NOTE: The loss is computed from the satoshi amounts of each forward, which
+// lnd truncates from the underlying millisatoshi amounts. The lnd RPC version
+// this package is built against only exposes the fee in millisatoshis, not
+// the incoming and outgoing amounts, so each forward may be off by up to one
+// satoshi. Once the msat amounts are available, the computation should switch
+// over to them and only round for display.
+//
+// If the context is canceled while the forwarding history is being fetched or
+// gone through, a *LossAbortedError is returned along with the loss computed
+// from the forwarding events gone through so far.
+func (c *Checker) QuantifyLoss(ctx context.Context,
+	invalid []InvalidChannel) (LossReport, error) {
+
+	// We'll keep the full details of each invalid channel around, so the
+	// loss can be attributed to its peer without looking it up again.
+	invalidChannels := make(
+		map[lnwire.ShortChannelID]InvalidChannel, len(invalid),
+	)
+	for _, channel := range invalid {
+		invalidChannels[channel.ChanID] = channel
+	}
+
+	// At this point, we suspect that a channel is invalid. As a result,
+	// we'll attempt to compute the total amount of coins that may have
+	// been drained using the channel. To do that, we'll obtain the history
+	// of all HTLCs successfully forwarded through this node. If we're
+	// aborted while fetching it, we'll still go through the events fetched
+	// so far for a partial loss.
+	fwdEvents, err := c.fetchForwardingHistory(ctx)
+	aborted := err != nil && ctx.Err() != nil
+	if err != nil && !aborted {
+		return LossReport{}, err
+	}
+
+	chanForwardHistory := make(map[lnwire.ShortChannelID]btcutil.Amount)
+	var chanForwards map[lnwire.ShortChannelID][]AttributedForward
+	if c.cfg.RecordForwards {
+		chanForwards = make(
+			map[lnwire.ShortChannelID][]AttributedForward,
+		)
+	}
+	numProcessed := 0
+	for i, fwdEvent := range fwdEvents {
+		// A history of hundreds of thousands of events takes a while
+		// to go through, so we'll make sure to notice being aborted
+		// midway.
+		if i%lossCancelCheckInterval == 0 && ctx.Err() != nil {
+			aborted = true
+			break
+		}
+		numProcessed++
+
+		cidIn := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdIn)
+		cidOut := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdOut)
+
+		// If this forwarding event doesn't involve this channel, then
+		// we'll skip it.
+		_, incomingInvalidChan := invalidChannels[cidIn]
+		_, outgoingInvalidChan := invalidChannels[cidOut]
+		if !(incomingInvalidChan || outgoingInvalidChan) {
+			continue
+		}
+
+		// If both links of the forward are invalid channels, such as
+		// for a rebalance between two fake channels, then we accepted
+		// fake coins and paid out fake coins in return. No real coins
+		// changed hands, so the forward neither drained nor recovered
+		// any funds. Counting it would instead add the outgoing amount
+		// as a loss on one channel and subtract the incoming amount as
+		// a recovery on the other, skewing the per-channel breakdown.
+		if incomingInvalidChan && outgoingInvalidChan {
+			continue
+		}
+
+		// Otherwise, if an invalid channel was used as the incoming
+		// link, then this forward means we've lost the amount we paid
+		// out on the outgoing channel, which is the amount we accepted
+		// inbound minus the fee. These funds were lost as we accepted
+		// "fake" coins on an incoming channel and exchanged them for
+		// real coins on the outgoing channel. We never actually earned
+		// the fee either, as it was paid in fake coins.
+		cid := cidIn
+		loss := btcutil.Amount(fwdEvent.AmtOut)
+
+		// If we ever completed a forward that went _out_ through an
+		// invalid channel, then we've recovered funds as we exchanged
+		// the real coins we were paid on the incoming channel for fake
+		// coins.
+		if outgoingInvalidChan {
+			cid = cidOut
+			loss = -btcutil.Amount(fwdEvent.AmtIn)
+		}
+		chanForwardHistory[cid] += loss
+
+		if chanForwards == nil {
+			continue
+		}
+		chanForwards[cid] = append(chanForwards[cid], AttributedForward{
+			Timestamp: time.Unix(int64(fwdEvent.Timestamp), 0),
+			ChanIn:    cidIn,
+			ChanOut:   cidOut,
+			AmtIn:     btcutil.Amount(fwdEvent.AmtIn),
+			AmtOut:    btcutil.Amount(fwdEvent.AmtOut),
+			Fee:       btcutil.Amount(fwdEvent.Fee),
+			Loss:      loss,
+		})
+	}
+
+	// We'll then take the sum of net losses of each channel to produce
+	// our calculation of the amount of coins lost. If we recovered more
+	// than we lost, then no funds were lost at all.
+	report := LossReport{
+		ChannelLosses:   chanForwardHistory,
+		PeerLosses:      make(map[route.Vertex]btcutil.Amount),
+		ChannelForwards: chanForwards,
+	}
+	for cid, amtLost := range chanForwardHistory {
+		report.TotalLoss += amtLost
+
+		// We'll also roll the loss up by the remote peer of the
+		// channel, as known from our set of channels.
+		remotePubkey := invalidChannels[cid].RemotePubkey
+		peer, err := route.NewVertexFromStr(remotePubkey)
+		if err != nil {
+			log.Debugf("Unable to attribute loss of cid(%v) to "+
+				"peer %q: %v", cid, remotePubkey, err)
+			continue
+		}
+		report.PeerLosses[peer] += amtLost
+	}
+	if report.TotalLoss < 0 {
+		report.TotalLoss = 0
+	}
+
+	// Neither the balances nor the closes can be obtained once we've been
+	// aborted, so we'll hand back the partial loss as is.
+	if aborted {
+		return report, &LossAbortedError{
+			NumProcessed: numProcessed,
+			NumFetched:   len(fwdEvents),
+			Err:          ctx.Err(),
+		}
+	}
+
+	// As a sanity check of the loss, we'll make sure the forwards account
+	// for the current balance of each of the channels.
+	report.BalanceChecks, err = c.reconcileBalances(ctx, invalid, fwdEvents)
+	if err != nil {
+		return LossReport{}, err
+	}
+
+	// If the forwards are kept, we'll also note whom the real coins of
+	// each of them went to or came from.
+	if report.ChannelForwards != nil {
+		err := c.addCounterparties(ctx, report.ChannelForwards)
+		if err != nil {
+			return LossReport{}, err
+		}
+	}
+
+	// Finally, we'll note how any of the invalid channels that have since
+	// been closed were resolved on-chain, for a fuller picture of the
+	// loss that was actually realized.
+	report.ChannelCloses, err = c.fetchChannelCloses(ctx, invalid)
+	if err != nil {
+		return LossReport{}, err
+	}
+
+	if c.cfg.OnLossComputed != nil {
+		c.cfg.OnLossComputed(report)
+	}
+
+	return report, nil
+}
+
+// fetchChannelCloses obtains the close details of each of the given invalid
+// channels that has been closed.
+func (c *Checker) fetchChannelCloses(ctx context.Context,
+	invalid []InvalidChannel) (map[lnwire.ShortChannelID]ChannelClose,
+	error) {
+
+	invalidChannels := make(map[lnwire.ShortChannelID]InvalidChannel)
+	for _, channel := range invalid {
+		invalidChannels[channel.ChanID] = channel
+	}
+
+	closedResp, err := c.cfg.Client.ClosedChannels(
+		ctx, &lnrpc.ClosedChannelsRequest{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain closed channels: %v",
+			err)
+	}
+
+	closes := make(map[lnwire.ShortChannelID]ChannelClose)
+	for _, summary := range closedResp.Channels {
+		cid := lnwire.NewShortChanIDFromInt(summary.ChanId)
+		channel, ok := invalidChannels[cid]
+		if !ok {
+			continue
+		}
+
+		// A channel that we found among the open channels may have
+		// been closed while the scan was running, in which case it's
+		// listed as both open and closed. The close is the more
+		// recent state, so we'll report it all the same.
+		if !channel.Closed {
+			log.Debugf("Invalid cid(%v) was closed during the scan",
+				cid)
+		}
+
+		closes[cid] = ChannelClose{
+			CloseType:      summary.CloseType,
+			ClosingTxHash:  summary.ClosingTxHash,
+			CloseHeight:    summary.CloseHeight,
+			SettledBalance: btcutil.Amount(summary.SettledBalance),
+			TimeLockedBalance: btcutil.Amount(
+				summary.TimeLockedBalance,
+			),
+		}
+	}
+
+	return closes, nil
+}
+
+// addCounterparties fills in the counterparty of each of the given forwards,
+// which is the remote peer of the forward's channel that isn't the invalid
+// channel it's attributed to.
+func (c *Checker) addCounterparties(ctx context.Context,
+	chanForwards map[lnwire.ShortChannelID][]AttributedForward) error {
+
+	// The other channel of a forward may have been closed since, so we'll
+	// need both the open and the closed channels to cover all of them.
+	peers := make(map[lnwire.ShortChannelID]string)
+	openChannels, err := c.cfg.ChannelSource.OpenChannels(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list channels: %v", err)
+	}
+	for _, channel := range openChannels {
+		cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+		peers[cid] = channel.RemotePubkey
+	}
+
+	closedResp, err := c.cfg.Client.ClosedChannels(
+		ctx, &lnrpc.ClosedChannelsRequest{},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to obtain closed channels: %v", err)
+	}
+	for _, summary := range closedResp.Channels {
+		cid := lnwire.NewShortChanIDFromInt(summary.ChanId)
+		if _, ok := peers[cid]; !ok {
+			peers[cid] = summary.RemotePubkey
+		}
+	}
+
+	for cid, forwards := range chanForwards {
+		for i := range forwards {
+			other := forwards[i].ChanOut
+			if other == cid {
+				other = forwards[i].ChanIn
+			}
+			forwards[i].Counterparty = peers[other]
+		}
+	}
+
+	return nil
+}
+
+// fetchForwardingHistory obtains the node's forwarding history within the
+// configured time range, which defaults to the node's full history. The
+// history is requested in pages of ForwardingHistoryPageSize events, as lnd
+// caps the number of events returned by a single call. If the context is
+// canceled, no further pages are requested, and the events fetched so far are
+// returned along with the context's error.
+func (c *Checker) fetchForwardingHistory(
+	ctx context.Context) ([]*lnrpc.ForwardingEvent, error) {
+
+	startTime := uint64(1)
+	if !c.cfg.ForwardingStartTime.IsZero() {
+		startTime = uint64(c.cfg.ForwardingStartTime.Unix())
+	}
+
+	endTime := uint64(time.Now().Unix())
+	if !c.cfg.ForwardingEndTime.IsZero() {
+		endTime = uint64(c.cfg.ForwardingEndTime.Unix())
+	}
+
+	var (
+		fwdEvents   []*lnrpc.ForwardingEvent
+		indexOffset uint32
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fwdEvents, err
+		}
+
+		fwdHistoryReq := &lnrpc.ForwardingHistoryRequest{
+			StartTime:    startTime,
+			EndTime:      endTime,
+			IndexOffset:  indexOffset,
+			NumMaxEvents: ForwardingHistoryPageSize,
+		}
+		forwardingHistory, err := c.cfg.Client.ForwardingHistory(
+			ctx, fwdHistoryReq,
+		)
+		if err != nil && ctx.Err() != nil {
+			return fwdEvents, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain forwarding "+
+				"history: %v", err)
+		}
+
+		// Once we get back an empty page, we've read the full history.
+		if len(forwardingHistory.ForwardingEvents) == 0 {
+			return fwdEvents, nil
+		}
+
+		fwdEvents = append(
+			fwdEvents, forwardingHistory.ForwardingEvents...,
+		)
+		indexOffset = forwardingHistory.LastOffsetIndex
+	}
+}
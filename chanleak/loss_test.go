@@ -0,0 +1,260 @@
+package chanleak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// quantifyLoss scans the channels of the configured client, and quantifies the
+// loss over the invalid channels found.
+func quantifyLoss(t *testing.T, cfg *Config) LossReport {
+	t.Helper()
+
+	checker, err := NewChecker(cfg)
+	if err != nil {
+		t.Fatalf("unable to create checker: %v", err)
+	}
+	result, err := checker.CheckChannels(context.Background())
+	if err != nil {
+		t.Fatalf("unable to check channels: %v", err)
+	}
+	report, err := checker.QuantifyLoss(
+		context.Background(), result.InvalidChannels,
+	)
+	if err != nil {
+		t.Fatalf("unable to quantify loss: %v", err)
+	}
+
+	return report
+}
+
+// channelLosses returns the loss over each channel of the report, keyed by the
+// channel's short channel ID.
+func channelLosses(report LossReport) map[uint64]btcutil.Amount {
+	losses := make(map[uint64]btcutil.Amount, len(report.ChannelLosses))
+	for cid, loss := range report.ChannelLosses {
+		losses[cid.ToUint64()] = loss
+	}
+
+	return losses
+}
+
+// assertLoss makes sure the report holds exactly the given loss over each
+// channel, adding up to the given total.
+func assertLoss(t *testing.T, report LossReport,
+	expected map[uint64]btcutil.Amount, total btcutil.Amount) {
+
+	t.Helper()
+
+	losses := channelLosses(report)
+	if !reflect.DeepEqual(losses, expected) {
+		t.Fatalf("expected channel losses %v, got %v", expected,
+			losses)
+	}
+	if report.TotalLoss != total {
+		t.Fatalf("expected total loss %v, got %v", total,
+			report.TotalLoss)
+	}
+}
+
+// fakeNode returns a client for a node with a fake channel 1, which we believe
+// to be far larger than it is, and the valid channels 2 and 3, which forwarded
+// the given events.
+func fakeNode(fwdEvents ...*lnrpc.ForwardingEvent) *fakeClient {
+	return &fakeClient{
+		channels: []*lnrpc.Channel{
+			fakeChannel(1, 16000000),
+			fakeChannel(2, 1000000),
+			fakeChannel(3, 1000000),
+		},
+		edges: []*lnrpc.ChannelEdge{
+			fakeEdge(1, 20000),
+			fakeEdge(2, 1000000),
+			fakeEdge(3, 1000000),
+		},
+		fwdEvents: fwdEvents,
+	}
+}
+
+// TestQuantifyLoss asserts the exact loss over a fake channel, which is the
+// real coins we paid out in exchange for the fake coins it brought in, minus
+// the real coins we took in for the fake coins we paid out over it.
+func TestQuantifyLoss(t *testing.T) {
+	tests := []struct {
+		name      string
+		fwdEvents []*lnrpc.ForwardingEvent
+		losses    map[uint64]btcutil.Amount
+		totalLoss btcutil.Amount
+	}{
+		{
+			name: "drained",
+			fwdEvents: []*lnrpc.ForwardingEvent{
+				fakeForward(1000, 1, 2, 100100, 100000),
+				fakeForward(2000, 3, 1, 30030, 30000),
+				fakeForward(3000, 1, 3, 50050, 50000),
+				fakeForward(4000, 2, 3, 70070, 70000),
+			},
+			losses: map[uint64]btcutil.Amount{
+				1: 100000 - 30030 + 50000,
+			},
+			totalLoss: 100000 - 30030 + 50000,
+		},
+		{
+			name: "recovered",
+			fwdEvents: []*lnrpc.ForwardingEvent{
+				fakeForward(1000, 1, 2, 10010, 10000),
+				fakeForward(2000, 3, 1, 30030, 30000),
+			},
+			losses: map[uint64]btcutil.Amount{
+				1: 10000 - 30030,
+			},
+			totalLoss: 0,
+		},
+		{
+			name: "valid channels only",
+			fwdEvents: []*lnrpc.ForwardingEvent{
+				fakeForward(1000, 2, 3, 10010, 10000),
+			},
+			losses:    map[uint64]btcutil.Amount{},
+			totalLoss: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			report := quantifyLoss(t, &Config{
+				Client: fakeNode(test.fwdEvents...),
+			})
+			assertLoss(t, report, test.losses, test.totalLoss)
+		})
+	}
+}
+
+// TestQuantifyLossTimeRange makes sure forwards settled outside the configured
+// time range aren't counted towards the loss.
+func TestQuantifyLossTimeRange(t *testing.T) {
+	fwdEvents := []*lnrpc.ForwardingEvent{
+		fakeForward(1000, 1, 2, 10010, 10000),
+		fakeForward(2000, 1, 2, 20020, 20000),
+		fakeForward(3000, 1, 2, 30030, 30000),
+		fakeForward(4000, 1, 2, 40040, 40000),
+	}
+
+	tests := []struct {
+		name      string
+		startTime time.Time
+		endTime   time.Time
+		totalLoss btcutil.Amount
+	}{
+		{
+			name:      "full history",
+			totalLoss: 10000 + 20000 + 30000 + 40000,
+		},
+		{
+			name:      "start only",
+			startTime: time.Unix(2500, 0),
+			totalLoss: 30000 + 40000,
+		},
+		{
+			name:      "end only",
+			endTime:   time.Unix(2500, 0),
+			totalLoss: 10000 + 20000,
+		},
+		{
+			name:      "start and end",
+			startTime: time.Unix(1500, 0),
+			endTime:   time.Unix(3500, 0),
+			totalLoss: 20000 + 30000,
+		},
+		{
+			name:      "no forwards within range",
+			startTime: time.Unix(5000, 0),
+			endTime:   time.Unix(6000, 0),
+			totalLoss: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			report := quantifyLoss(t, &Config{
+				Client:              fakeNode(fwdEvents...),
+				ForwardingStartTime: test.startTime,
+				ForwardingEndTime:   test.endTime,
+			})
+
+			losses := map[uint64]btcutil.Amount{}
+			if test.totalLoss != 0 {
+				losses[1] = test.totalLoss
+			}
+			assertLoss(t, report, losses, test.totalLoss)
+		})
+	}
+}
+
+// TestQuantifyLossBothLegsInvalid makes sure a forward between two fake
+// channels neither adds to the loss over one of them nor offsets it over the
+// other, as no real coins changed hands.
+func TestQuantifyLossBothLegsInvalid(t *testing.T) {
+	client := fakeNode(
+		fakeForward(1000, 1, 4, 50050, 50000),
+		fakeForward(2000, 4, 1, 20020, 20000),
+		fakeForward(3000, 1, 2, 10010, 10000),
+		fakeForward(4000, 4, 3, 30030, 30000),
+	)
+	client.channels = append(client.channels, fakeChannel(4, 8000000))
+	client.edges = append(client.edges, fakeEdge(4, 40000))
+
+	report := quantifyLoss(t, &Config{Client: client})
+	assertLoss(t, report, map[uint64]btcutil.Amount{
+		1: 10000,
+		4: 30000,
+	}, 10000+30000)
+}
+
+// TestQuantifyLossClosedChannel makes sure the forwards over a fake channel
+// that has since been closed count towards the loss, while those over a valid
+// closed channel don't.
+func TestQuantifyLossClosedChannel(t *testing.T) {
+	client := fakeNode(
+		fakeForward(1000, 5, 2, 100100, 100000),
+		fakeForward(2000, 6, 3, 20020, 20000),
+		fakeForward(3000, 1, 3, 10010, 10000),
+	)
+	client.closedChannels = []*lnrpc.ChannelCloseSummary{
+		fakeClosedChannel(5, 16000000),
+		fakeClosedChannel(6, 500000),
+	}
+	chainBackend := &fakeChainBackend{
+		outputs: map[wire.OutPoint]fakeOutput{
+			fakeOutPoint(1): {20000, OutputUnspent},
+			fakeOutPoint(2): {1000000, OutputUnspent},
+			fakeOutPoint(3): {1000000, OutputUnspent},
+			fakeOutPoint(5): {20000, OutputSpent},
+			fakeOutPoint(6): {500000, OutputSpent},
+		},
+	}
+
+	report := quantifyLoss(t, &Config{
+		Client:        client,
+		ChainBackend:  chainBackend,
+		IncludeClosed: true,
+	})
+	assertLoss(t, report, map[uint64]btcutil.Amount{
+		1: 10000,
+		5: 100000,
+	}, 10000+100000)
+
+	closedCid := lnwire.NewShortChanIDFromInt(5)
+	if _, ok := report.ChannelCloses[closedCid]; !ok {
+		t.Fatalf("expected the close of cid 5 to be reported")
+	}
+}
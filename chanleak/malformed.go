@@ -0,0 +1,56 @@
+package chanleak
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// malformedCapacity returns why the given capacity recorded within the channel
+// graph can't possibly belong to a real channel, or an empty string if it's
+// plausible. A channel without any capacity, or with more capacity than the
+// Bitcoin supply, points to corrupt graph data rather than a mere mismatch
+// with our own view of the channel, so it's flagged even if our view happens
+// to agree.
+func malformedCapacity(capacity btcutil.Amount) string {
+	switch {
+	case capacity <= 0:
+		return fmt.Sprintf("graph capacity of %v is not positive",
+			capacity)
+
+	case capacity > btcutil.MaxSatoshi:
+		return fmt.Sprintf("graph capacity of %v exceeds the bitcoin "+
+			"supply of %v", capacity,
+			btcutil.Amount(btcutil.MaxSatoshi))
+
+	default:
+		return ""
+	}
+}
+
+// malformedChannelPoint returns why the given funding outpoint of a channel,
+// as recorded by the given source, can't be parsed, or an empty string if it
+// can. Garbage channel point data can't refer to any funding output, so the
+// channel is flagged rather than the scan aborted.
+func malformedChannelPoint(source, chanPoint string) string {
+	if _, err := ParseOutPoint(chanPoint); err != nil {
+		return fmt.Sprintf("%v channel point is malformed: %v", source,
+			err)
+	}
+
+	return ""
+}
+
+// joinMalformed joins the given reasons a channel is malformed, skipping the
+// empty ones.
+func joinMalformed(reasons ...string) string {
+	var nonEmpty []string
+	for _, reason := range reasons {
+		if reason != "" {
+			nonEmpty = append(nonEmpty, reason)
+		}
+	}
+
+	return strings.Join(nonEmpty, "; ")
+}
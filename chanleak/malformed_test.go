@@ -0,0 +1,49 @@
+package chanleak
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// TestMalformedCapacity checks the sanity bounds of a graph capacity right at
+// and beyond their boundaries.
+func TestMalformedCapacity(t *testing.T) {
+	tests := []struct {
+		capacity  btcutil.Amount
+		malformed bool
+	}{
+		{capacity: -btcutil.MaxSatoshi, malformed: true},
+		{capacity: -1, malformed: true},
+		{capacity: 0, malformed: true},
+		{capacity: 1, malformed: false},
+		{capacity: 16777215, malformed: false},
+		{capacity: btcutil.MaxSatoshi, malformed: false},
+		{capacity: btcutil.MaxSatoshi + 1, malformed: true},
+	}
+
+	for _, test := range tests {
+		reason := malformedCapacity(test.capacity)
+		if (reason != "") != test.malformed {
+			t.Fatalf("expected capacity %v to be malformed=%v, "+
+				"got reason %q", int64(test.capacity),
+				test.malformed, reason)
+		}
+	}
+}
+
+// TestMalformedChannelPoint makes sure a malformed channel point is flagged
+// along with the source that recorded it, while a well formed one isn't.
+func TestMalformedChannelPoint(t *testing.T) {
+	reason := malformedChannelPoint("local", fakeChanPoint(1))
+	if reason != "" {
+		t.Fatalf("expected well formed channel point, got %q", reason)
+	}
+
+	reason = malformedChannelPoint("graph", fakeChanPoint(1)+"0")
+	if !strings.HasPrefix(reason, "graph channel point is malformed") {
+		t.Fatalf("expected malformed graph channel point, got %q",
+			reason)
+	}
+}
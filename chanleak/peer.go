@@ -0,0 +1,110 @@
+package chanleak
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// ResolvePeers looks up the remote peer of each of the given channels within
+// the channel graph, and populates their PeerAlias and PeerAddresses fields.
+// Peers that aren't known to the graph are left with their public key only,
+// as an unknown peer shouldn't keep the channel from being reported.
+func (c *Checker) ResolvePeers(ctx context.Context, channels []InvalidChannel) {
+	// Several channels may be with the same peer, so we'll only query each
+	// peer once.
+	var pubkeys []string
+	seen := make(map[string]struct{})
+	for _, channel := range channels {
+		pubkey := channel.RemotePubkey
+		if pubkey == "" {
+			continue
+		}
+		if _, ok := seen[pubkey]; ok {
+			continue
+		}
+
+		seen[pubkey] = struct{}{}
+		pubkeys = append(pubkeys, pubkey)
+	}
+
+	nodes := c.lookupNodes(ctx, pubkeys)
+	for i := range channels {
+		nodeInfo, ok := nodes[channels[i].RemotePubkey]
+		if !ok || nodeInfo.Node == nil {
+			continue
+		}
+		node := nodeInfo.Node
+
+		channels[i].PeerAlias = node.Alias
+		channels[i].PeerAddresses = nil
+		for _, addr := range node.Addresses {
+			channels[i].PeerAddresses = append(
+				channels[i].PeerAddresses, addr.Addr,
+			)
+		}
+	}
+}
+
+// lookupNodes looks up each of the given nodes within the channel graph using
+// the same bounded pool of workers as the channel lookups, as large nodes may
+// have hundreds of peers. Nodes that couldn't be found are omitted from the
+// returned map.
+func (c *Checker) lookupNodes(ctx context.Context,
+	pubkeys []string) map[string]*lnrpc.NodeInfo {
+
+	numWorkers := c.cfg.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	// First, we'll launch a goroutine to feed all nodes to the workers,
+	// bailing out early if the context is canceled.
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+
+		for _, pubkey := range pubkeys {
+			select {
+			case jobs <- pubkey:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Next, we'll launch the workers themselves, each of them recording
+	// the nodes it found in the shared map.
+	var (
+		mu    sync.Mutex
+		nodes = make(map[string]*lnrpc.NodeInfo, len(pubkeys))
+		wg    sync.WaitGroup
+	)
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for pubkey := range jobs {
+				nodeInfo, err := c.cfg.Client.GetNodeInfo(
+					ctx, &lnrpc.NodeInfoRequest{
+						PubKey: pubkey,
+					},
+				)
+				if err != nil {
+					log.Debugf("Unable to obtain node info "+
+						"for peer %v: %v", pubkey, err)
+					continue
+				}
+
+				mu.Lock()
+				nodes[pubkey] = nodeInfo
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return nodes
+}
@@ -0,0 +1,74 @@
+package chanleak
+
+import (
+	"context"
+)
+
+// ScanPlan describes the work a scan of the node would incur, without carrying
+// it out.
+type ScanPlan struct {
+	// NumChannels is the number of open channels that would be scanned.
+	NumChannels int
+
+	// NumPrivate is the number of those channels that are private, and
+	// would thus only be verified on-chain.
+	NumPrivate int
+
+	// NumPrivateSkipped is the number of private channels that would be
+	// left out of the scan, as IncludePrivate isn't set.
+	NumPrivateSkipped int
+
+	// NumDescribeGraphCalls is the number of DescribeGraph calls the scan
+	// would issue. This is one in GraphModeDescribe, and zero otherwise.
+	NumDescribeGraphCalls int
+
+	// NumChanInfoCalls is the number of GetChanInfo calls the scan would
+	// issue. This is one per public channel in GraphModeLookup, and zero
+	// otherwise.
+	NumChanInfoCalls int
+
+	// NumChainLookups is the number of funding outputs that would be
+	// fetched from the chain backend, if one is configured.
+	NumChainLookups int
+
+	// ForwardingHistoryPageSize is the number of forwarding events that
+	// would be requested per ForwardingHistory call if any invalid
+	// channels are found. The number of pages depends on the size of the
+	// node's forwarding history, which can't be known without fetching
+	// it.
+	ForwardingHistoryPageSize int
+}
+
+// Plan returns the work a scan of the node would incur with the current
+// config. Only the node's open channels are fetched, so this is cheap even for
+// large nodes.
+func (c *Checker) Plan(ctx context.Context) (*ScanPlan, error) {
+	selection, err := c.selectChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	numChannels := len(selection.subjectiveChanView)
+	numPublic := numChannels - len(selection.privateChans)
+
+	plan := &ScanPlan{
+		NumChannels:               numChannels,
+		NumPrivate:                len(selection.privateChans),
+		NumPrivateSkipped:         selection.numPrivateSkipped,
+		ForwardingHistoryPageSize: ForwardingHistoryPageSize,
+	}
+
+	switch c.cfg.GraphMode {
+	case GraphModeDescribe:
+		plan.NumDescribeGraphCalls = 1
+
+	case GraphModeLookup:
+		plan.NumChanInfoCalls = numPublic
+	}
+
+	if c.cfg.ChainBackend != nil {
+		plan.NumChainLookups = numChannels
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,53 @@
+package chanleak
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// PolicyMismatch describes a routing policy of a channel that is inconsistent
+// with the capacity the channel graph records for the channel.
+type PolicyMismatch struct {
+	// NodePubkey is the hex encoded public key of the node that advertised
+	// the policy.
+	NodePubkey string
+
+	// MaxHTLC is the largest HTLC the node claims the channel can carry,
+	// which exceeds the capacity of the channel.
+	MaxHTLC lnwire.MilliSatoshi
+}
+
+// findPolicyMismatches checks the routing policies of both ends of a channel
+// against the capacity the channel graph records for it. Each policy's maximum
+// HTLC size implies a lower bound on the capacity of the channel, so a policy
+// advertising a larger maximum than the channel could possibly carry reveals
+// that the capacity and the channel's actual funding disagree somewhere, even
+// if our own view of the channel matches the graph.
+func findPolicyMismatches(edge *lnrpc.ChannelEdge) []PolicyMismatch {
+	capacity := lnwire.NewMSatFromSatoshis(btcutil.Amount(edge.Capacity))
+
+	var mismatches []PolicyMismatch
+	checkPolicy := func(nodePubkey string, policy *lnrpc.RoutingPolicy) {
+		// Older channel updates don't carry a maximum HTLC size at
+		// all, in which case there's nothing to compare.
+		if policy == nil || policy.MaxHtlcMsat == 0 {
+			return
+		}
+
+		maxHTLC := lnwire.MilliSatoshi(policy.MaxHtlcMsat)
+		if maxHTLC <= capacity {
+			return
+		}
+
+		mismatches = append(mismatches, PolicyMismatch{
+			NodePubkey: nodePubkey,
+			MaxHTLC:    maxHTLC,
+		})
+	}
+
+	checkPolicy(edge.Node1Pub, edge.Node1Policy)
+	checkPolicy(edge.Node2Pub, edge.Node2Policy)
+
+	return mismatches
+}
@@ -0,0 +1,166 @@
+package chanleak
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultRateLimit is the default maximum number of RPCs per second
+	// issued to lnd. This is low enough to leave plenty of headroom for a
+	// production node's own workload, while still verifying a few
+	// thousand channels within a minute.
+	DefaultRateLimit = 50
+
+	// rateLimitBurst is the number of RPCs that may be issued at once
+	// after a pause, before the rate limit kicks in.
+	rateLimitBurst = 5
+)
+
+// rateLimiter is a token bucket shared by all workers of a scan. Tokens are
+// added at a fixed rate up to rateLimitBurst, and each RPC takes one, waiting
+// for the next one if the bucket is empty.
+type rateLimiter struct {
+	// interval is the time it takes to add a single token.
+	interval time.Duration
+
+	mu sync.Mutex
+
+	// next is the time the next token becomes available. If it lies in
+	// the past, the bucket holds one token for each interval since, up
+	// to rateLimitBurst.
+	next time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing the given number of RPCs per
+// second, which must be positive.
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / rps),
+	}
+}
+
+// wait blocks until a token is available and takes it, or returns an error if
+// the context is done first.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+
+	// Tokens don't accumulate beyond the burst, however long the bucket
+	// went unused.
+	earliest := now.Add(-(rateLimitBurst - 1) * r.interval)
+	if r.next.Before(earliest) {
+		r.next = earliest
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(delay):
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitClient is an LndClient that never issues more RPCs per second than
+// its rate limiter allows, so a scan with many workers doesn't saturate lnd's
+// RPC server and degrade the node it's auditing.
+type rateLimitClient struct {
+	client  LndClient
+	limiter *rateLimiter
+}
+
+// A compile-time check to ensure rateLimitClient satisfies LndClient.
+var _ LndClient = (*rateLimitClient)(nil)
+
+// newRateLimitClient returns an LndClient that issues the RPCs of the given
+// client at no more than the given number per second.
+func newRateLimitClient(client LndClient, rps float64) *rateLimitClient {
+	return &rateLimitClient{
+		client:  client,
+		limiter: newRateLimiter(rps),
+	}
+}
+
+// ListChannels returns the set of currently open channels of the node.
+func (r *rateLimitClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.ListChannels(ctx, in, opts...)
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+func (r *rateLimitClient) ClosedChannels(ctx context.Context,
+	in *lnrpc.ClosedChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.ClosedChannels(ctx, in, opts...)
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (r *rateLimitClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.GetChanInfo(ctx, in, opts...)
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (r *rateLimitClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.DescribeGraph(ctx, in, opts...)
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (r *rateLimitClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.GetNodeInfo(ctx, in, opts...)
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (r *rateLimitClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	if err := r.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.client.ForwardingHistory(ctx, in, opts...)
+}
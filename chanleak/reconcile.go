@@ -0,0 +1,152 @@
+package chanleak
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// balanceDiscrepancyRatio is the share of a channel's capacity by which its
+// local balance may differ from the balance implied by its forwards before the
+// loss figure is considered incomplete. This leaves room for the commitment
+// fee, which changes with the fee rate, as well as for payments and invoices
+// over the channel, which aren't part of the forwarding history.
+const balanceDiscrepancyRatio = 0.01
+
+// BalanceCheck compares the balance of an open invalid channel with the
+// balance implied by the forwards over it. A forward that came in over the
+// channel added to our local balance, while one that went out over it took
+// from it, so the forwarding history should account for our current balance.
+// If it doesn't, forwarding events may be missing, and the loss computed from
+// them may be incomplete.
+type BalanceCheck struct {
+	// LocalBalance is our current balance within the channel.
+	LocalBalance btcutil.Amount
+
+	// RemoteBalance is the remote peer's current balance within the
+	// channel.
+	RemoteBalance btcutil.Amount
+
+	// NetForwarded is the net amount the forwards over the channel added
+	// to our local balance: the amount of all forwards that came in over
+	// it, minus the amount of all forwards that went out over it.
+	NetForwarded btcutil.Amount
+
+	// ExpectedLocalBalance is our local balance as implied by the
+	// forwards over the channel. It assumes no funds were pushed to the
+	// remote peer when the channel was opened.
+	ExpectedLocalBalance btcutil.Amount
+
+	// Discrepancy is the absolute difference between LocalBalance and
+	// ExpectedLocalBalance.
+	Discrepancy btcutil.Amount
+
+	// Incomplete is true if the discrepancy is too large to be explained
+	// by fees or pending HTLCs. This hints at forwarding events missing
+	// from the history, in which case the loss figure may be incomplete.
+	Incomplete bool
+}
+
+// reconcileBalances compares the balance of each of the given invalid channels
+// that is still open with the balance implied by the given forwards. If the
+// forwarding history was restricted to a time range, it doesn't account for
+// the full lifetime of the channels, so no balances are reconciled.
+func (c *Checker) reconcileBalances(ctx context.Context,
+	invalid []InvalidChannel, fwdEvents []*lnrpc.ForwardingEvent) (
+	map[lnwire.ShortChannelID]BalanceCheck, error) {
+
+	if !c.cfg.ForwardingStartTime.IsZero() ||
+		!c.cfg.ForwardingEndTime.IsZero() {
+
+		log.Debugf("Not reconciling channel balances, as the " +
+			"forwarding history is restricted to a time range")
+		return nil, nil
+	}
+
+	openChannels := make(map[lnwire.ShortChannelID]struct{})
+	for _, channel := range invalid {
+		if !channel.Closed {
+			openChannels[channel.ChanID] = struct{}{}
+		}
+	}
+	if len(openChannels) == 0 {
+		return nil, nil
+	}
+
+	netForwarded := make(map[lnwire.ShortChannelID]btcutil.Amount)
+	for _, fwdEvent := range fwdEvents {
+		cidIn := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdIn)
+		if _, ok := openChannels[cidIn]; ok {
+			netForwarded[cidIn] += btcutil.Amount(fwdEvent.AmtIn)
+		}
+
+		cidOut := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdOut)
+		if _, ok := openChannels[cidOut]; ok {
+			netForwarded[cidOut] -= btcutil.Amount(fwdEvent.AmtOut)
+		}
+	}
+
+	channels, err := c.cfg.ChannelSource.OpenChannels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list channels: %v", err)
+	}
+
+	checks := make(map[lnwire.ShortChannelID]BalanceCheck)
+	for _, channel := range channels {
+		cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+		if _, ok := openChannels[cid]; !ok {
+			continue
+		}
+
+		check := newBalanceCheck(channel, netForwarded[cid])
+		if check.Incomplete {
+			log.Warnf("Loss of cid(%v) may be incomplete: local "+
+				"balance of %v differs from the %v implied by "+
+				"its forwards", cid, check.LocalBalance,
+				check.ExpectedLocalBalance)
+		}
+		checks[cid] = check
+	}
+
+	return checks, nil
+}
+
+// newBalanceCheck compares the balance of the given channel with the balance
+// implied by the net amount forwarded over it.
+func newBalanceCheck(channel *lnrpc.Channel,
+	netForwarded btcutil.Amount) BalanceCheck {
+
+	// If we opened the channel, then we started out with its full
+	// capacity, minus the commitment fee we pay as the initiator.
+	// Otherwise, we started out with nothing.
+	var initialBalance btcutil.Amount
+	if channel.Initiator {
+		initialBalance = btcutil.Amount(
+			channel.Capacity - channel.CommitFee,
+		)
+	}
+
+	check := BalanceCheck{
+		LocalBalance:         btcutil.Amount(channel.LocalBalance),
+		RemoteBalance:        btcutil.Amount(channel.RemoteBalance),
+		NetForwarded:         netForwarded,
+		ExpectedLocalBalance: initialBalance + netForwarded,
+	}
+
+	check.Discrepancy = check.LocalBalance - check.ExpectedLocalBalance
+	if check.Discrepancy < 0 {
+		check.Discrepancy = -check.Discrepancy
+	}
+
+	// HTLCs that are still pending haven't settled into either balance
+	// yet, so we'll allow for them on top of the fees.
+	tolerance := btcutil.Amount(
+		balanceDiscrepancyRatio*float64(channel.Capacity),
+	) + btcutil.Amount(channel.UnsettledBalance)
+	check.Incomplete = check.Discrepancy > tolerance
+
+	return check
+}
@@ -0,0 +1,529 @@
+package chanleak
+
+import "time"
+
+// ReportSchemaVersion is the version of the schema of Report. It's bumped
+// whenever the meaning of an existing field changes or a field is removed, but
+// not when a new field is added, as consumers are expected to ignore fields
+// they don't know of.
+const ReportSchemaVersion = 1
+
+// LossStatus describes to what extent the loss of a scan could be quantified.
+type LossStatus string
+
+const (
+	// LossComputed means the loss was quantified from the node's
+	// forwarding history, which accounts for the balance of every invalid
+	// channel. It's also the status of a scan without invalid channels,
+	// which caused no loss.
+	LossComputed LossStatus = "computed"
+
+	// LossPartial means the loss was quantified, but the forwarding
+	// history doesn't account for the balance of every invalid channel,
+	// so forwards may be missing from it.
+	LossPartial LossStatus = "partial"
+
+	// LossUnavailable means the loss couldn't be quantified at all, for
+	// example because the node's forwarding history couldn't be obtained.
+	LossUnavailable LossStatus = "unavailable"
+)
+
+// ReportChannel is the JSON representation of a channel that we believe to
+// be invalid.
+type ReportChannel struct {
+	// ChanID is the compact uint64 form of the short channel ID.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// OpenHeight is the height of the block the channel's funding
+	// transaction was confirmed in, as encoded in its short channel ID.
+	OpenHeight uint32 `json:"openHeight"`
+
+	// ApproxOpenDate is the date the channel was opened at, estimated
+	// from OpenHeight relative to the node's best block. It's empty if
+	// the node didn't report the time of its best block.
+	ApproxOpenDate string `json:"approxOpenDate,omitempty"`
+
+	// RemotePubkey is the hex encoded public key of the channel's remote
+	// peer.
+	RemotePubkey string `json:"remotePubkey"`
+
+	// ChannelPoint is the funding outpoint of the channel in txid:index
+	// form, as recorded by the node.
+	ChannelPoint string `json:"channelPoint,omitempty"`
+
+	// PeerAlias is the alias of the remote peer, if it's known to the
+	// channel graph.
+	PeerAlias string `json:"peerAlias,omitempty"`
+
+	// PeerAddresses is the set of addresses the remote peer advertises,
+	// if it's known to the channel graph.
+	PeerAddresses []string `json:"peerAddresses,omitempty"`
+
+	// Initiator is the side that opened the channel, either local,
+	// remote, or unknown for closed channels.
+	Initiator string `json:"initiator"`
+
+	// Inactive is true if the open channel is currently inactive, such as
+	// its peer being offline. It's never set for closed channels.
+	Inactive bool `json:"inactive"`
+
+	// SubjectiveCapacity is the capacity of the channel as we believe it
+	// to be, taken from our set of open channels.
+	SubjectiveCapacity int64 `json:"subjectiveCapacity"`
+
+	// GraphCapacity is the capacity of the channel as recorded within the
+	// channel graph. This is zero if the channel wasn't found.
+	GraphCapacity int64 `json:"graphCapacity"`
+
+	// InGraph is true if the channel was found within the channel graph.
+	InGraph bool `json:"inGraph"`
+
+	// Private is true if the channel is private, and was thus only
+	// verified on-chain.
+	Private bool `json:"private"`
+
+	// ChainMismatch is set if the funding output on-chain didn't match
+	// the channel graph.
+	ChainMismatch *ReportChainMismatch `json:"chainMismatch,omitempty"`
+
+	// PolicyMismatches is the set of routing policies that advertise a
+	// larger maximum HTLC size than the graph capacity allows for.
+	PolicyMismatches []ReportPolicyMismatch `json:"policyMismatches,omitempty"`
+
+	// Malformed describes why the graph capacity or a channel point of
+	// the channel can't possibly be right, if it can't.
+	Malformed string `json:"malformed,omitempty"`
+
+	// Closed is true if the channel has since been closed.
+	Closed bool `json:"closed"`
+
+	// InCrossGraph is true if the channel was found within the channel
+	// graph of the cross-check node. It's only set if one was configured.
+	InCrossGraph bool `json:"inCrossGraph,omitempty"`
+
+	// CrossGraphCapacity is the capacity of the channel in satoshis
+	// according to the channel graph of the cross-check node.
+	CrossGraphCapacity int64 `json:"crossGraphCapacity,omitempty"`
+
+	// CrossMismatch is true if the local channel graph and the one of the
+	// cross-check node disagree on the capacity of the channel.
+	CrossMismatch bool `json:"crossMismatch,omitempty"`
+
+	// Mismatch is by how much the subjective capacity is off in satoshis,
+	// as computed by MismatchMagnitude. It's only set for invalid
+	// channels.
+	Mismatch int64 `json:"mismatch,omitempty"`
+
+	// Severity is the score of how alarming the channel is, as computed by
+	// Severity from its mismatch and the loss attributed to it. It's only
+	// set for invalid channels.
+	Severity int64 `json:"severity,omitempty"`
+
+	// LookupError is the error the lookup of the channel within the
+	// channel graph kept failing with. It's only set for channels whose
+	// check failed.
+	LookupError string `json:"lookupError,omitempty"`
+}
+
+// ReportChainMismatch is the JSON representation of a funding output whose
+// on-chain state doesn't match the channel graph.
+type ReportChainMismatch struct {
+	// FundingOutpoint is the funding outpoint of the channel.
+	FundingOutpoint string `json:"fundingOutpoint"`
+
+	// State is the on-chain state of the funding output.
+	State string `json:"state"`
+
+	// ChainValue is the value of the funding output on-chain.
+	ChainValue int64 `json:"chainValue"`
+}
+
+// ReportPolicyMismatch is the JSON representation of a routing policy that
+// doesn't fit the capacity of its channel.
+type ReportPolicyMismatch struct {
+	// NodePubkey is the hex encoded public key of the node that advertised
+	// the policy.
+	NodePubkey string `json:"nodePubkey"`
+
+	// MaxHTLCMsat is the maximum HTLC size advertised by the policy in
+	// millisatoshis.
+	MaxHTLCMsat uint64 `json:"maxHtlcMsat"`
+}
+
+// ReportChannelLoss is the JSON representation of the net amount lost over a
+// single channel.
+type ReportChannelLoss struct {
+	// ChanID is the compact uint64 form of the short channel ID.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// Loss is the amount lost over this channel in satoshis.
+	Loss int64 `json:"loss"`
+
+	// LossFiat is the value of the loss in the fiat currency of the
+	// report, if one was requested.
+	LossFiat *float64 `json:"lossFiat,omitempty"`
+
+	// Forwards are the forwards the loss is made up of, if they were
+	// recorded.
+	Forwards []ReportForward `json:"forwards,omitempty"`
+}
+
+// ReportForward is the JSON representation of a single forward attributed to
+// the loss over an invalid channel.
+type ReportForward struct {
+	// Timestamp is the time the forward was settled at.
+	Timestamp time.Time `json:"timestamp"`
+
+	// ChanIDIn is the compact uint64 form of the short channel ID of the
+	// channel the HTLC came in over.
+	ChanIDIn uint64 `json:"chanIdIn"`
+
+	// ChanIDOut is the compact uint64 form of the short channel ID of the
+	// channel the HTLC went out over.
+	ChanIDOut uint64 `json:"chanIdOut"`
+
+	// Counterparty is the hex encoded public key of the remote peer of
+	// the forward's other channel, if it's still known to the node.
+	Counterparty string `json:"counterparty,omitempty"`
+
+	// AmtIn is the amount that came in in satoshis.
+	AmtIn int64 `json:"amtIn"`
+
+	// AmtOut is the amount that went out in satoshis.
+	AmtOut int64 `json:"amtOut"`
+
+	// Fee is the fee earned for the forward in satoshis.
+	Fee int64 `json:"fee"`
+
+	// Loss is the amount the forward added to the loss over the channel
+	// in satoshis, which is negative if it recovered real coins.
+	Loss int64 `json:"loss"`
+}
+
+// ReportPeerLoss is the JSON representation of the net amount lost to a single
+// remote peer across all of its invalid channels.
+type ReportPeerLoss struct {
+	// RemotePubkey is the hex encoded public key of the remote peer.
+	RemotePubkey string `json:"remotePubkey"`
+
+	// PeerAlias is the alias of the remote peer, if it's known to the
+	// channel graph.
+	PeerAlias string `json:"peerAlias,omitempty"`
+
+	// Loss is the amount lost to this peer in satoshis.
+	Loss int64 `json:"loss"`
+
+	// LossFiat is the value of the loss in the fiat currency of the
+	// report, if one was requested.
+	LossFiat *float64 `json:"lossFiat,omitempty"`
+}
+
+// ReportChannelClose is the JSON representation of how an invalid channel was
+// closed.
+type ReportChannelClose struct {
+	// ChanID is the compact uint64 form of the short channel ID.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// CloseType is the way the channel was closed, as named by lnd.
+	CloseType string `json:"closeType"`
+
+	// ClosingTxHash is the hash of the transaction that closed the
+	// channel.
+	ClosingTxHash string `json:"closingTxHash"`
+
+	// CloseHeight is the height at which the channel was closed.
+	CloseHeight uint32 `json:"closeHeight"`
+
+	// SettledBalance is our balance that was settled on-chain by the
+	// close in satoshis.
+	SettledBalance int64 `json:"settledBalance"`
+
+	// TimeLockedBalance is our balance that was still time-locked
+	// on-chain by the close in satoshis.
+	TimeLockedBalance int64 `json:"timeLockedBalance"`
+}
+
+// ReportBalanceCheck is the JSON representation of the reconciliation of an
+// invalid channel's balance with the forwards over it.
+type ReportBalanceCheck struct {
+	// ChanID is the compact uint64 form of the short channel ID.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// LocalBalance is our current balance within the channel in
+	// satoshis.
+	LocalBalance int64 `json:"localBalance"`
+
+	// RemoteBalance is the remote peer's current balance within the
+	// channel in satoshis.
+	RemoteBalance int64 `json:"remoteBalance"`
+
+	// NetForwarded is the net amount the forwards over the channel added
+	// to our local balance in satoshis.
+	NetForwarded int64 `json:"netForwarded"`
+
+	// ExpectedLocalBalance is our local balance as implied by the
+	// forwards over the channel in satoshis.
+	ExpectedLocalBalance int64 `json:"expectedLocalBalance"`
+
+	// Discrepancy is the absolute difference between the local balance
+	// and the expected local balance in satoshis.
+	Discrepancy int64 `json:"discrepancy"`
+
+	// Incomplete is true if the discrepancy hints at forwarding events
+	// missing from the history, in which case the loss figure of the
+	// channel may be incomplete.
+	Incomplete bool `json:"incomplete"`
+}
+
+// ReportCoverage describes how much of the node was verified by a scan, so a
+// clean result can be told apart from one that simply didn't verify much.
+type ReportCoverage struct {
+	// NumChannels is the number of open channels selected for the scan,
+	// including private channels that were skipped.
+	NumChannels int `json:"numChannels"`
+
+	// NumVerified is the number of channels whose capacity could be
+	// verified, either against the channel graph or on-chain.
+	NumVerified int `json:"numVerified"`
+
+	// TotalCapacity is the capacity of all channels counted by
+	// NumChannels in satoshis.
+	TotalCapacity int64 `json:"totalCapacity"`
+
+	// VerifiedCapacity is the capacity of all channels counted by
+	// NumVerified in satoshis.
+	VerifiedCapacity int64 `json:"verifiedCapacity"`
+
+	// VerifiedPercent is the percentage of channels that were verified.
+	VerifiedPercent float64 `json:"verifiedPercent"`
+
+	// VerifiedCapacityPercent is the percentage of the total capacity
+	// that was verified.
+	VerifiedCapacityPercent float64 `json:"verifiedCapacityPercent"`
+}
+
+// ReportCollision is the JSON representation of several open channels sharing
+// the same short channel ID or funding outpoint.
+type ReportCollision struct {
+	// Kind is what the channels share, either chanid or outpoint.
+	Kind string `json:"kind"`
+
+	// Key is the shared short channel ID in block:tx:output form, or the
+	// shared funding outpoint.
+	Key string `json:"key"`
+
+	// Channels is the set of all channels sharing the key.
+	Channels []ReportCollidingChannel `json:"channels"`
+}
+
+// ReportCollidingChannel is the JSON representation of a single channel of a
+// collision.
+type ReportCollidingChannel struct {
+	// ChanID is the compact uint64 form of the short channel ID.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// ChannelPoint is the funding outpoint of the channel.
+	ChannelPoint string `json:"channelPoint"`
+
+	// RemotePubkey is the hex encoded public key of the channel's remote
+	// peer.
+	RemotePubkey string `json:"remotePubkey"`
+
+	// Capacity is the capacity of the channel from our point of view in
+	// satoshis.
+	Capacity int64 `json:"capacity"`
+}
+
+// ReportLossStats summarizes the distribution of the losses across a set of
+// invalid channels, to tell a single large loss apart from many small ones.
+type ReportLossStats struct {
+	// MeanLoss is the mean loss per invalid channel in satoshis.
+	MeanLoss float64 `json:"meanLoss"`
+
+	// MedianLoss is the median loss per invalid channel in satoshis.
+	MedianLoss float64 `json:"medianLoss"`
+
+	// MaxLoss is the largest loss over a single invalid channel in
+	// satoshis.
+	MaxLoss int64 `json:"maxLoss"`
+
+	// NumWithLoss is the number of invalid channels with a nonzero loss,
+	// which includes channels that recovered more than they lost.
+	NumWithLoss int `json:"numWithLoss"`
+
+	// NumWithoutForwards is the number of invalid channels that weren't
+	// involved in any forward, and thus didn't cause any loss.
+	NumWithoutForwards int `json:"numWithoutForwards"`
+}
+
+// ReportStats describes how long a scan took and how many RPCs it issued to
+// lnd, to diagnose slow scans.
+type ReportStats struct {
+	// DurationMs is the wall-clock time the scan took in milliseconds.
+	DurationMs int64 `json:"durationMs"`
+
+	// Phases is the time spent in each phase of the scan, in the order
+	// they were carried out.
+	Phases []ReportPhase `json:"phases"`
+
+	// RPCs is the number of RPCs of each kind issued to lnd.
+	RPCs ReportRPCCounts `json:"rpcs"`
+}
+
+// ReportPhase is the time spent in a single phase of a scan.
+type ReportPhase struct {
+	// Name is the name of the phase.
+	Name string `json:"name"`
+
+	// DurationMs is the wall-clock time the phase took in milliseconds.
+	DurationMs int64 `json:"durationMs"`
+}
+
+// ReportRPCCounts is the number of RPCs of each kind a scan issued to lnd.
+// Each retry of an RPC counts as one of its own.
+type ReportRPCCounts struct {
+	// ListChannels is the number of ListChannels RPCs.
+	ListChannels uint64 `json:"listChannels"`
+
+	// ClosedChannels is the number of ClosedChannels RPCs.
+	ClosedChannels uint64 `json:"closedChannels"`
+
+	// GetChanInfo is the number of GetChanInfo RPCs.
+	GetChanInfo uint64 `json:"getChanInfo"`
+
+	// DescribeGraph is the number of DescribeGraph RPCs.
+	DescribeGraph uint64 `json:"describeGraph"`
+
+	// GetNodeInfo is the number of GetNodeInfo RPCs.
+	GetNodeInfo uint64 `json:"getNodeInfo"`
+
+	// ForwardingHistory is the number of ForwardingHistory RPCs.
+	ForwardingHistory uint64 `json:"forwardingHistory"`
+
+	// Total is the number of RPCs of all kinds.
+	Total uint64 `json:"total"`
+}
+
+// ReportWatchDiff describes how the findings of a scan in watch mode changed
+// since the previous one.
+type ReportWatchDiff struct {
+	// NewlyInvalid is the short channel ID of each channel flagged as
+	// invalid that wasn't flagged by the previous scan.
+	NewlyInvalid []string `json:"newlyInvalid"`
+
+	// Cleared is the short channel ID of each channel flagged by the
+	// previous scan that no longer is.
+	Cleared []string `json:"cleared"`
+
+	// PreviousTotalLoss is the total loss in satoshis as computed by the
+	// previous scan.
+	PreviousTotalLoss int64 `json:"previousTotalLoss"`
+
+	// TotalLossChange is the change in the total loss in satoshis since
+	// the previous scan.
+	TotalLossChange int64 `json:"totalLossChange"`
+}
+
+// Report is the top-level JSON document chanleakcheck writes when its JSON
+// output mode is selected. Consumers should check SchemaVersion before
+// relying on the meaning of any other field.
+type Report struct {
+	// SchemaVersion is the version of the schema the report follows. It's
+	// set to ReportSchemaVersion by the producer of the report.
+	SchemaVersion int `json:"schemaVersion"`
+
+	// ToolVersion is the version and build information of the
+	// chanleakcheck binary that produced the report.
+	ToolVersion string `json:"toolVersion"`
+
+	// InvalidChannels is the set of channels we confirmed to be invalid.
+	InvalidChannels []ReportChannel `json:"invalidChannels"`
+
+	// NotInGraph is the set of channels that couldn't be found within the
+	// channel graph, and thus couldn't be verified.
+	NotInGraph []ReportChannel `json:"notInGraph"`
+
+	// CheckFailed is the set of channels whose lookup within the channel
+	// graph kept failing for an operational reason, and thus couldn't be
+	// checked at all.
+	CheckFailed []ReportChannel `json:"checkFailed"`
+
+	// IncompleteGraph describes why the channel graph looked implausibly
+	// incomplete during the scan, in which case the channels reported as
+	// missing from it are most likely valid.
+	IncompleteGraph string `json:"incompleteGraph,omitempty"`
+
+	// Collisions is the set of short channel IDs and funding outpoints
+	// shared by several of the node's open channels, which no two genuine
+	// channels ever share.
+	Collisions []ReportCollision `json:"collisions"`
+
+	// Coverage describes how much of the node was verified by the scan.
+	Coverage *ReportCoverage `json:"coverage,omitempty"`
+
+	// ChannelLosses is the per-channel breakdown of the amount lost.
+	ChannelLosses []ReportChannelLoss `json:"channelLosses"`
+
+	// TotalLoss is the sum of all the per-channel losses in satoshis.
+	TotalLoss int64 `json:"totalLoss"`
+
+	// LossStatus describes to what extent the loss could be quantified.
+	LossStatus LossStatus `json:"lossStatus"`
+
+	// LossStats summarizes the distribution of the losses across the
+	// invalid channels. It's only set if invalid channels were found and
+	// the loss could be quantified.
+	LossStats *ReportLossStats `json:"lossStats,omitempty"`
+
+	// LossError is set if the loss couldn't be quantified, for example
+	// because the node's forwarding history couldn't be obtained. The
+	// invalid channels are still reported in that case, but all losses
+	// are left at zero.
+	LossError string `json:"lossError,omitempty"`
+
+	// PeerLosses is the breakdown of the amount lost by remote peer,
+	// ranked by loss with the largest first.
+	PeerLosses []ReportPeerLoss `json:"peerLosses"`
+
+	// ChannelCloses describes how each invalid channel that has since
+	// been closed was resolved on-chain.
+	ChannelCloses []ReportChannelClose `json:"channelCloses"`
+
+	// BalanceChecks holds the reconciliation of the balance of each open
+	// invalid channel with the forwards over it.
+	BalanceChecks []ReportBalanceCheck `json:"balanceChecks"`
+
+	// FiatCurrency is the fiat currency the losses were converted to, if
+	// one was requested and its price could be obtained.
+	FiatCurrency string `json:"fiatCurrency,omitempty"`
+
+	// FiatPrice is the price of one BTC in FiatCurrency that the losses
+	// were converted at.
+	FiatPrice float64 `json:"fiatPrice,omitempty"`
+
+	// TotalLossFiat is the value of the total loss in FiatCurrency.
+	TotalLossFiat *float64 `json:"totalLossFiat,omitempty"`
+
+	// Stats describes how long the scan took and how many RPCs it
+	// issued to lnd.
+	Stats *ReportStats `json:"stats,omitempty"`
+
+	// WatchDiff describes how the findings changed since the previous
+	// scan. It's only set in watch mode.
+	WatchDiff *ReportWatchDiff `json:"watchDiff,omitempty"`
+}
@@ -0,0 +1,182 @@
+package chanleak
+
+import (
+	"context"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultMaxRetries is the default number of times a failed RPC is
+	// retried before giving up.
+	DefaultMaxRetries = 3
+
+	// initialRetryBackoff is the time we'll wait before the first retry of
+	// a failed RPC. The backoff is doubled after each attempt.
+	initialRetryBackoff = time.Second
+
+	// maxRetryBackoff is the longest we'll ever wait between two attempts.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// retryClient is an LndClient that retries RPCs which failed due to transient
+// errors, such as the connection to lnd being interrupted.
+type retryClient struct {
+	client     LndClient
+	maxRetries int
+}
+
+// A compile-time check to ensure retryClient satisfies LndClient.
+var _ LndClient = (*retryClient)(nil)
+
+// newRetryClient returns an LndClient that retries each failed RPC of the
+// given client up to maxRetries times with exponential backoff.
+func newRetryClient(client LndClient, maxRetries int) *retryClient {
+	return &retryClient{
+		client:     client,
+		maxRetries: maxRetries,
+	}
+}
+
+// isTransientErr returns true if the given RPC error is likely to go away on
+// its own, in which case the RPC is worth retrying. Errors such as failed
+// authentication or a channel that doesn't exist are never retried.
+func isTransientErr(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// retry executes the given RPC until it succeeds, fails with a non-transient
+// error, or the maximum number of retries is reached.
+func (r *retryClient) retry(ctx context.Context, rpcName string,
+	rpc func() error) error {
+
+	backoff := initialRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := rpc()
+		if err == nil {
+			return nil
+		}
+
+		// If our own context is done, then the deadline wasn't caused
+		// by a transient error, and there's no point in retrying.
+		if attempt >= r.maxRetries || !isTransientErr(err) ||
+			ctx.Err() != nil {
+
+			return err
+		}
+
+		log.Warnf("%v failed, retrying in %v (attempt %d of %d): %v",
+			rpcName, backoff, attempt+1, r.maxRetries, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// ListChannels returns the set of currently open channels of the node.
+func (r *retryClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	var resp *lnrpc.ListChannelsResponse
+	err := r.retry(ctx, "ListChannels", func() error {
+		var err error
+		resp, err = r.client.ListChannels(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+func (r *retryClient) ClosedChannels(ctx context.Context,
+	in *lnrpc.ClosedChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	var resp *lnrpc.ClosedChannelsResponse
+	err := r.retry(ctx, "ClosedChannels", func() error {
+		var err error
+		resp, err = r.client.ClosedChannels(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (r *retryClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	var resp *lnrpc.ChannelEdge
+	err := r.retry(ctx, "GetChanInfo", func() error {
+		var err error
+		resp, err = r.client.GetChanInfo(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (r *retryClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	var resp *lnrpc.ChannelGraph
+	err := r.retry(ctx, "DescribeGraph", func() error {
+		var err error
+		resp, err = r.client.DescribeGraph(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (r *retryClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	var resp *lnrpc.NodeInfo
+	err := r.retry(ctx, "GetNodeInfo", func() error {
+		var err error
+		resp, err = r.client.GetNodeInfo(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (r *retryClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	var resp *lnrpc.ForwardingHistoryResponse
+	err := r.retry(ctx, "ForwardingHistory", func() error {
+		var err error
+		resp, err = r.client.ForwardingHistory(ctx, in, opts...)
+		return err
+	})
+
+	return resp, err
+}
@@ -0,0 +1,71 @@
+package chanleak
+
+import (
+	"github.com/btcsuite/btcutil"
+)
+
+// SeverityLossWeight is how much more each satoshi of loss attributed to an
+// invalid channel weighs into its severity than each satoshi of its mismatch.
+// The loss was actually drained from the node, while the mismatch only bounds
+// what could still be.
+const SeverityLossWeight = 10
+
+// MismatchMagnitude returns by how much the capacity the node believes an
+// invalid channel has is off. This is the difference to the graph capacity, to
+// the capacity within the cross-check graph, or to the value of the funding
+// output on-chain, whichever is largest. If none differs, such as for a
+// channel whose capacity is wrong within both the node's view and the graph,
+// its whole capacity is considered to be off.
+func MismatchMagnitude(channel InvalidChannel) btcutil.Amount {
+	var magnitude btcutil.Amount
+	if channel.InGraph {
+		magnitude = absAmount(
+			channel.SubjectiveCapacity - channel.GraphCapacity,
+		)
+	}
+	if channel.InCrossGraph {
+		crossDiff := absAmount(
+			channel.SubjectiveCapacity - channel.CrossGraphCapacity,
+		)
+		if crossDiff > magnitude {
+			magnitude = crossDiff
+		}
+	}
+	if channel.ChainMismatch != nil {
+		chainDiff := absAmount(
+			channel.SubjectiveCapacity -
+				channel.ChainMismatch.ChainValue,
+		)
+		if chainDiff > magnitude {
+			magnitude = chainDiff
+		}
+	}
+
+	if magnitude == 0 {
+		return channel.SubjectiveCapacity
+	}
+
+	return magnitude
+}
+
+// Severity returns a score of how alarming an invalid channel is, given the
+// magnitude of its mismatch and the net loss attributed to it. Each satoshi of
+// loss weighs SeverityLossWeight times as much as each satoshi of mismatch, so
+// a channel that drained coins outranks a similar one that didn't. Coins
+// recovered over the channel don't lower the score below its mismatch.
+func Severity(mismatch, loss btcutil.Amount) int64 {
+	if loss < 0 {
+		loss = 0
+	}
+
+	return int64(mismatch) + SeverityLossWeight*int64(loss)
+}
+
+// absAmount returns the absolute value of the given amount.
+func absAmount(amt btcutil.Amount) btcutil.Amount {
+	if amt < 0 {
+		return -amt
+	}
+
+	return amt
+}
@@ -0,0 +1,105 @@
+package chanleak
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChannelSource provides our subjective view of the node's open channels,
+// which the Checker verifies against the channel graph.
+type ChannelSource interface {
+	// OpenChannels returns the set of currently open channels of the
+	// node.
+	OpenChannels(ctx context.Context) ([]*lnrpc.Channel, error)
+}
+
+// clientChannelSource is a ChannelSource backed by the ListChannels RPC of the
+// target node.
+type clientChannelSource struct {
+	client LndClient
+}
+
+// NewClientChannelSource returns a ChannelSource that obtains the open
+// channels through the ListChannels RPC of the given client. This is the
+// source a Checker uses unless another one is configured.
+func NewClientChannelSource(client LndClient) ChannelSource {
+	return &clientChannelSource{
+		client: client,
+	}
+}
+
+// OpenChannels returns the set of currently open channels of the node.
+func (c *clientChannelSource) OpenChannels(
+	ctx context.Context) ([]*lnrpc.Channel, error) {
+
+	resp, err := c.client.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Channels, nil
+}
+
+// StaticChannelSource is a ChannelSource serving a fixed set of channels, such
+// as one taken from the node operator's own records.
+type StaticChannelSource []*lnrpc.Channel
+
+// OpenChannels returns the fixed set of channels.
+func (s StaticChannelSource) OpenChannels(
+	_ context.Context) ([]*lnrpc.Channel, error) {
+
+	return s, nil
+}
+
+// fallbackChannelSource is a ChannelSource that obtains the open channels from
+// a primary source, and falls back to a second one if the primary fails.
+type fallbackChannelSource struct {
+	primary  ChannelSource
+	fallback ChannelSource
+
+	// warnOnce ensures we only warn about falling back once, as the open
+	// channels are obtained several times per scan.
+	warnOnce sync.Once
+}
+
+// newFallbackChannelSource returns a ChannelSource that obtains the open
+// channels from the primary source, and from the fallback source whenever the
+// primary refuses to list them, such as a node that restricts ListChannels.
+func newFallbackChannelSource(primary, fallback ChannelSource) ChannelSource {
+	return &fallbackChannelSource{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+// OpenChannels returns the set of currently open channels of the node.
+func (f *fallbackChannelSource) OpenChannels(
+	ctx context.Context) ([]*lnrpc.Channel, error) {
+
+	channels, err := f.primary.OpenChannels(ctx)
+	if err == nil {
+		return channels, nil
+	}
+
+	// Only a node that refuses to list its channels altogether calls for
+	// the fallback. Any other failure, such as lnd being briefly
+	// unreachable, would have us silently verify stale channels, so we'll
+	// surface it instead.
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unimplemented:
+
+	default:
+		return nil, err
+	}
+
+	f.warnOnce.Do(func() {
+		log.Warnf("Unable to obtain open channels from the node, "+
+			"falling back to the configured channel set: %v", err)
+	})
+
+	return f.fallback.OpenChannels(ctx)
+}
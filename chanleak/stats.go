@@ -0,0 +1,147 @@
+package chanleak
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// RPCCounts is the number of RPCs of each kind a Checker issued to lnd. Each
+// retry of an RPC counts as one of its own.
+type RPCCounts struct {
+	// ListChannels is the number of ListChannels RPCs.
+	ListChannels uint64
+
+	// ClosedChannels is the number of ClosedChannels RPCs.
+	ClosedChannels uint64
+
+	// GetChanInfo is the number of GetChanInfo RPCs.
+	GetChanInfo uint64
+
+	// DescribeGraph is the number of DescribeGraph RPCs.
+	DescribeGraph uint64
+
+	// GetNodeInfo is the number of GetNodeInfo RPCs.
+	GetNodeInfo uint64
+
+	// ForwardingHistory is the number of ForwardingHistory RPCs.
+	ForwardingHistory uint64
+}
+
+// Sub returns the number of RPCs issued since the given earlier counts were
+// taken, such as those of a single scan of a long-lived Checker.
+func (r RPCCounts) Sub(earlier RPCCounts) RPCCounts {
+	return RPCCounts{
+		ListChannels:   r.ListChannels - earlier.ListChannels,
+		ClosedChannels: r.ClosedChannels - earlier.ClosedChannels,
+		GetChanInfo:    r.GetChanInfo - earlier.GetChanInfo,
+		DescribeGraph:  r.DescribeGraph - earlier.DescribeGraph,
+		GetNodeInfo:    r.GetNodeInfo - earlier.GetNodeInfo,
+		ForwardingHistory: r.ForwardingHistory -
+			earlier.ForwardingHistory,
+	}
+}
+
+// Total returns the number of RPCs of all kinds.
+func (r RPCCounts) Total() uint64 {
+	return r.ListChannels + r.ClosedChannels + r.GetChanInfo +
+		r.DescribeGraph + r.GetNodeInfo + r.ForwardingHistory
+}
+
+// RPCCounts returns the number of RPCs of each kind the Checker issued to lnd
+// since it was created.
+func (c *Checker) RPCCounts() RPCCounts {
+	return c.rpcCounter.counts()
+}
+
+// countingClient is an LndClient that counts the RPCs issued through it. The
+// counters are updated atomically, as the workers of a scan share the client.
+type countingClient struct {
+	client LndClient
+
+	listChannels      uint64
+	closedChannels    uint64
+	getChanInfo       uint64
+	describeGraph     uint64
+	getNodeInfo       uint64
+	forwardingHistory uint64
+}
+
+// A compile-time check to ensure countingClient satisfies LndClient.
+var _ LndClient = (*countingClient)(nil)
+
+// newCountingClient returns an LndClient counting the RPCs it passes on to the
+// given client.
+func newCountingClient(client LndClient) *countingClient {
+	return &countingClient{
+		client: client,
+	}
+}
+
+// counts returns the number of RPCs issued so far.
+func (c *countingClient) counts() RPCCounts {
+	return RPCCounts{
+		ListChannels:      atomic.LoadUint64(&c.listChannels),
+		ClosedChannels:    atomic.LoadUint64(&c.closedChannels),
+		GetChanInfo:       atomic.LoadUint64(&c.getChanInfo),
+		DescribeGraph:     atomic.LoadUint64(&c.describeGraph),
+		GetNodeInfo:       atomic.LoadUint64(&c.getNodeInfo),
+		ForwardingHistory: atomic.LoadUint64(&c.forwardingHistory),
+	}
+}
+
+// ListChannels returns the set of currently open channels of the node.
+func (c *countingClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	atomic.AddUint64(&c.listChannels, 1)
+	return c.client.ListChannels(ctx, in, opts...)
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+func (c *countingClient) ClosedChannels(ctx context.Context,
+	in *lnrpc.ClosedChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	atomic.AddUint64(&c.closedChannels, 1)
+	return c.client.ClosedChannels(ctx, in, opts...)
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (c *countingClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	atomic.AddUint64(&c.getChanInfo, 1)
+	return c.client.GetChanInfo(ctx, in, opts...)
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (c *countingClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	atomic.AddUint64(&c.describeGraph, 1)
+	return c.client.DescribeGraph(ctx, in, opts...)
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (c *countingClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	atomic.AddUint64(&c.getNodeInfo, 1)
+	return c.client.GetNodeInfo(ctx, in, opts...)
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (c *countingClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	atomic.AddUint64(&c.forwardingHistory, 1)
+	return c.client.ForwardingHistory(ctx, in, opts...)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lncfg"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/macaroons"
+	"github.com/lightningnetwork/lnd/tor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultRPCPort is the port we'll connect to if the host doesn't
+	// specify one.
+	defaultRPCPort = "10009"
+
+	// defaultTorSOCKS is the SOCKS proxy we'll connect through if the host
+	// is an onion address and no proxy was specified. This is the default
+	// SOCKS port of the Tor daemon.
+	defaultTorSOCKS = "127.0.0.1:9050"
+
+	// defaultMaxMsgSize is the default size in MB of the largest gRPC
+	// message our client will receive.
+	defaultMaxMsgSize = 50
+
+	// tlsCertEnv is the environment variable that may carry the encoded
+	// TLS cert of the target lnd node in place of the -tlscert flag.
+	tlsCertEnv = "LND_TLSCERT_HEX"
+
+	// macaroonEnv is the environment variable that may carry the encoded
+	// macaroon of the target lnd node in place of the -macaroon flag.
+	macaroonEnv = "LND_MACAROON_HEX"
+)
+
+// credentialFromFlagOrEnv returns the value of the given flag, or if it is
+// empty, the value of the given environment variable.
+func credentialFromFlagOrEnv(flagValue, envName string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	return os.Getenv(envName)
+}
+
+// decodeCredential decodes a credential that was passed in as a string. Hex
+// is the preferred encoding, but we'll also accept standard base64 as that's
+// what most secret stores hand out by default.
+func decodeCredential(encoded string) ([]byte, error) {
+	if b, err := hex.DecodeString(encoded); err == nil {
+		return b, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("credential is neither valid hex nor " +
+			"base64")
+	}
+
+	return b, nil
+}
+
+// loadTLSCertPool returns the cert pool used to verify the TLS cert of the
+// node of the given profile. The encoded cert of the profile takes precedence
+// over its cert path.
+func loadTLSCertPool(profile *nodeProfile) (*x509.CertPool, error) {
+	var certBytes []byte
+	if profile.TLSCert != "" {
+		var err error
+		certBytes, err = decodeCredential(profile.TLSCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode tls cert: %v",
+				err)
+		}
+	} else {
+		var err error
+		certBytes, err = ioutil.ReadFile(profile.TLSPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tls cert: %v",
+				err)
+		}
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(certBytes) {
+		return nil, fmt.Errorf("unable to parse tls cert")
+	}
+
+	return certPool, nil
+}
+
+// loadTLSCredentials returns the transport credentials used to connect to the
+// node of the given profile over gRPC.
+func loadTLSCredentials(
+	profile *nodeProfile) (credentials.TransportCredentials, error) {
+
+	certPool, err := loadTLSCertPool(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewClientTLSFromCert(certPool, ""), nil
+}
+
+// socksProxy returns the SOCKS proxy we should connect to the node of the
+// given profile through, or an empty string if we should connect directly.
+// Unless a proxy was specified explicitly, we'll only use one if the host is
+// an onion address.
+func socksProxy(profile *nodeProfile) string {
+	if profile.SOCKS != "" {
+		return profile.SOCKS
+	}
+
+	hostname, _, err := net.SplitHostPort(profile.Host)
+	if err != nil {
+		hostname = profile.Host
+	}
+	if tor.IsOnionHost(hostname) {
+		return defaultTorSOCKS
+	}
+
+	return ""
+}
+
+// newSOCKSDialer returns a gRPC dialer that connects to the target address
+// through the given SOCKS proxy.
+func newSOCKSDialer(socksAddr string) func(string,
+	time.Duration) (net.Conn, error) {
+
+	return func(addr string, _ time.Duration) (net.Conn, error) {
+		// Unlike the regular dialer, we'll need to add the default
+		// port ourselves if it's missing.
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, defaultRPCPort)
+		}
+
+		return tor.Dial(addr, socksAddr, false)
+	}
+}
+
+// isMsgSizeErr returns true if the given error was caused by a gRPC response
+// exceeding the maximum message size of our client.
+func isMsgSizeErr(err error) bool {
+	if status.Code(err) == codes.ResourceExhausted {
+		return true
+	}
+
+	// Most RPC errors are wrapped before they reach us, which strips their
+	// gRPC status, so we'll also look for the code within the message.
+	return strings.Contains(
+		err.Error(), "code = "+codes.ResourceExhausted.String(),
+	)
+}
+
+// nodeClient is the set of lnd RPCs chanleakcheck relies on. It's satisfied by
+// both the gRPC client and the REST client.
+type nodeClient interface {
+	chanleak.LndClient
+
+	// GetInfo returns general information about the node.
+	GetInfo(ctx context.Context, in *lnrpc.GetInfoRequest,
+		opts ...grpc.CallOption) (*lnrpc.GetInfoResponse, error)
+}
+
+// A compile-time check to ensure the lnd gRPC client satisfies nodeClient.
+var _ nodeClient = (lnrpc.LightningClient)(nil)
+
+// newLndClient creates a new client for the node of the given profile. Unless
+// the profile selects REST mode, we'll connect to lnd's gRPC interface. When
+// replaying a snapshot, no connection is made at all, and neither are any
+// permissions of a macaroon enforced.
+func newLndClient(profile *nodeProfile) (nodeClient, error) {
+	if *replayPath != "" {
+		return loadReplayClient(*replayPath)
+	}
+
+	if exportMode() {
+		return loadExportClient(*graphExport, *channelsExport)
+	}
+
+	allowed, err := parseBakemac(*bakeMac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -bakemac: %v", err)
+	}
+
+	var client nodeClient
+	if profile.REST {
+		client, err = newRESTClient(profile)
+	} else {
+		client, err = newGRPCClient(profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newPermissionClient(client, allowed), nil
+}
+
+// newGRPCClient creates a new gRPC client for the node of the given profile.
+func newGRPCClient(profile *nodeProfile) (lnrpc.LightningClient, error) {
+	creds, err := loadTLSCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := loadMacaroon(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// We need to use a custom dialer so we can also connect to unix
+	// sockets and not just TCP addresses. If we're connecting through a
+	// SOCKS proxy, we'll use a proxied dialer instead. As the target host
+	// is still used as the TLS server name, the cert of the node is
+	// verified against its onion address as usual.
+	dialer := lncfg.ClientAddressDialer(defaultRPCPort)
+	if socksAddr := socksProxy(profile); socksAddr != "" {
+		log.Infof("Connecting to %v through SOCKS proxy %v",
+			profile.Host, socksAddr)
+
+		dialer = newSOCKSDialer(socksAddr)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(macaroons.NewMacaroonCredential(mac)),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(*maxMsgSize * 1024 * 1024),
+		),
+		grpc.WithDialer(dialer),
+	}
+
+	conn, err := grpc.Dial(profile.Host, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to RPC server: %v",
+			err)
+	}
+
+	return lnrpc.NewLightningClient(conn), nil
+}
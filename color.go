@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// colorRed is the ANSI escape sequence highlighting invalid channels
+	// and the loss they caused.
+	colorRed = "\x1b[31m"
+
+	// colorGreen is the ANSI escape sequence highlighting a clean scan.
+	colorGreen = "\x1b[32m"
+
+	// colorReset is the ANSI escape sequence resetting the color set by
+	// either of the above.
+	colorReset = "\x1b[0m"
+
+	// noColorEnv is the environment variable that disables colored output
+	// when set to any non-empty value, as suggested by https://no-color.org.
+	noColorEnv = "NO_COLOR"
+)
+
+// useColor returns true if the output written to the given file should be
+// colored, which is only the case if it's a terminal. Redirecting the output
+// to a file or pipe, setting -no-color or setting NO_COLOR disables it.
+func useColor(f *os.File) bool {
+	if *noColor || os.Getenv(noColorEnv) != "" {
+		return false
+	}
+
+	return terminal.IsTerminal(int(f.Fd()))
+}
+
+// colorize wraps the given text in the given color if enabled is true, and
+// otherwise returns it unchanged.
+func colorize(text, color string, enabled bool) string {
+	if !enabled {
+		return text
+	}
+
+	return color + text + colorReset
+}
+
+// outcomeColor returns the color highlighting the outcome of a scan that found
+// the given number of invalid channels.
+func outcomeColor(numInvalid int) string {
+	if numInvalid > 0 {
+		return colorRed
+	}
+
+	return colorGreen
+}
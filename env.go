@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables any flag can be set
+// through, such as CHANLEAKCHECK_HOST for -host.
+const envPrefix = "CHANLEAKCHECK_"
+
+// envName returns the name of the environment variable the flag with the
+// given name can be set through. Dashes aren't allowed in the names of
+// environment variables, so they're replaced by underscores.
+func envName(flagName string) string {
+	name := strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+	return envPrefix + name
+}
+
+// applyEnvFlags sets every flag that wasn't given on the command line from its
+// environment variable, if that's set. As this goes over all registered flags,
+// new flags can be set through the environment without any further changes.
+// Flags given on the command line always take precedence.
+func applyEnvFlags() error {
+	var envFlags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		if !flagIsSet(f.Name) {
+			envFlags = append(envFlags, f)
+		}
+	})
+
+	for _, f := range envFlags {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			continue
+		}
+
+		if err := flag.Set(f.Name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %v: %v", value,
+				envName(f.Name), err)
+		}
+	}
+
+	return nil
+}
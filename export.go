@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// exportAlias is the alias reported for a node scanned from exports, as they
+// don't record the node's identity.
+const exportAlias = "lncli export"
+
+// exportClient serves a scan from the output of `lncli describegraph` and
+// `lncli listchannels` copied off the node, so the node can be audited on an
+// air-gapped machine without granting the tool any macaroon. As the exports
+// don't include the node's forwarding history, the loss can't be quantified.
+type exportClient struct {
+	*replayClient
+}
+
+// A compile-time check to ensure exportClient satisfies nodeClient.
+var _ nodeClient = (*exportClient)(nil)
+
+// exportMode returns true if the scan is served from lncli exports rather than
+// a live node.
+func exportMode() bool {
+	return *graphExport != "" || *channelsExport != ""
+}
+
+// loadExportClient returns a client serving the graph and channels exports at
+// the given paths.
+func loadExportClient(graphPath, channelsPath string) (*exportClient, error) {
+	client := &exportClient{
+		replayClient: &replayClient{
+			getInfo:        &lnrpc.GetInfoResponse{},
+			listChannels:   &lnrpc.ListChannelsResponse{},
+			closedChannels: &lnrpc.ClosedChannelsResponse{},
+			describeGraph:  &lnrpc.ChannelGraph{},
+			edges:          make(map[uint64]*lnrpc.ChannelEdge),
+			nodeInfo:       make(map[string]*lnrpc.NodeInfo),
+		},
+	}
+
+	err := readExport("channels", channelsPath, client.listChannels)
+	if err != nil {
+		return nil, err
+	}
+	err = readExport("graph", graphPath, client.describeGraph)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, edge := range client.describeGraph.Edges {
+		client.edges[edge.ChannelId] = edge
+	}
+	for _, node := range client.describeGraph.Nodes {
+		client.nodeInfo[node.PubKey] = &lnrpc.NodeInfo{Node: node}
+	}
+
+	// The exports don't record which node they were taken from, but the
+	// node is the one end all of its public channels have in common. The
+	// exports were taken from a node that was in a state worth auditing,
+	// so we'll report it as synced.
+	client.getInfo.IdentityPubkey = client.identityPubkey()
+	client.getInfo.Alias = exportAlias
+	client.getInfo.SyncedToChain = true
+	client.getInfo.SyncedToGraph = true
+
+	log.Infof("Scanning %v channels from %v against %v edges from %v",
+		len(client.listChannels.Channels), channelsPath,
+		len(client.describeGraph.Edges), graphPath)
+
+	return client, nil
+}
+
+// loadChannelsFallback loads the export of lncli listchannels at the given
+// path, which is verified in place of the node's own channels if it refuses to
+// list them.
+func loadChannelsFallback(path string) (chanleak.StaticChannelSource, error) {
+	channelsResp := &lnrpc.ListChannelsResponse{}
+	if err := readExport("channels", path, channelsResp); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Loaded %v fallback channels from %v",
+		len(channelsResp.Channels), path)
+
+	return chanleak.StaticChannelSource(channelsResp.Channels), nil
+}
+
+// readExport decodes the JSON output of an lncli command from the file at the
+// given path.
+func readExport(name, path string, msg proto.Message) error {
+	exportBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read %v export: %v", name, err)
+	}
+
+	if err := unmarshalProto(exportBytes, msg); err != nil {
+		return fmt.Errorf("unable to decode %v export %v: %v", name,
+			path, err)
+	}
+
+	return nil
+}
+
+// identityPubkey returns the public key of the node the exports were taken
+// from, which is the end of its public channels that isn't the remote peer. If
+// none of its channels are part of the graph, it's unknown and left empty.
+func (e *exportClient) identityPubkey() string {
+	for _, channel := range e.listChannels.Channels {
+		edge, ok := e.edges[channel.ChanId]
+		if !ok {
+			continue
+		}
+
+		switch channel.RemotePubkey {
+		case edge.Node1Pub:
+			return edge.Node2Pub
+
+		case edge.Node2Pub:
+			return edge.Node1Pub
+		}
+	}
+
+	return ""
+}
+
+// ForwardingHistory always fails, as the forwarding history isn't part of the
+// exports.
+func (e *exportClient) ForwardingHistory(_ context.Context,
+	_ *lnrpc.ForwardingHistoryRequest,
+	_ ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	return nil, fmt.Errorf("the forwarding history isn't part of the " +
+		"lncli exports, use -dump and -replay to quantify the loss " +
+		"offline")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+const (
+	// failOnMismatch selects channels whose graph capacity doesn't match
+	// our own view of them, or doesn't fit their routing policies.
+	failOnMismatch = "mismatch"
+
+	// failOnMissing selects channels missing from the channel graph.
+	failOnMissing = "missing"
+
+	// failOnMalformed selects channels whose graph capacity no channel
+	// could possibly have, or whose channel point is malformed.
+	failOnMalformed = "malformed"
+
+	// failOnChain selects channels whose funding output doesn't match
+	// on-chain.
+	failOnChain = "chain"
+
+	// failOnCollision selects open channels sharing the same short
+	// channel ID or funding outpoint.
+	failOnCollision = "collision"
+)
+
+// failOnCategories is the set of all categories of findings -fail-on accepts.
+var failOnCategories = []string{
+	failOnMismatch, failOnMissing, failOnMalformed, failOnChain,
+	failOnCollision,
+}
+
+// parseFailOn parses the comma-separated set of categories of findings that
+// make a scan exit with exitCodeInvalidChannels.
+func parseFailOn(s string) (map[string]struct{}, error) {
+	categories := make(map[string]struct{})
+	for _, category := range strings.Split(s, ",") {
+		category = strings.TrimSpace(category)
+
+		var known bool
+		for _, c := range failOnCategories {
+			if category == c {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown category %q, must be "+
+				"one of %v", category,
+				strings.Join(failOnCategories, ", "))
+		}
+
+		categories[category] = struct{}{}
+	}
+
+	return categories, nil
+}
+
+// findingsExitCode returns the exit code of a completed scan with the given
+// result. Only the categories of findings selected by -fail-on make the scan
+// exit with exitCodeInvalidChannels, so a CI gate can be tuned to the node,
+// such as one whose graph is known to miss some of its channels. A scan
+// without such findings still fails if some channels couldn't be checked, as
+// it can't vouch for the node then.
+func findingsExitCode(result *chanleak.ScanResult) int {
+	// The flag was validated before the scan, so it can't fail to parse.
+	categories, _ := parseFailOn(*failOn)
+	selected := func(category string) bool {
+		_, ok := categories[category]
+		return ok
+	}
+
+	if selected(failOnMissing) && len(result.NotInGraph) > 0 {
+		return exitCodeInvalidChannels
+	}
+
+	if selected(failOnCollision) && len(result.Collisions) > 0 {
+		return exitCodeInvalidChannels
+	}
+
+	for _, channel := range result.InvalidChannels {
+		mismatch := channel.CapacityMismatch || channel.CrossMismatch ||
+			len(channel.PolicyMismatches) > 0
+
+		switch {
+		case selected(failOnMismatch) && mismatch,
+			selected(failOnMalformed) && channel.Malformed != "",
+			selected(failOnChain) && channel.ChainMismatch != nil:
+
+			return exitCodeInvalidChannels
+		}
+	}
+
+	if len(result.CheckFailed) > 0 {
+		log.Errorf("Unable to check %v channels, the scan is "+
+			"incomplete", len(result.CheckFailed))
+		return exitCodeFailure
+	}
+
+	return exitCodeClean
+}
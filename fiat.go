@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// defaultPriceURL is the public API the BTC spot price is fetched from
+	// if -fiat is set. The {currency} placeholder is replaced with the
+	// lower case currency code.
+	defaultPriceURL = "https://api.coingecko.com/api/v3/simple/price?" +
+		"ids=bitcoin&vs_currencies={currency}"
+
+	// priceFetchTimeout is the maximum time we'll wait for the price feed
+	// to respond.
+	priceFetchTimeout = 10 * time.Second
+)
+
+// fiatRate is the price of a single bitcoin in a fiat currency.
+type fiatRate struct {
+	// currency is the upper case code of the fiat currency.
+	currency string
+
+	// price is the price of one BTC in the fiat currency.
+	price float64
+}
+
+// convert returns the value of the given amount in the fiat currency.
+func (r *fiatRate) convert(amt btcutil.Amount) float64 {
+	return amt.ToBTC() * r.price
+}
+
+// formatLoss formats the given amount for display, along with its fiat value
+// if a rate is known.
+func formatLoss(amt btcutil.Amount, rate *fiatRate) string {
+	if rate == nil {
+		return amt.String()
+	}
+
+	return fmt.Sprintf("%v (~%.2f %v)", amt, rate.convert(amt),
+		rate.currency)
+}
+
+// obtainFiatRate returns the fiat rate losses should be converted at, or nil
+// if no fiat currency was requested. A fixed rate given through -price takes
+// precedence over the price feed. If the price feed can't be reached, we'll
+// fall back to reporting satoshis only, as a missing price shouldn't keep the
+// losses from being reported.
+func obtainFiatRate(ctx context.Context) *fiatRate {
+	if *fiatCurrency == "" {
+		return nil
+	}
+
+	currency := strings.ToUpper(*fiatCurrency)
+	if *fixedPrice > 0 {
+		return &fiatRate{
+			currency: currency,
+			price:    *fixedPrice,
+		}
+	}
+
+	price, err := fetchPrice(ctx, *priceURL, currency)
+	if err != nil {
+		log.Warnf("Unable to fetch BTC price in %v, reporting "+
+			"losses in satoshis only: %v", currency, err)
+		return nil
+	}
+
+	log.Infof("Using BTC price of %.2f %v", price, currency)
+
+	return &fiatRate{
+		currency: currency,
+		price:    price,
+	}
+}
+
+// fetchPrice fetches the BTC spot price in the given currency from the price
+// feed at the given URL. The feed is expected to respond in the format of the
+// default feed: {"bitcoin": {"<currency>": <price>}}.
+func fetchPrice(ctx context.Context, url, currency string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, priceFetchTimeout)
+	defer cancel()
+
+	currency = strings.ToLower(currency)
+	url = strings.Replace(url, "{currency}", currency, -1)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("price feed returned status %v",
+			resp.Status)
+	}
+
+	var prices map[string]map[string]float64
+	if err := json.NewDecoder(resp.Body).Decode(&prices); err != nil {
+		return 0, fmt.Errorf("unable to decode price feed response: "+
+			"%v", err)
+	}
+
+	price, ok := prices["bitcoin"][currency]
+	if !ok || price <= 0 {
+		return 0, fmt.Errorf("price feed has no BTC price in %v",
+			currency)
+	}
+
+	return price, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes a file to the given path using the given function.
+// The file is first written to a temporary file within the same directory,
+// which is then renamed into place, so a crash midway never leaves a partial
+// file behind.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmpFile, err := ioutil.TempFile(
+		filepath.Dir(path), "."+filepath.Base(path)+".tmp",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to create %v: %v", path, err)
+	}
+
+	// If anything goes wrong before the rename, we'll clean up after
+	// ourselves. Once renamed, the removal is a no-op.
+	defer os.Remove(tmpFile.Name())
+
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("unable to write %v: %v", path, err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("unable to write %v: %v", path, err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("unable to write %v: %v", path, err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("unable to write %v: %v", path, err)
+	}
+
+	return nil
+}
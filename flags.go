@@ -0,0 +1,585 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+// maxBlockHeight is the largest block height that can be encoded within a
+// short channel ID.
+const maxBlockHeight = 1<<24 - 1
+
+// validateFlags checks the given flags for invalid values and combinations,
+// so we can fail fast with an actionable error before connecting to any node.
+func validateFlags() error {
+	switch *outputFormat {
+	case outputText, outputJSON:
+	default:
+		return fmt.Errorf("unknown output format %q, must be one of "+
+			"%v or %v", *outputFormat, outputText, outputJSON)
+	}
+
+	switch *sortOrder {
+	case sortChanID, sortLoss, sortSeverity:
+	default:
+		return fmt.Errorf("unknown sort order %q, must be one of %v, "+
+			"%v or %v", *sortOrder, sortChanID, sortLoss,
+			sortSeverity)
+	}
+
+	if *jsonStream && *outputFormat == outputJSON {
+		return fmt.Errorf("-json-stream can't be combined with " +
+			"-output json, as both write to stdout")
+	}
+
+	if *table && *logFormat != logFormatText {
+		return fmt.Errorf("-table can't be combined with -logformat "+
+			"%v, as it would break up the structured log lines",
+			*logFormat)
+	}
+
+	if err := validateNetwork(*network); err != nil {
+		return err
+	}
+
+	switch *graphMode {
+	case chanleak.GraphModeDescribe, chanleak.GraphModeLookup:
+	default:
+		return fmt.Errorf("unknown graph mode %q, must be one of %v "+
+			"or %v", *graphMode, chanleak.GraphModeDescribe,
+			chanleak.GraphModeLookup)
+	}
+
+	switch *channelState {
+	case chanleak.ChannelStateAll, chanleak.ChannelStateActive,
+		chanleak.ChannelStateInactive:
+
+	default:
+		return fmt.Errorf("unknown channel state %q, must be one of "+
+			"%v, %v or %v", *channelState, chanleak.ChannelStateAll,
+			chanleak.ChannelStateActive,
+			chanleak.ChannelStateInactive)
+	}
+
+	if *maxMsgSize <= 0 {
+		return fmt.Errorf("-maxmsgsize must be positive")
+	}
+
+	if *numWorkers < 0 {
+		return fmt.Errorf("-workers must not be negative")
+	}
+
+	if *retries < 0 {
+		return fmt.Errorf("-retries must not be negative")
+	}
+
+	if *timeout < 0 {
+		return fmt.Errorf("-timeout must not be negative, use 0 to " +
+			"disable it")
+	}
+
+	if _, err := parseFailOn(*failOn); err != nil {
+		return fmt.Errorf("invalid -fail-on: %v", err)
+	}
+
+	if *rateLimit < 0 {
+		return fmt.Errorf("-rate must not be negative, use 0 to " +
+			"disable the limit")
+	}
+
+	if *limit < 0 {
+		return fmt.Errorf("-limit must not be negative, use 0 to " +
+			"disable it")
+	}
+
+	if *tolerance < 0 {
+		return fmt.Errorf("-tolerance must not be negative")
+	}
+
+	if *minCapacity < 0 {
+		return fmt.Errorf("-min-capacity must not be negative")
+	}
+
+	// A single channel is either selected or not, so restricting the
+	// scan by capacity on top would only ever hide it.
+	if *channel != "" && flagIsSet("min-capacity") {
+		return fmt.Errorf("-channel can't be combined with " +
+			"-min-capacity, as it already selects a single channel")
+	}
+	if *channel != "" && flagIsSet("since-height") {
+		return fmt.Errorf("-channel can't be combined with " +
+			"-since-height, as it already selects a single channel")
+	}
+
+	if *peer != "" {
+		if _, err := chanleak.ParsePeer(*peer); err != nil {
+			return fmt.Errorf("invalid -peer: %v", err)
+		}
+	}
+
+	// No channel could ever match a larger height.
+	if *sinceHeight > maxBlockHeight {
+		return fmt.Errorf("-since-height must be at most %v",
+			maxBlockHeight)
+	}
+
+	if err := validateChainFlags(); err != nil {
+		return err
+	}
+
+	if *includePrivate && !*chainVerify {
+		return fmt.Errorf("-include-private requires -chainverify, " +
+			"as private channels can only be verified on-chain")
+	}
+
+	if err := validateQuietFlags(); err != nil {
+		return err
+	}
+
+	if err := validateFiatFlags(); err != nil {
+		return err
+	}
+
+	if err := validateGraphCacheFlags(); err != nil {
+		return err
+	}
+
+	if err := validateModeFlags(); err != nil {
+		return err
+	}
+
+	if err := validateSnapshotFlags(); err != nil {
+		return err
+	}
+
+	if err := validateSelfTestFlags(); err != nil {
+		return err
+	}
+
+	if err := validateChannelsFallbackFlags(); err != nil {
+		return err
+	}
+
+	if err := validateCrossFlags(); err != nil {
+		return err
+	}
+
+	if err := validateBakemacFlags(); err != nil {
+		return err
+	}
+
+	if err := validateHistoryFlags(); err != nil {
+		return err
+	}
+
+	if *configPath != "" {
+		return checkFleetFlags()
+	}
+
+	return nil
+}
+
+// knownNetworks is the set of networks lnd may run on.
+var knownNetworks = []string{
+	"mainnet", "testnet", "signet", "regtest", "simnet",
+}
+
+// validateNetwork returns an error if the given network isn't one lnd may run
+// on.
+func validateNetwork(network string) error {
+	for _, known := range knownNetworks {
+		if network == known {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown network %q, must be one of %v", network,
+		strings.Join(knownNetworks, ", "))
+}
+
+// validateChainFlags checks that the flags selecting the chain backend used by
+// -chainverify are consistent.
+func validateChainFlags() error {
+	if !*chainVerify {
+		for _, name := range []string{"chainbackend", "esploraurl"} {
+			if flagIsSet(name) {
+				return fmt.Errorf("-%v requires -chainverify to "+
+					"be set", name)
+			}
+		}
+	}
+
+	switch *chainBackendType {
+	case chainBackendBitcoind:
+		if flagIsSet("esploraurl") {
+			return fmt.Errorf("-esploraurl requires -chainbackend " +
+				"esplora")
+		}
+
+	case chainBackendEsplora:
+		conflicting := []string{
+			"chainrpchost", "chainrpcuser", "chainrpcpass",
+		}
+		for _, name := range conflicting {
+			if flagIsSet(name) {
+				return fmt.Errorf("-%v can't be combined with "+
+					"-chainbackend esplora", name)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown chain backend %q, must be one of "+
+			"%v or %v", *chainBackendType, chainBackendBitcoind,
+			chainBackendEsplora)
+	}
+
+	return nil
+}
+
+// validateQuietFlags checks that quiet mode isn't combined with flags that
+// produce output of their own, or that don't complete with a single verdict.
+func validateQuietFlags() error {
+	if !*quiet {
+		return nil
+	}
+
+	conflicting := []string{
+		"loglevel", "output", "json-stream", "table", "watch", "plan",
+		"config", "serve", "follow",
+	}
+	for _, name := range conflicting {
+		if flagIsSet(name) {
+			return fmt.Errorf("-quiet can't be combined with -%v",
+				name)
+		}
+	}
+
+	return nil
+}
+
+// validateFiatFlags checks that the flags expressing the loss in fiat are
+// consistent.
+func validateFiatFlags() error {
+	if *fiatCurrency == "" {
+		for _, name := range []string{"price", "priceurl"} {
+			if flagIsSet(name) {
+				return fmt.Errorf("-%v requires -fiat to be "+
+					"set", name)
+			}
+		}
+
+		return nil
+	}
+
+	if *fixedPrice < 0 {
+		return fmt.Errorf("-price must be positive")
+	}
+
+	if *fixedPrice == 0 && *priceURL == "" {
+		return fmt.Errorf("-fiat requires a price source, set " +
+			"either -priceurl or -price")
+	}
+
+	return nil
+}
+
+// validateGraphCacheFlags checks that the graph cache flags are consistent.
+func validateGraphCacheFlags() error {
+	if *graphCachePath == "" {
+		for _, name := range []string{"graphcache-ttl", "refresh"} {
+			if flagIsSet(name) {
+				return fmt.Errorf("-%v requires -graphcache "+
+					"to be set", name)
+			}
+		}
+
+		return nil
+	}
+
+	if *graphMode == chanleak.GraphModeLookup {
+		return fmt.Errorf("-graphcache can't be combined with " +
+			"-graphmode lookup, as only the full graph is cached")
+	}
+
+	if *graphCacheTTL <= 0 {
+		return fmt.Errorf("-graphcache-ttl must be positive")
+	}
+
+	return nil
+}
+
+// validateModeFlags checks that the flags selecting how the tool runs, such as
+// a one-shot scan, watch mode or plan mode, are consistent.
+func validateModeFlags() error {
+	if *watch && *plan {
+		return fmt.Errorf("-watch can't be combined with -plan")
+	}
+
+	if *follow {
+		conflicting := []string{
+			"watch", "plan", "serve", "report", "replay", "dump",
+		}
+		for _, name := range conflicting {
+			if flagIsSet(name) {
+				return fmt.Errorf("-follow can't be combined "+
+					"with -%v", name)
+			}
+		}
+	}
+
+	// A connection check never scans the node, so flags selecting how
+	// it's scanned would be silently ignored.
+	if *checkConn {
+		conflicting := []string{
+			"watch", "follow", "plan", "serve", "config", "history",
+			"replay", "dump",
+		}
+		for _, name := range conflicting {
+			if flagIsSet(name) {
+				return fmt.Errorf("-check-conn can't be "+
+					"combined with -%v", name)
+			}
+		}
+	}
+
+	if *serveAddr != "" {
+		for _, name := range []string{"watch", "plan", "report"} {
+			if flagIsSet(name) {
+				return fmt.Errorf("-serve can't be combined "+
+					"with -%v", name)
+			}
+		}
+	} else if *apiToken != "" {
+		return fmt.Errorf("-api-token requires -serve to be set")
+	}
+
+	if *chanInfoTTL < 0 {
+		return fmt.Errorf("-chaninfo-ttl must not be negative, use 0 " +
+			"to disable the cache")
+	}
+	if flagIsSet("chaninfo-ttl") && !*watch && !*follow &&
+		*serveAddr == "" {
+
+		return fmt.Errorf("-chaninfo-ttl requires -watch, -follow or " +
+			"-serve to be set")
+	}
+
+	if *watch || *follow {
+		if *interval <= 0 {
+			return fmt.Errorf("-interval must be positive")
+		}
+
+		// The report describes a single scan, so it would be
+		// overwritten by every scan of the watcher.
+		if *reportPath != "" {
+			return fmt.Errorf("-report describes a single scan " +
+				"and can't be combined with -watch")
+		}
+	} else if flagIsSet("interval") {
+		return fmt.Errorf("-interval requires -watch or -follow to " +
+			"be set")
+	}
+
+	return nil
+}
+
+// validateSnapshotFlags checks that the flags recording and replaying a
+// snapshot of the node are consistent.
+func validateSnapshotFlags() error {
+	if *dumpPath != "" && *replayPath != "" {
+		return fmt.Errorf("-dump can't be combined with -replay")
+	}
+
+	// A snapshot never changes, so watching it would only ever repeat the
+	// same scan.
+	if *replayPath != "" && *watch {
+		return fmt.Errorf("-replay can't be combined with -watch")
+	}
+
+	if !exportMode() {
+		return nil
+	}
+	if *graphExport == "" || *channelsExport == "" {
+		return fmt.Errorf("-graphexport and -channelsexport must be " +
+			"set together")
+	}
+
+	// Like a snapshot, the exports never change, and they hold neither
+	// the node's closed channels nor anything to stream from.
+	conflicting := []string{
+		"replay", "dump", "watch", "follow", "serve", "config",
+		"check-conn", "include-closed", "closedexplorer",
+	}
+	for _, name := range conflicting {
+		if flagIsSet(name) {
+			return fmt.Errorf("-graphexport can't be combined "+
+				"with -%v", name)
+		}
+	}
+
+	return nil
+}
+
+// validateCrossFlags checks that the second node to cross-check the channel
+// graph against is specified in full, as none of its credentials can be
+// defaulted without mixing them up with those of the target node.
+func validateCrossFlags() error {
+	if *crossHost == "" {
+		for _, name := range []string{"crosstls", "crossmac"} {
+			if flagIsSet(name) {
+				return fmt.Errorf("-%v requires -crosshost",
+					name)
+			}
+		}
+
+		return nil
+	}
+
+	if *crossTLSPath == "" || *crossMacPath == "" {
+		return fmt.Errorf("-crosshost requires -crosstls and -crossmac")
+	}
+
+	return nil
+}
+
+// validateChannelsFallbackFlags checks that -channelsfallback is only used
+// when scanning a single live node.
+func validateChannelsFallbackFlags() error {
+	if *channelsFallback == "" {
+		return nil
+	}
+
+	// Snapshots and exports already carry the open channels, while a
+	// fleet config lists several nodes, each with channels of its own. A
+	// snapshot taken while falling back would miss the channels.
+	conflicting := []string{
+		"replay", "graphexport", "channelsexport", "dump", "config",
+	}
+	for _, name := range conflicting {
+		if flagIsSet(name) {
+			return fmt.Errorf("-channelsfallback can't be "+
+				"combined with -%v", name)
+		}
+	}
+
+	return nil
+}
+
+// selfTestFlags is the set of flags that may be combined with -selftest, as
+// they only affect how its results are logged.
+var selfTestFlags = map[string]bool{
+	"selftest":  true,
+	"loglevel":  true,
+	"logformat": true,
+	"no-color":  true,
+}
+
+// validateSelfTestFlags checks that the self-test isn't combined with flags
+// that select a node or how it's scanned, as the self-test always scans its
+// built-in fixture the same way.
+func validateSelfTestFlags() error {
+	if !*selfTest {
+		return nil
+	}
+
+	var conflicting string
+	flag.Visit(func(f *flag.Flag) {
+		if !selfTestFlags[f.Name] && conflicting == "" {
+			conflicting = f.Name
+		}
+	})
+	if conflicting != "" {
+		return fmt.Errorf("-selftest can't be combined with -%v",
+			conflicting)
+	}
+
+	return nil
+}
+
+// validateBakemacFlags checks that the macaroon can access every RPC the
+// requested features rely on, as listed by -bakemac.
+func validateBakemacFlags() error {
+	allowed, err := parseBakemac(*bakeMac)
+	if err != nil {
+		return fmt.Errorf("invalid -bakemac: %v", err)
+	}
+	if allowed == nil {
+		return nil
+	}
+
+	// Snapshots and exports are served without a macaroon.
+	for _, name := range []string{"replay", "graphexport"} {
+		if flagIsSet(name) {
+			return fmt.Errorf("-bakemac can't be combined with -%v, "+
+				"as no macaroon is used", name)
+		}
+	}
+
+	if !allowed["DescribeGraph"] {
+		switch {
+		case flagIsSet("graphmode") &&
+			*graphMode == chanleak.GraphModeDescribe:
+
+			return fmt.Errorf("-graphmode describe requires " +
+				"DescribeGraph to be part of -bakemac")
+
+		case *graphCachePath != "":
+			return fmt.Errorf("-graphcache requires DescribeGraph " +
+				"to be part of -bakemac")
+		}
+	}
+
+	// The graph is looked up channel by channel in lookup mode, as well
+	// as for closed channels and each newly opened channel in follow mode.
+	needsChanInfo := !allowed["DescribeGraph"] ||
+		*graphMode == chanleak.GraphModeLookup || *includeClosed ||
+		*closedExplorer != "" || *follow
+	if needsChanInfo && !allowed["GetChanInfo"] {
+		return fmt.Errorf("GetChanInfo must be part of -bakemac, as " +
+			"the graph is looked up channel by channel")
+	}
+
+	if (*includeClosed || *closedExplorer != "") &&
+		!allowed["ClosedChannels"] {
+
+		return fmt.Errorf("-include-closed requires ClosedChannels to " +
+			"be part of -bakemac")
+	}
+
+	if *follow && !allowed["SubscribeChannelEvents"] {
+		return fmt.Errorf("-follow requires SubscribeChannelEvents to " +
+			"be part of -bakemac")
+	}
+
+	return nil
+}
+
+// validateHistoryFlags checks that the flags recording and printing the scan
+// history are consistent.
+func validateHistoryFlags() error {
+	if !*history {
+		return nil
+	}
+
+	if *dbPath == "" {
+		return fmt.Errorf("-history requires -db to be set")
+	}
+
+	// Printing the history doesn't scan the node, so flags that only
+	// affect a scan would be silently ignored.
+	conflicting := []string{
+		"watch", "plan", "config", "replay", "dump", "serve",
+		"follow",
+	}
+	for _, name := range conflicting {
+		if flagIsSet(name) {
+			return fmt.Errorf("-history can't be combined with -%v",
+				name)
+		}
+	}
+
+	return nil
+}
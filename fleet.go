@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+// fleetReport is the top-level JSON document written to stdout when several
+// nodes are scanned in JSON output mode.
+type fleetReport struct {
+	// ToolVersion is the version and build information of the
+	// chanleakcheck binary that produced the report.
+	ToolVersion string `json:"toolVersion"`
+
+	// Nodes maps the pubkey of each node that was scanned successfully
+	// to the report of its scan.
+	Nodes map[string]*jsonReport `json:"nodes"`
+
+	// Failed lists the names of the nodes that couldn't be scanned.
+	Failed []string `json:"failed"`
+}
+
+// fleetResult is the outcome of scanning a single node of the fleet.
+type fleetResult struct {
+	profile  *nodeProfile
+	pubkey   string
+	report   *jsonReport
+	exitCode int
+}
+
+// checkFleetFlags returns an error if any flags were set that can't be
+// combined with scanning several nodes at once.
+func checkFleetFlags() error {
+	for _, name := range []string{
+		"watch", "plan", "csv", "report", "json-stream", "graphcache",
+		"dump", "replay", "db", "serve", "follow",
+	} {
+		if flagIsSet(name) {
+			return fmt.Errorf("-%v can't be combined with -config",
+				name)
+		}
+	}
+
+	if *parallel < 1 {
+		return fmt.Errorf("-parallel must be positive")
+	}
+
+	return nil
+}
+
+// runFleet scans all nodes of the given profiles, at most -parallel at a time,
+// and returns the exit code the process should terminate with. If any node
+// has a confirmed invalid channel, the whole run is considered to have found
+// invalid channels. Otherwise, the most severe failure of any node determines
+// the exit code.
+func runFleet(rootCtx context.Context, interrupted <-chan struct{},
+	baseCfg chanleak.Config, profiles []*nodeProfile) int {
+
+	log.Infof("Scanning %v nodes, %v at a time...", len(profiles),
+		*parallel)
+
+	var (
+		results = make([]fleetResult, len(profiles))
+		sem     = make(chan struct{}, *parallel)
+		wg      sync.WaitGroup
+	)
+	for i, profile := range profiles {
+		wg.Add(1)
+		go func(i int, profile *nodeProfile) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = scanFleetNode(
+				rootCtx, interrupted, baseCfg, profile,
+			)
+		}(i, profile)
+	}
+	wg.Wait()
+
+	combined := &fleetReport{
+		ToolVersion: versionString(),
+		Nodes:       make(map[string]*jsonReport),
+		Failed:      []string{},
+	}
+	exitCode := exitCodeClean
+	for _, result := range results {
+		switch result.exitCode {
+		case exitCodeClean, exitCodeInvalidChannels:
+			log.Infof("Node %v (%v): %v invalid channels, amount "+
+				"lost: %v", result.profile.Name, result.pubkey,
+				len(result.report.InvalidChannels),
+				btcutil.Amount(result.report.TotalLoss))
+
+		default:
+			log.Errorf("Node %v could not be scanned",
+				result.profile.Name)
+		}
+
+		if result.report != nil {
+			combined.Nodes[result.pubkey] = result.report
+		} else {
+			combined.Failed = append(
+				combined.Failed, result.profile.Name,
+			)
+		}
+
+		exitCode = combineExitCodes(exitCode, result.exitCode)
+	}
+
+	if *outputFormat == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(combined); err != nil {
+			log.Errorf("unable to write json report: %v", err)
+			return combineExitCodes(exitCode, exitCodeFailure)
+		}
+	}
+
+	return exitCode
+}
+
+// scanFleetNode connects to and scans the node of the given profile.
+func scanFleetNode(rootCtx context.Context, interrupted <-chan struct{},
+	baseCfg chanleak.Config, profile *nodeProfile) fleetResult {
+
+	result := fleetResult{
+		profile:  profile,
+		exitCode: exitCodeFailure,
+	}
+
+	ctx, cancel := scanContext(rootCtx)
+	defer cancel()
+
+	log.Infof("Scanning node %v...", profile.Name)
+
+	client, nodeInfo, err := connectNode(ctx, profile)
+	if err != nil {
+		log.Errorf("%v", err)
+		return result
+	}
+	result.pubkey = nodeInfo.IdentityPubkey
+
+	cfg := baseCfg
+	cfg.Client = client
+	checker, err := chanleak.NewChecker(&cfg)
+	if err != nil {
+		log.Errorf("unable to create checker for %v: %v", profile.Name,
+			err)
+		return result
+	}
+
+	result.report, _, result.exitCode = scanNode(
+		ctx, interrupted, checker, &scanMetrics{}, nodeInfo, nil,
+	)
+
+	return result
+}
+
+// combineExitCodes returns the exit code of a run made up of several scans,
+// given the combined exit code of the scans so far and the exit code of the
+// next scan. A confirmed invalid channel on any node takes precedence over
+// everything else, as it's the one outcome that must never be masked.
+func combineExitCodes(combined, next int) int {
+	switch {
+	case combined == exitCodeInvalidChannels ||
+		next == exitCodeInvalidChannels:
+
+		return exitCodeInvalidChannels
+
+	case next > combined:
+		return next
+
+	default:
+		return combined
+	}
+}
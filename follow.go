@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"google.golang.org/grpc"
+)
+
+const (
+	// followScanInterval is the default time between two full scans in
+	// follow mode. As each newly opened channel is verified as soon as
+	// it opens, the full scans only need to catch whatever we missed.
+	followScanInterval = 6 * time.Hour
+
+	// followRecheckInterval is the time between two checks of a newly
+	// opened channel that isn't part of the channel graph yet. Channels
+	// are only announced once their funding transaction is buried deep
+	// enough, so most channels can't be verified right as they open.
+	followRecheckInterval = time.Minute
+
+	// followRecheckTimeout is the time after which we'll stop checking a
+	// newly opened channel that still isn't part of the channel graph,
+	// such as one that is never announced. The periodic full scans still
+	// cover it.
+	followRecheckTimeout = 24 * time.Hour
+
+	// followInitialBackoff is the time we'll wait before resubscribing to
+	// the channel event stream after it failed for the first time. The
+	// backoff is doubled after each failed attempt.
+	followInitialBackoff = time.Second
+
+	// followMaxBackoff is the longest we'll ever wait before resubscribing
+	// to the channel event stream.
+	followMaxBackoff = time.Minute
+)
+
+// channelEventSubscriber is a client able to stream the channel events of a
+// node, which only lnd's gRPC interface supports.
+type channelEventSubscriber interface {
+	// SubscribeChannelEvents streams an update whenever a channel of
+	// the node is opened, closed, or changes its state.
+	SubscribeChannelEvents(ctx context.Context,
+		in *lnrpc.ChannelEventSubscription,
+		opts ...grpc.CallOption) (
+		lnrpc.Lightning_SubscribeChannelEventsClient, error)
+}
+
+// follower verifies each channel of the target node as soon as it's opened,
+// and falls back to periodic full scans to catch anything it missed.
+type follower struct {
+	*watcher
+
+	// cfg is the config of the checker used for full scans, from which
+	// the checkers verifying a single channel are derived.
+	cfg chanleak.Config
+
+	subscriber channelEventSubscriber
+
+	// pending maps each newly opened channel that couldn't be verified
+	// yet, as it isn't part of the channel graph, to the time it opened.
+	pending map[lnwire.ShortChannelID]time.Time
+}
+
+// runFollow verifies each channel of the target node as it's opened until the
+// root context is canceled, and returns the exit code the process should
+// terminate with.
+func runFollow(rootCtx context.Context, checker *chanleak.Checker,
+	cfg chanleak.Config, subscriber channelEventSubscriber,
+	metrics *scanMetrics, nodeInfo *lnrpc.GetInfoResponse) int {
+
+	scanInterval := followScanInterval
+	if flagIsSet("interval") {
+		scanInterval = *interval
+	}
+
+	w := newWatcher(checker, metrics, nodeInfo, scanInterval)
+	f := &follower{
+		watcher:    w,
+		cfg:        cfg,
+		subscriber: subscriber,
+		pending:    make(map[lnwire.ShortChannelID]time.Time),
+	}
+
+	log.Infof("Following channel events, with a full scan every %v...",
+		scanInterval)
+
+	opened := make(chan *lnrpc.Channel)
+	resubscribed := make(chan struct{})
+	go f.subscribe(rootCtx, opened, resubscribed)
+
+	// We'll start out with a full scan, as we only learn about channels
+	// opened from now on.
+	f.scan(rootCtx)
+
+	recheck := time.NewTicker(followRecheckInterval)
+	defer recheck.Stop()
+
+	nextScan := time.After(f.interval)
+	for {
+		select {
+		case channel := <-opened:
+			cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+			log.Infof("Channel %v with %v opened, verifying it",
+				cid, channel.RemotePubkey)
+
+			f.verifyOpened(rootCtx, cid, time.Now())
+
+		// Any channel opened while we weren't subscribed went
+		// unnoticed, so we'll catch up with a full scan.
+		case <-resubscribed:
+			f.scan(rootCtx)
+			nextScan = time.After(f.interval)
+
+		case <-recheck.C:
+			for cid, openedAt := range f.pending {
+				f.verifyOpened(rootCtx, cid, openedAt)
+			}
+
+		case <-nextScan:
+			f.scan(rootCtx)
+			nextScan = time.After(f.interval)
+
+		case <-rootCtx.Done():
+			log.Infof("Follower shutting down")
+			return exitCodeClean
+		}
+	}
+}
+
+// subscribe streams the node's newly opened channels to the given channel
+// until the context is canceled. If the stream fails, we'll resubscribe with
+// exponential backoff, and signal every successful resubscription.
+func (f *follower) subscribe(ctx context.Context,
+	opened chan<- *lnrpc.Channel, resubscribed chan<- struct{}) {
+
+	backoff := followInitialBackoff
+	for attempt := 0; ; attempt++ {
+		stream, err := f.subscriber.SubscribeChannelEvents(
+			ctx, &lnrpc.ChannelEventSubscription{},
+		)
+		if err == nil {
+			if attempt > 0 {
+				log.Infof("Resubscribed to channel events")
+
+				select {
+				case resubscribed <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			backoff = followInitialBackoff
+			err = receiveOpenedChannels(ctx, stream, opened)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Warnf("Channel event stream failed, resubscribing in %v: "+
+			"%v", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > followMaxBackoff {
+			backoff = followMaxBackoff
+		}
+	}
+}
+
+// receiveOpenedChannels forwards each channel the given stream reports as
+// opened to the given channel, until the stream fails.
+func receiveOpenedChannels(ctx context.Context,
+	stream lnrpc.Lightning_SubscribeChannelEventsClient,
+	opened chan<- *lnrpc.Channel) error {
+
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		channel := update.GetOpenChannel()
+		if update.Type != lnrpc.ChannelEventUpdate_OPEN_CHANNEL ||
+			channel == nil {
+
+			continue
+		}
+
+		select {
+		case opened <- channel:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// verifyOpened verifies a single newly opened channel. If it's invalid, we'll
+// carry out a full scan, so it's reported like in watch mode. If it can't be
+// verified yet, it's checked again later, until it has been open for longer
+// than followRecheckTimeout.
+func (f *follower) verifyOpened(rootCtx context.Context,
+	cid lnwire.ShortChannelID, openedAt time.Time) {
+
+	if time.Since(openedAt) > followRecheckTimeout {
+		log.Infof("Channel %v still can't be verified after %v, "+
+			"leaving it to the periodic full scans", cid,
+			followRecheckTimeout)
+		delete(f.pending, cid)
+		return
+	}
+
+	scanResult, err := f.checkChannel(rootCtx, cid)
+	switch {
+	case err != nil:
+		if rootCtx.Err() == nil {
+			log.Errorf("Unable to verify channel %v, retrying in "+
+				"%v: %v", cid, followRecheckInterval, err)
+			f.pending[cid] = openedAt
+		}
+		return
+
+	case len(scanResult.InvalidChannels) > 0:
+		log.Warnf("Newly opened channel %v is invalid, scanning the "+
+			"node", cid)
+		delete(f.pending, cid)
+		f.scan(rootCtx)
+
+	case len(scanResult.CheckFailed) > 0:
+		log.Errorf("Unable to verify channel %v, retrying in %v: %v",
+			cid, followRecheckInterval,
+			scanResult.CheckFailed[0].LookupErr)
+		f.pending[cid] = openedAt
+
+	case len(scanResult.NotInGraph) > 0:
+		if _, ok := f.pending[cid]; !ok {
+			log.Infof("Channel %v isn't part of the graph yet, "+
+				"checking it again every %v", cid,
+				followRecheckInterval)
+		}
+		f.pending[cid] = openedAt
+
+	case scanResult.NumPrivateSkipped > 0:
+		log.Infof("Skipping private channel %v, use -include-private "+
+			"to verify it on-chain", cid)
+		delete(f.pending, cid)
+
+	default:
+		log.Infof("Channel %v is valid", cid)
+		delete(f.pending, cid)
+	}
+}
+
+// checkChannel verifies the open channel with the given short channel ID.
+func (f *follower) checkChannel(rootCtx context.Context,
+	cid lnwire.ShortChannelID) (*chanleak.ScanResult, error) {
+
+	// A single channel is cheaper to look up than downloading the whole
+	// graph. The allowlist is left to the full scan we'll carry out if
+	// the channel is invalid, as it would warn about all other entries
+	// not matching the channel.
+	cfg := f.cfg
+	cfg.ChannelFilter = chanleak.AllFilters(
+		cfg.ChannelFilter, chanleak.ChanIDFilter(cid),
+	)
+	cfg.GraphMode = chanleak.GraphModeLookup
+	cfg.IncludeClosed = false
+	cfg.Allowlist = nil
+
+	checker, err := chanleak.NewChecker(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := scanContext(rootCtx)
+	defer cancel()
+
+	return checker.CheckChannels(ctx)
+}
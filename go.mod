@@ -3,7 +3,17 @@ module github.com/lightninglabs/chanleakcheck
 go 1.13
 
 require (
+	github.com/btcsuite/btcd v0.0.0-20190824003749-130ea5bddde3
+	github.com/btcsuite/btclog v0.0.0-20170628155309-84c8d2346e9f
 	github.com/btcsuite/btcutil v0.0.0-20190425235716-9e5f4b9a998d
-	github.com/lightninglabs/loop v0.2.2-alpha
+	github.com/coreos/bbolt v1.3.3
+	github.com/golang/protobuf v1.3.1
+	github.com/grpc-ecosystem/grpc-gateway v1.8.5 // indirect
+	github.com/lightninglabs/neutrino v0.0.0-20190919020618-7cf83626779e
 	github.com/lightningnetwork/lnd v0.8.0-beta-rc1
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/net v0.0.0-20190313220215-9f648a60d977 // indirect
+	google.golang.org/genproto v0.0.0-20190307195333-5fe7a883aa19 // indirect
+	google.golang.org/grpc v1.19.0
+	gopkg.in/macaroon.v2 v2.1.0
 )
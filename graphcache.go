@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+const (
+	// defaultGraphCacheTTL is the default time a cached channel graph is
+	// reused for.
+	defaultGraphCacheTTL = time.Hour
+
+	// graphCacheMaxBlocks is the number of blocks the node's chain may
+	// advance by before a cached channel graph is considered stale,
+	// regardless of its age. Channels confirmed in the meantime wouldn't
+	// be part of the cached graph, and would be reported as missing.
+	graphCacheMaxBlocks = 6
+)
+
+// graphCacheFile is the on-disk format of a cached channel graph.
+type graphCacheFile struct {
+	// NodePubkey is the public key of the node the graph was fetched
+	// from. A cache of a different node is never used.
+	NodePubkey string `json:"nodePubkey"`
+
+	// FetchedAt is the time the graph was fetched at.
+	FetchedAt time.Time `json:"fetchedAt"`
+
+	// BlockHeight is the node's block height at the time the graph was
+	// fetched.
+	BlockHeight uint32 `json:"blockHeight"`
+
+	// IncludeUnannounced records whether the graph includes unannounced
+	// channels.
+	IncludeUnannounced bool `json:"includeUnannounced"`
+
+	// Graph is the channel graph as returned by lnd, in the JSON format of
+	// lnd's REST interface.
+	Graph json.RawMessage `json:"graph"`
+}
+
+// graphCacheClient wraps a node client, persisting the result of DescribeGraph
+// to disk and reusing it for repeated scans until it goes stale. All other
+// calls are passed through.
+type graphCacheClient struct {
+	nodeClient
+
+	// path is the file the graph is cached in.
+	path string
+
+	// ttl is the time a cached graph is reused for.
+	ttl time.Duration
+
+	// refresh is set if the next DescribeGraph call must ignore the cache
+	// and fetch a fresh graph.
+	refresh bool
+}
+
+// newGraphCacheClient returns a client caching the channel graph in the given
+// file. If refresh is set, the first call of DescribeGraph fetches a fresh
+// graph regardless of the cache.
+func newGraphCacheClient(client nodeClient, path string, ttl time.Duration,
+	refresh bool) *graphCacheClient {
+
+	return &graphCacheClient{
+		nodeClient: client,
+		path:       path,
+		ttl:        ttl,
+		refresh:    refresh,
+	}
+}
+
+// DescribeGraph returns the channel graph from the cache if it's still fresh,
+// and fetches it from the node otherwise.
+func (c *graphCacheClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	// We'll need the node's identity and current height to tell whether
+	// the cache is still usable.
+	info, err := c.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get node info: %v", err)
+	}
+
+	if !c.refresh {
+		graph, err := c.load(info, in)
+		switch {
+		case err != nil:
+			log.Warnf("Unable to use graph cache %v, fetching a "+
+				"fresh graph: %v", c.path, err)
+
+		case graph != nil:
+			return graph, nil
+		}
+	}
+	c.refresh = false
+
+	graph, err := c.nodeClient.DescribeGraph(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.store(info, in, graph); err != nil {
+		log.Warnf("Unable to update graph cache: %v", err)
+	}
+
+	return graph, nil
+}
+
+// load returns the cached graph if it's still fresh. If there's no usable
+// cache, nil is returned without an error.
+func (c *graphCacheClient) load(info *lnrpc.GetInfoResponse,
+	in *lnrpc.ChannelGraphRequest) (*lnrpc.ChannelGraph, error) {
+
+	cacheBytes, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cache graphCacheFile
+	if err := json.Unmarshal(cacheBytes, &cache); err != nil {
+		return nil, err
+	}
+
+	age := time.Since(cache.FetchedAt)
+	switch {
+	case cache.NodePubkey != info.IdentityPubkey:
+		log.Infof("Graph cache belongs to node %v, ignoring it",
+			cache.NodePubkey)
+		return nil, nil
+
+	case cache.IncludeUnannounced != in.IncludeUnannounced:
+		return nil, nil
+
+	case age > c.ttl:
+		log.Infof("Graph cache expired %v ago, ignoring it",
+			(age - c.ttl).Round(time.Second))
+		return nil, nil
+
+	case info.BlockHeight > cache.BlockHeight+graphCacheMaxBlocks:
+		log.Infof("Node advanced %v blocks since the graph was "+
+			"cached, ignoring it",
+			info.BlockHeight-cache.BlockHeight)
+		return nil, nil
+	}
+
+	graph := &lnrpc.ChannelGraph{}
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	err = unmarshaler.Unmarshal(bytes.NewReader(cache.Graph), graph)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Using channel graph cached %v ago at height %v",
+		age.Round(time.Second), cache.BlockHeight)
+
+	return graph, nil
+}
+
+// store persists the given graph to the cache.
+func (c *graphCacheClient) store(info *lnrpc.GetInfoResponse,
+	in *lnrpc.ChannelGraphRequest, graph *lnrpc.ChannelGraph) error {
+
+	var graphJSON bytes.Buffer
+	marshaler := jsonpb.Marshaler{OrigName: true}
+	if err := marshaler.Marshal(&graphJSON, graph); err != nil {
+		return err
+	}
+
+	cache := &graphCacheFile{
+		NodePubkey:         info.IdentityPubkey,
+		FetchedAt:          time.Now(),
+		BlockHeight:        info.BlockHeight,
+		IncludeUnannounced: in.IncludeUnannounced,
+		Graph:              graphJSON.Bytes(),
+	}
+
+	return writeFileAtomic(c.path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cache)
+	})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	bolt "github.com/coreos/bbolt"
+)
+
+const (
+	// historyVersion is the current version of the layout of the history
+	// database. It must be bumped along with a new migration whenever the
+	// layout changes in a way older versions can't read.
+	historyVersion = 1
+
+	// historyOpenTimeout is the maximum time we'll wait for another
+	// instance of the tool to release the history database.
+	historyOpenTimeout = 10 * time.Second
+
+	// historyLimit is the number of most recent scans printed by
+	// -history.
+	historyLimit = 20
+)
+
+var (
+	// metaBucket holds the metadata of the history database, such as
+	// the version of its layout.
+	metaBucket = []byte("meta")
+
+	// versionKey is the key within the meta bucket storing the version
+	// of the database layout.
+	versionKey = []byte("version")
+
+	// scansBucket holds a record of each scan, keyed by a big-endian
+	// sequence number so the records are ordered by the time they were
+	// written.
+	scansBucket = []byte("scans")
+)
+
+// historyMigrations holds the migrations applied to bring a history database
+// of an older layout up to date. The migration at index i upgrades a database
+// from version i+1 to version i+2.
+var historyMigrations []func(tx *bolt.Tx) error
+
+// historyRecord is the record of a single scan stored within the history
+// database. Records are stored as JSON, so new fields can be added without a
+// migration, and records written by older versions remain readable.
+type historyRecord struct {
+	// Timestamp is the time the scan completed at.
+	Timestamp time.Time `json:"timestamp"`
+
+	// ToolVersion is the version of the tool that carried out the scan.
+	ToolVersion string `json:"toolVersion"`
+
+	// NodePubkey is the public key of the scanned node.
+	NodePubkey string `json:"nodePubkey"`
+
+	// NodeAlias is the alias of the scanned node.
+	NodeAlias string `json:"nodeAlias"`
+
+	// NumChannels is the number of channels selected for the scan.
+	NumChannels int `json:"numChannels"`
+
+	// NumChecked is the number of channels that were verified.
+	NumChecked int `json:"numChecked"`
+
+	// NumNotInGraph is the number of channels missing from the graph.
+	NumNotInGraph int `json:"numNotInGraph"`
+
+	// TotalLoss is the total amount lost due to the invalid channels in
+	// satoshis.
+	TotalLoss int64 `json:"totalLoss"`
+
+	// InvalidChannels is the set of invalid channels found by the scan.
+	InvalidChannels []historyChannel `json:"invalidChannels"`
+}
+
+// historyChannel is the record of a single invalid channel within a scan.
+type historyChannel struct {
+	// ChanID is the compact short channel ID of the channel.
+	ChanID uint64 `json:"chanId"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID.
+	ShortChanID string `json:"shortChanId"`
+
+	// RemotePubkey is the public key of the channel's remote peer.
+	RemotePubkey string `json:"remotePubkey"`
+
+	// SubjectiveCapacity is the capacity of the channel according to the
+	// node.
+	SubjectiveCapacity int64 `json:"subjectiveCapacity"`
+
+	// GraphCapacity is the capacity of the channel according to the
+	// channel graph.
+	GraphCapacity int64 `json:"graphCapacity"`
+}
+
+// newHistoryRecord returns the record of the scan described by the given
+// report and summary.
+func newHistoryRecord(report *jsonReport,
+	summary *scanSummary) *historyRecord {
+
+	record := &historyRecord{
+		Timestamp:       summary.started.Add(summary.duration).UTC(),
+		ToolVersion:     report.ToolVersion,
+		NumChannels:     summary.numChannels,
+		NumChecked:      summary.numChecked,
+		NumNotInGraph:   len(report.NotInGraph),
+		TotalLoss:       report.TotalLoss,
+		InvalidChannels: []historyChannel{},
+	}
+	if summary.nodeInfo != nil {
+		record.NodePubkey = summary.nodeInfo.IdentityPubkey
+		record.NodeAlias = summary.nodeInfo.Alias
+	}
+
+	for _, channel := range report.InvalidChannels {
+		record.InvalidChannels = append(
+			record.InvalidChannels, historyChannel{
+				ChanID:             channel.ChanID,
+				ShortChanID:        channel.ShortChanID,
+				RemotePubkey:       channel.RemotePubkey,
+				SubjectiveCapacity: channel.SubjectiveCapacity,
+				GraphCapacity:      channel.GraphCapacity,
+			},
+		)
+	}
+
+	return record
+}
+
+// openHistory opens the history database at the given path, creating it if it
+// doesn't exist yet, and brings its layout up to date.
+func openHistory(path string, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:  historyOpenTimeout,
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history database %v: %v",
+			path, err)
+	}
+
+	if readOnly {
+		err = db.View(checkHistoryVersion)
+	} else {
+		err = db.Update(migrateHistory)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("history database %v: %v", path, err)
+	}
+
+	return db, nil
+}
+
+// historyDBVersion returns the version of the layout of the history database.
+// A database without a version was just created, and is reported as version 0.
+func historyDBVersion(tx *bolt.Tx) uint32 {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return 0
+	}
+
+	version := meta.Get(versionKey)
+	if len(version) != 4 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(version)
+}
+
+// checkHistoryVersion returns an error if the history database has a layout
+// this version of the tool can't read.
+func checkHistoryVersion(tx *bolt.Tx) error {
+	switch version := historyDBVersion(tx); {
+	case version == 0:
+		return fmt.Errorf("no scans recorded yet")
+
+	case version > historyVersion:
+		return fmt.Errorf("layout version %v was created by a newer "+
+			"version of chanleakcheck, which only supports up to "+
+			"version %v", version, historyVersion)
+
+	case version < historyVersion:
+		return fmt.Errorf("layout version %v is outdated, run a scan "+
+			"with -db to upgrade it", version)
+	}
+
+	return nil
+}
+
+// migrateHistory creates the buckets of the history database, and applies any
+// migrations required to bring its layout up to date.
+func migrateHistory(tx *bolt.Tx) error {
+	version := historyDBVersion(tx)
+	if version > historyVersion {
+		return fmt.Errorf("layout version %v was created by a newer "+
+			"version of chanleakcheck, which only supports up to "+
+			"version %v", version, historyVersion)
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(scansBucket); err != nil {
+		return err
+	}
+
+	// A fresh database already has the current layout, so only existing
+	// ones need to be migrated.
+	if version != 0 {
+		for v := version; v < historyVersion; v++ {
+			log.Infof("Migrating history database from layout "+
+				"version %v to %v", v, v+1)
+
+			if err := historyMigrations[v-1](tx); err != nil {
+				return fmt.Errorf("unable to migrate to "+
+					"layout version %v: %v", v+1, err)
+			}
+		}
+	}
+
+	var versionBytes [4]byte
+	binary.BigEndian.PutUint32(versionBytes[:], historyVersion)
+
+	return meta.Put(versionKey, versionBytes[:])
+}
+
+// recordScan appends the record of the scan described by the given report and
+// summary to the history database at the given path.
+func recordScan(path string, report *jsonReport, summary *scanSummary) error {
+	recordBytes, err := json.Marshal(newHistoryRecord(report, summary))
+	if err != nil {
+		return err
+	}
+
+	db, err := openHistory(path, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		scans := tx.Bucket(scansBucket)
+
+		seq, err := scans.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+
+		return scans.Put(key[:], recordBytes)
+	})
+}
+
+// loadHistory returns all scans recorded within the history database at the
+// given path, oldest first.
+func loadHistory(path string) ([]*historyRecord, error) {
+	// Opening a database read-only doesn't create it, so we'll report a
+	// missing one as such.
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("history database %v doesn't exist, "+
+			"run a scan with -db to create it", path)
+	}
+
+	db, err := openHistory(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var records []*historyRecord
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scansBucket).ForEach(func(k, v []byte) error {
+			record := &historyRecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return fmt.Errorf("unable to decode scan "+
+					"%x: %v", k, err)
+			}
+			records = append(records, record)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// printHistory writes the most recent scans recorded within the history
+// database at the given path to the given writer, followed by the time each
+// invalid channel was first detected.
+func printHistory(w io.Writer, path string) error {
+	records, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+
+	recent := records
+	if len(recent) > historyLimit {
+		recent = recent[len(recent)-historyLimit:]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\tNODE\tCHANNELS\tINVALID\tTOTAL LOSS\n")
+	for _, record := range recent {
+		node := record.NodeAlias
+		if node == "" {
+			node = record.NodePubkey
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n",
+			record.Timestamp.Format(time.RFC3339), node,
+			record.NumChannels, len(record.InvalidChannels),
+			btcutil.Amount(record.TotalLoss))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// To tell when each fake channel first appeared, we'll go through
+	// the full history rather than only the recent scans.
+	type firstSeen struct {
+		nodePubkey string
+		channel    historyChannel
+		seen       time.Time
+	}
+	var channels []firstSeen
+	known := make(map[string]struct{})
+	for _, record := range records {
+		for _, channel := range record.InvalidChannels {
+			key := fmt.Sprintf("%v:%v", record.NodePubkey,
+				channel.ChanID)
+			if _, ok := known[key]; ok {
+				continue
+			}
+
+			known[key] = struct{}{}
+			channels = append(channels, firstSeen{
+				nodePubkey: record.NodePubkey,
+				channel:    channel,
+				seen:       record.Timestamp,
+			})
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "CID\tREMOTE PUBKEY\tFIRST SEEN\n")
+	for _, c := range channels {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", c.channel.ShortChanID,
+			c.channel.RemotePubkey, c.seen.Format(time.RFC3339))
+	}
+
+	return tw.Flush()
+}
+
+// runHistory prints the scan history and returns the exit code the process
+// should terminate with.
+func runHistory() int {
+	if err := printHistory(os.Stdout, *dbPath); err != nil {
+		log.Errorf("Unable to print history: %v", err)
+		return exitCodeFailure
+	}
+
+	return exitCodeClean
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// displayedIndices returns the indices of the items to print out of the given
+// number of items, given the loss attributed to each one. If -limit caps the
+// number of items printed, only that many are returned, those with the highest
+// loss first, along with the number of items left out. Otherwise, all items
+// are returned in their original order. A negative limit is rejected along with
+// the other flags, but is treated as no limit at all here so it can never
+// slice out of bounds.
+func displayedIndices(numItems int, loss func(i int) int64) ([]int, int) {
+	indices := make([]int, numItems)
+	for i := range indices {
+		indices[i] = i
+	}
+
+	if *limit <= 0 || numItems <= *limit {
+		return indices, 0
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		return loss(indices[i]) > loss(indices[j])
+	})
+
+	return indices[:*limit], numItems - *limit
+}
+
+// omittedFooter returns the line pointing to the full results for the given
+// number of items that -limit left out.
+func omittedFooter(numOmitted int) string {
+	return fmt.Sprintf("... and %v more (use -output json for full list)",
+		numOmitted)
+}
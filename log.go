@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btclog"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+var (
+	// logBackend is the backend all loggers write to. All log output goes
+	// to stderr, so stdout is kept clean for the JSON output mode.
+	logBackend = btclog.NewBackend(os.Stderr)
+
+	// log is the logger of the main package.
+	log = logBackend.Logger("CHLK")
+
+	// leakLog is the logger of the chanleak package.
+	leakLog = logBackend.Logger(chanleak.Subsystem)
+)
+
+func init() {
+	chanleak.UseLogger(leakLog)
+}
+
+// setLogFormat replaces all loggers with ones writing in the given format. The
+// text format is the default, so nothing needs to be replaced for it.
+func setLogFormat(format string) error {
+	switch format {
+	case logFormatText:
+		return nil
+
+	case logFormatLogfmt, logFormatJSON:
+
+	default:
+		return fmt.Errorf("unknown log format %q, must be one of %v, "+
+			"%v or %v", format, logFormatText, logFormatLogfmt,
+			logFormatJSON)
+	}
+
+	backend := newStructuredBackend(os.Stderr, format)
+	log = backend.logger("CHLK")
+	leakLog = backend.logger(chanleak.Subsystem)
+	chanleak.UseLogger(leakLog)
+
+	return nil
+}
+
+// setLogLevel sets the level of all loggers to the level with the given name.
+func setLogLevel(levelName string) error {
+	level, ok := btclog.LevelFromString(levelName)
+	if !ok {
+		return fmt.Errorf("unknown log level: %v", levelName)
+	}
+
+	log.SetLevel(level)
+	leakLog.SetLevel(level)
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	macaroon "gopkg.in/macaroon.v2"
+)
+
+const (
+	// defaultMacaroonFilename is the name of the macaroon we'll look for
+	// within the macaroon directory.
+	defaultMacaroonFilename = "readonly.macaroon"
+)
+
+// flagIsSet returns true if the flag with the given name was explicitly set on
+// the command line.
+func flagIsSet(name string) bool {
+	var isSet bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			isSet = true
+		}
+	})
+
+	return isSet
+}
+
+// resolveMacaroonPath returns the full path of the macaroon file that should
+// be used to authenticate with lnd. Either -macaroonpath points directly at
+// the file, or the readonly macaroon is expected to live within -macdir. An
+// error is returned if both flags were set, or the macaroon doesn't exist.
+func resolveMacaroonPath() (string, error) {
+	// Unless the macaroon directory was given, we'll look in lnd's
+	// default directory of the selected network.
+	macDir := *macaroonDir
+	if macDir == "" {
+		macDir = filepath.Join(defaultChainDir, *network)
+	}
+
+	macPath := filepath.Join(macDir, defaultMacaroonFilename)
+	if *macaroonPath != "" {
+		if flagIsSet("macdir") {
+			return "", fmt.Errorf("only one of -macdir and " +
+				"-macaroonpath may be set")
+		}
+
+		macPath = *macaroonPath
+	}
+
+	info, err := os.Stat(macPath)
+	switch {
+	case os.IsNotExist(err):
+		return "", fmt.Errorf("macaroon not found at %v, use -macdir "+
+			"or -macaroonpath to point at it", macPath)
+
+	case err != nil:
+		return "", fmt.Errorf("unable to read macaroon %v: %v",
+			macPath, err)
+
+	case info.IsDir():
+		return "", fmt.Errorf("macaroon path %v is a directory, "+
+			"use -macdir instead", macPath)
+	}
+
+	return macPath, nil
+}
+
+// loadMacaroon returns the macaroon used to authenticate with the node of the
+// given profile. The encoded macaroon of the profile takes precedence over its
+// macaroon path.
+func loadMacaroon(profile *nodeProfile) (*macaroon.Macaroon, error) {
+	var macBytes []byte
+	switch {
+	case profile.Macaroon != "":
+		var err error
+		macBytes, err = decodeCredential(profile.Macaroon)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode macaroon: %v",
+				err)
+		}
+
+	case profile.MacaroonPath != "":
+		var err error
+		macBytes, err = ioutil.ReadFile(profile.MacaroonPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read macaroon: %v",
+				err)
+		}
+
+	default:
+		return nil, fmt.Errorf("no macaroon specified")
+	}
+
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macBytes); err != nil {
+		return nil, fmt.Errorf("unable to decode macaroon: %v", err)
+	}
+
+	return mac, nil
+}
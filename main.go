@@ -3,15 +3,15 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
-	"math"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/btcsuite/btcutil"
-	"github.com/lightninglabs/loop/lndclient"
-	"github.com/lightningnetwork/lnd/lnrpc"
-	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
 )
 
 var (
@@ -24,175 +24,865 @@ var (
 	defaultDataDir     = "data"
 	defaultChainSubDir = "chain"
 
-	defaultMacaroonDir = filepath.Join(
-		defaultLndDir, defaultDataDir,
-		defaultChainSubDir, "bitcoin", "mainnet",
+	// defaultChainDir is the directory holding a subdirectory with the
+	// macaroons of each network.
+	defaultChainDir = filepath.Join(
+		defaultLndDir, defaultDataDir, defaultChainSubDir, "bitcoin",
 	)
 
 	defaultNet = "mainnet"
 )
 
+const (
+	// exitCodeClean is returned if the scan completed and no invalid
+	// channels were found.
+	exitCodeClean = 0
+
+	// exitCodeInvalidChannels is returned if the scan completed and at
+	// least one invalid channel was found.
+	exitCodeInvalidChannels = 1
+
+	// exitCodeFailure is returned if the scan couldn't be completed, for
+	// example because we were unable to connect to lnd or an RPC failed.
+	exitCodeFailure = 2
+
+	// exitCodeTimeout is returned if the scan didn't complete within the
+	// configured timeout.
+	exitCodeTimeout = 3
+
+	// exitCodeInterrupted is returned if the scan was aborted by the user
+	// through SIGINT or SIGTERM.
+	exitCodeInterrupted = 4
+)
+
+// usage prints the default flag usage message followed by a description of
+// the exit codes of the tool.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExamples:\n"+
+		"  Scan the local node:\n"+
+		"    %[1]s\n"+
+		"  Scan a remote node and verify all channels on-chain:\n"+
+		"    %[1]s -host node:10009 -tlspath tls.cert "+
+		"-macaroonpath readonly.macaroon -chainverify "+
+		"-include-private -chainrpcuser user -chainrpcpass pass\n"+
+		"  Monitor the node and alert a webhook on changes:\n"+
+		"    %[1]s -watch -interval 5m -webhook https://example.com\n"+
+		"  Write a report and express the loss in fiat:\n"+
+		"    %[1]s -report report.txt -fiat USD\n"+
+		"  Scan several nodes in parallel:\n"+
+		"    %[1]s -config nodes.json -parallel 4 -output json\n",
+		os.Args[0])
+	fmt.Fprintf(out, "\nEvery flag can also be set through an environment "+
+		"variable named after it,\nsuch as %[1]vHOST for -host or "+
+		"%[1]vGRAPHCACHE_TTL for\n-graphcache-ttl. Flags given on "+
+		"the command line take precedence.\n", envPrefix)
+	fmt.Fprintf(out, "\nExit codes:\n"+
+		"  %d\tno invalid channels were found\n"+
+		"  %d\tan invalid channel selected by -fail-on was found\n"+
+		"  %d\tthe scan failed (connection or RPC error)\n"+
+		"  %d\tthe scan timed out\n"+
+		"  %d\tthe scan was interrupted\n",
+		exitCodeClean, exitCodeInvalidChannels, exitCodeFailure,
+		exitCodeTimeout, exitCodeInterrupted)
+}
+
 var (
 	host = flag.String("host", "localhost:10009", "host of the target lnd node")
 
+	socks = flag.String("socks", "", "the SOCKS5 proxy to connect to "+
+		"the target lnd node through, such as Tor. Defaults to "+
+		defaultTorSOCKS+" if -host is an onion address")
+
 	tlsPath = flag.String("tlspath", defaultTLSCertPath, "path to the "+
 		"TLS cert of the target lnd node")
 
-	macaroonDir = flag.String("macdir", defaultMacaroonDir, "path to the "+
-		"readonly macaroon for the target lnd node")
+	macaroonDir = flag.String("macdir", "", "path to the directory "+
+		"containing the readonly macaroon for the target lnd node. "+
+		"Defaults to the directory of the -network within "+
+		defaultChainDir)
+
+	macaroonPath = flag.String("macaroonpath", "", "path to the "+
+		"macaroon file for the target lnd node, takes the place of "+
+		"-macdir for macaroons with a custom name or location")
+
+	crossHost = flag.String("crosshost", "", "if set, the host of a "+
+		"second, independent lnd node whose channel graph every "+
+		"channel is cross-checked against, so a poisoned graph of "+
+		"the target node doesn't go unnoticed. Requires -crosstls and "+
+		"-crossmac")
+
+	crossTLSPath = flag.String("crosstls", "", "path to the TLS cert of "+
+		"the -crosshost node")
+
+	crossMacPath = flag.String("crossmac", "", "path to the macaroon file "+
+		"for the -crosshost node, which only needs to grant read "+
+		"access to its channel graph")
+
+	bakeMac = flag.String("bakemac", "", "the comma-separated list of "+
+		"RPCs the macaroon can access, if it was baked with fewer "+
+		"permissions than the readonly macaroon. Features relying on "+
+		"other RPCs are skipped, and the graph is looked up channel "+
+		"by channel if DescribeGraph isn't listed")
+
+	tlsCert = flag.String("tlscert", "", "the hex or base64 encoded "+
+		"TLS cert of the target lnd node, overrides -tlspath. May "+
+		"also be set through the "+tlsCertEnv+" environment variable")
+
+	macaroonHex = flag.String("macaroon", "", "the hex or base64 "+
+		"encoded macaroon for the target lnd node, overrides -macdir "+
+		"and -macaroonpath. May also be set through the "+
+		macaroonEnv+" environment variable")
 
 	network = flag.String("network", defaultNet, "the network the lnd "+
-		"node is running on (default:mainnet)")
+		"node is running on, one of "+strings.Join(knownNetworks, ", "))
+
+	logLevel = flag.String("loglevel", "info", "the log level, one of "+
+		"error, warn, info or debug. At warn only invalid channels "+
+		"and errors are logged, at debug every channel lookup is "+
+		"logged")
+
+	logFormat = flag.String("logformat", logFormatText, "the format of "+
+		"the log output, either text for human readable lines, or "+
+		"logfmt or json for structured lines with an ISO8601 "+
+		"timestamp and a level field, as ingested by log aggregators")
+
+	maxMsgSize = flag.Int("maxmsgsize", defaultMaxMsgSize, "the size "+
+		"in MB of the largest gRPC message accepted from lnd. Large "+
+		"nodes may need to raise this to fetch their channel graph "+
+		"or forwarding history")
+
+	outputFormat = flag.String("output", outputText, "the output format "+
+		"of the scan results, either text or json. In json mode the "+
+		"results are written to stdout while logs remain on stderr")
+
+	sortOrder = flag.String("sort", sortChanID, "the order the "+
+		"channels of the scan results are reported in, either chanid "+
+		"to order them by short channel ID, loss to order them by "+
+		"the amount lost over them with the largest loss first, or "+
+		"severity to order the invalid channels by their severity "+
+		"with the most severe first")
+
+	limit = flag.Int("limit", 0, "if set, the maximum number of invalid "+
+		"channels printed to the terminal, those with the largest "+
+		"loss first, the JSON and file outputs always list all of "+
+		"them")
+
+	suggestActions = flag.Bool("suggest-actions", false, "print the "+
+		"lncli command closing each open invalid channel to stderr "+
+		"once the scan completes, for the operator to review and "+
+		"run")
+
+	csvPath = flag.String("csv", "", "if set, the path to write a CSV "+
+		"file to with the per-channel loss breakdown of all invalid "+
+		"channels")
+
+	dumpPath = flag.String("dump", "", "if set, the path to write a "+
+		"snapshot of all RPC responses of the scan to, which can be "+
+		"scanned again later with -replay")
+
+	replayPath = flag.String("replay", "", "if set, the path of a "+
+		"snapshot written with -dump to scan instead of a live node")
+
+	graphExport = flag.String("graphexport", "", "if set, the path of "+
+		"the output of lncli describegraph to verify the channels "+
+		"of -channelsexport against, rather than connecting to a "+
+		"node. No credentials are needed, but the loss can't be "+
+		"quantified")
+
+	channelsExport = flag.String("channelsexport", "", "the path of "+
+		"the output of lncli listchannels to scan along with "+
+		"-graphexport")
+
+	channelsFallback = flag.String("channelsfallback", "", "if set, "+
+		"the path of the output of lncli listchannels to verify "+
+		"against the graph if the node refuses to list its open "+
+		"channels, such as a monitoring node restricted to graph "+
+		"queries")
+
+	fiatCurrency = flag.String("fiat", "", "if set, the fiat currency "+
+		"code (e.g. USD or EUR) to also express the losses in, using "+
+		"the BTC price from -priceurl or -price")
+
+	priceURL = flag.String("priceurl", defaultPriceURL, "the price feed "+
+		"to fetch the BTC price in the -fiat currency from. The "+
+		"{currency} placeholder is replaced with the currency code")
+
+	fixedPrice = flag.Float64("price", 0, "a fixed BTC price in the "+
+		"-fiat currency to use instead of the price feed, for "+
+		"reproducible or offline reports")
+
+	force = flag.Bool("force", false, "scan the node even if it isn't "+
+		"synced to the channel graph yet, which may report valid "+
+		"channels as missing from the graph")
+
+	graphMode = flag.String("graphmode", chanleak.GraphModeDescribe,
+		"how the channel graph is queried: describe fetches the "+
+			"whole graph at once, lookup queries each channel "+
+			"individually which uses less memory but is much "+
+			"slower on large nodes")
+
+	graphCachePath = flag.String("graphcache", "", "if set, the path "+
+		"to cache the channel graph at, so repeated runs reuse it "+
+		"rather than fetching the whole graph again. Only used in "+
+		"the describe graph mode")
+
+	graphCacheTTL = flag.Duration("graphcache-ttl", defaultGraphCacheTTL,
+		"the time a graph cached with -graphcache is reused for. "+
+			"The cache is also discarded once the node's chain "+
+			"advances by more than "+
+			strconv.Itoa(graphCacheMaxBlocks)+" blocks")
+
+	refresh = flag.Bool("refresh", false, "ignore the graph cached with "+
+		"-graphcache and fetch a fresh graph")
+
+	numWorkers = flag.Int("workers", chanleak.DefaultNumWorkers,
+		"the number of channels that are verified against the "+
+			"channel graph concurrently, and of peers whose "+
+			"aliases are looked up concurrently")
+
+	chainVerify = flag.Bool("chainverify", false, "also verify the "+
+		"funding output of each channel on-chain against the "+
+		"channel graph, using the backend selected with "+
+		"-chainbackend")
+
+	chainBackendType = flag.String("chainbackend", chainBackendBitcoind,
+		"the backend used by -chainverify, either bitcoind to use "+
+			"the bitcoind or btcd node specified with the "+
+			"-chainrpc flags, or esplora to use the HTTP API of an "+
+			"Esplora block explorer, which doesn't require "+
+			"running a full node")
+
+	esploraAPI = flag.String("esploraurl", "", "the base URL of the "+
+		"Esplora API used by -chainbackend esplora. Defaults to "+
+		"blockstream.info's API for mainnet and testnet, and "+
+		"mempool.space's API for signet")
+
+	minCapacity = flag.Int64("min-capacity", 0, "if set, only verify "+
+		"channels with a capacity of at least this many satoshis. "+
+		"This speeds up scans of nodes with many small channels, but "+
+		"a fake channel below the threshold goes unnoticed")
+
+	channelState = flag.String("channel-state", chanleak.ChannelStateAll,
+		"restrict the scan to the open channels in the given "+
+			"state, either all, active to only verify the "+
+			"currently active channels, or inactive to only "+
+			"verify those whose peer is offline")
+
+	sinceHeight = flag.Uint("since-height", 0, "if set, only verify "+
+		"channels opened at or above this block height, as encoded "+
+		"within their short channel ID. Useful for incremental audits "+
+		"that only need to check the channels opened since the last "+
+		"scan")
+
+	tolerance = flag.Int64("tolerance", 0, "the largest difference in "+
+		"satoshis between the graph's and the node's capacity of a "+
+		"channel that is still considered valid. Any tolerance may "+
+		"let a fake channel slip through, so only raise this for "+
+		"nodes known to report capacities inconsistently")
+
+	strictGraph = flag.Bool("strict", false, "fail the scan rather "+
+		"than warn if the channel graph looks incomplete, such as "+
+		"when it holds fewer edges than the node has public channels")
+
+	allowlistPath = flag.String("allowlist", "", "if set, the path to a "+
+		"file listing channels confirmed to be valid, one short "+
+		"channel ID or funding outpoint per line. These are left "+
+		"out of the reported channels, so triaged false positives "+
+		"don't show up again")
+
+	includePrivate = flag.Bool("include-private", false, "also verify "+
+		"private channels, which can't be found within the public "+
+		"channel graph, against their funding output on-chain. "+
+		"Requires -chainverify")
+
+	includeClosed = flag.Bool("include-closed", false, "also verify "+
+		"the node's closed channels, as a fake channel may have been "+
+		"used to drain the node before it was closed. Closed "+
+		"channels are pruned from the channel graph, so this is "+
+		"best combined with -chainverify or -closedexplorer")
+
+	closedExplorer = flag.String("closedexplorer", "", "if set, the "+
+		"base URL of an Esplora API to verify the funding output of "+
+		"each closed channel against, without verifying the open "+
+		"channels on-chain. Implies -include-closed, and takes "+
+		"precedence over -chainverify for closed channels")
+
+	chainRPCHost = flag.String("chainrpchost", "localhost:8332", "host "+
+		"of the bitcoind or btcd JSON-RPC interface used by "+
+		"-chainverify")
+
+	chainRPCUser = flag.String("chainrpcuser", "", "username for the "+
+		"bitcoind or btcd JSON-RPC interface used by -chainverify")
+
+	chainRPCPass = flag.String("chainrpcpass", "", "password for the "+
+		"bitcoind or btcd JSON-RPC interface used by -chainverify")
+
+	configPath = flag.String("config", "", "the path to a JSON file "+
+		"listing the connection details of several nodes to scan in "+
+		"one run, in place of -host and the credential flags")
+
+	parallel = flag.Int("parallel", 4, "the number of nodes listed "+
+		"within -config that are scanned concurrently")
+
+	channel = flag.String("channel", "", "restrict the scan to a single "+
+		"channel, given either as a short channel ID (block:tx:output "+
+		"or its uint64 form) or a funding outpoint (txid:index)")
+
+	peer = flag.String("peer", "", "restrict the scan to the channels "+
+		"with a single remote peer, given as its hex encoded public "+
+		"key")
+
+	startTime = flag.String("start", "", "only consider forwards at or "+
+		"after this time when quantifying the loss, as an RFC3339 "+
+		"timestamp or Unix seconds. Defaults to the node's full history")
+
+	endTime = flag.String("end", "", "only consider forwards at or "+
+		"before this time when quantifying the loss, as an RFC3339 "+
+		"timestamp or Unix seconds. Defaults to now")
+
+	showForwards = flag.Bool("show-forwards", false, "log each forward "+
+		"the loss over an invalid channel is made up of, and include "+
+		"them in the JSON output, so the loss can be audited. Off by "+
+		"default, as busy channels may have forwarded a great many "+
+		"HTLCs")
+
+	plan = flag.Bool("plan", false, "only report how many channels a "+
+		"scan would verify and how many RPCs it would issue, then "+
+		"exit without scanning")
+
+	reportPath = flag.String("report", "", "if set, the path to write a "+
+		"human readable report of the scan to, suitable for "+
+		"attaching to a support ticket")
+
+	rest = flag.Bool("rest", false, "connect to lnd's REST interface "+
+		"instead of its gRPC interface, for nodes that only expose "+
+		"the REST API. The -host port defaults to "+defaultRESTPort+
+		" in this mode")
+
+	retries = flag.Int("retries", chanleak.DefaultMaxRetries, "the number "+
+		"of times an RPC to lnd is retried with exponential backoff "+
+		"if it failed due to a transient error. A value of 0 "+
+		"disables retries")
+
+	rateLimit = flag.Float64("rate", chanleak.DefaultRateLimit, "the "+
+		"maximum number of RPCs per second issued to lnd, shared by "+
+		"all workers, so the scan doesn't degrade the node. A value of "+
+		"0 disables the limit")
+
+	timeout = flag.Duration("timeout", 60*time.Second, "the maximum "+
+		"duration of the whole scan, large nodes may need more time. "+
+		"A value of 0 disables the timeout. In watch mode the "+
+		"timeout applies to each individual scan")
+
+	watch = flag.Bool("watch", false, "keep scanning the node every "+
+		"-interval, only reporting what changed when the set of "+
+		"invalid channels or the total loss changes")
+
+	interval = flag.Duration("interval", 10*time.Minute, "the time "+
+		"between two scans in watch mode, or between two full scans "+
+		"in follow mode, where it defaults to 6h")
+
+	chanInfoTTL = flag.Duration("chaninfo-ttl", defaultChanInfoTTL, "in "+
+		"watch, follow and serve mode, the time the graph's view of a "+
+		"channel is reused for by later scans. Defaults to three "+
+		"times -interval in watch and follow mode. A view whose "+
+		"routing policies were updated after the node's best block "+
+		"isn't reused once the node's chain advances. Use 0 to look "+
+		"up every channel anew. Only applies to -graphmode lookup, as "+
+		"the describe mode doesn't look up channels individually")
+
+	follow = flag.Bool("follow", false, "subscribe to the channel "+
+		"events of the node and verify each channel as soon as it's "+
+		"opened, with a full scan every -interval to catch anything "+
+		"missed. Requires lnd's gRPC interface")
+
+	metricsAddr = flag.String("metrics-addr", "", "if set, the address "+
+		"to serve Prometheus metrics of the scan results on, mostly "+
+		"useful in combination with -watch")
+
+	serveAddr = flag.String("serve", "", "if set, the address to serve "+
+		"an HTTP JSON API on rather than scanning once. GET /scan "+
+		"carries out a fresh scan and responds with its JSON report, "+
+		"while GET /healthz reports liveness")
+
+	apiToken = flag.String("api-token", "", "if set, the bearer token "+
+		"required in the Authorization header of every request to "+
+		"/scan of the API served with -serve")
+
+	jsonStream = flag.Bool("json-stream", false, "emit the events of "+
+		"the scan to stdout as newline-delimited JSON as they happen, "+
+		"such as each channel being checked, each invalid channel and "+
+		"the computed loss. Can't be combined with -output json")
+
+	webhookURL = flag.String("webhook", "", "if set, the URL to POST a "+
+		"JSON notification to whenever invalid channels are found. "+
+		"In watch mode, a notification is only sent when the set of "+
+		"invalid channels or the total loss changes")
+
+	table = flag.Bool("table", false, "in the text output format, "+
+		"summarize the invalid channels in a table with aligned "+
+		"columns. This is the default if stderr is a terminal")
+
+	noColor = flag.Bool("no-color", false, "never color the result "+
+		"line and the table of invalid channels, even if they're "+
+		"written to a terminal. Setting the NO_COLOR environment "+
+		"variable has the same effect")
+
+	failOn = flag.String("fail-on", failOnMismatch, "the comma-separated "+
+		"set of findings that make the scan exit with code 1: "+
+		"mismatch for a capacity that doesn't match the graph, "+
+		"missing for channels not found in the graph, malformed for "+
+		"an implausible graph capacity or a malformed channel point, "+
+		"chain for a funding output that doesn't match on-chain, and "+
+		"collision for open channels sharing a short channel ID or "+
+		"funding outpoint")
+
+	quiet = flag.Bool("quiet", false, "only print the final verdict to "+
+		"stdout, either CLEAN or the number of fake channels and the "+
+		"amount at risk. Errors are still logged to stderr")
+
+	dbPath = flag.String("db", "", "if set, the path to a database to "+
+		"record the results of each scan in, so the history of a "+
+		"node can be reviewed with -history. The database is a bbolt "+
+		"file holding a JSON record of each scan, not an SQLite "+
+		"database")
+
+	history = flag.Bool("history", false, "print the most recent scans "+
+		"recorded in -db, along with the time each invalid channel "+
+		"was first detected, and exit without scanning")
+
+	checkConn = flag.Bool("check-conn", false, "only connect to the "+
+		"node and verify it's synced to the chain and the graph, then "+
+		"print OK and exit 0, or exit 2 if it's unreachable or not "+
+		"synced. Meant as a lightweight container healthcheck")
+
+	selfTest = flag.Bool("selftest", false, "scan a built-in snapshot of "+
+		"a node that fell for a fake channel, verify that exactly the "+
+		"fake channel is found with the expected loss, then print "+
+		"PASS and exit 0, or print FAIL and exit 2. No node is "+
+		"connected to")
+
+	showVersion = flag.Bool("version", false, "print the version and "+
+		"build information of chanleakcheck and exit")
 )
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
-	// To start, we'll create a new gRPC client for the target lnd node.
-	// This'll be our source for all the information of the target node.
-	lndClient, err := lndclient.NewBasicClient(
-		*host, *tlsPath, *macaroonDir, *network,
-		lndclient.MacFilename("readonly.macaroon"),
-	)
+	if err := applyEnvFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCodeFailure)
+	}
+
+	if *showVersion {
+		fmt.Println("chanleakcheck version", versionString())
+		os.Exit(exitCodeClean)
+	}
+
+	os.Exit(run())
+}
+
+// run executes a full scan of the target node and returns the exit code the
+// process should terminate with.
+func run() int {
+	// In quiet mode, only errors are logged, and the verdict is printed
+	// to stdout once the scan completes. A connection check is just as
+	// terse, unless a log level was explicitly requested.
+	level := *logLevel
+	if *quiet || (*checkConn && !flagIsSet("loglevel")) {
+		level = "error"
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeFailure
+	}
+	if err := setLogLevel(level); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeFailure
+	}
+
+	// Before making any RPCs, we'll make sure the flags make sense
+	// together, so misconfigurations are caught right away.
+	if err := validateFlags(); err != nil {
+		log.Errorf("Invalid flags: %v", err)
+		return exitCodeFailure
+	}
+
+	if *history {
+		return runHistory()
+	}
+
+	// All RPCs share a single root context, so canceling it aborts any
+	// outstanding requests. We'll cancel the context if the user
+	// interrupts the scan, so we can exit gracefully with whatever partial
+	// results we have.
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := interceptSignals(rootCtx, cancel)
+
+	if *selfTest {
+		return runSelfTest(rootCtx, interrupted)
+	}
+
+	if *checkConn {
+		ctx, cancelCheck := scanContext(rootCtx)
+		defer cancelCheck()
+
+		return runCheckConn(ctx)
+	}
+
+	fwdStartTime, err := parseTimestamp(*startTime)
 	if err != nil {
-		log.Fatalf("unable to create client: %v", err)
-	}
-
-	// In order to check if any invalid channels are accepted we'll compare
-	// the how big we think the channel is (our subjective view) to the
-	// _actual_ size of the channel in lnd's local channel graph. The
-	// channel graph has the correct channel values, but a node could be
-	// tricked into setting the wrong value/outpoint where it stores the
-	// actual channel data.
-	//
-	// So first we'll obtain all the node's current open channels to check
-	// against the channel graph shortly below.
-	channelResp, err := lndClient.ListChannels(
-		context.Background(), &lnrpc.ListChannelsRequest{},
-	)
+		log.Errorf("invalid -start: %v", err)
+		return exitCodeFailure
+	}
+	fwdEndTime, err := parseTimestamp(*endTime)
 	if err != nil {
-		log.Fatalf("unable to obtain channels: %v", err)
+		log.Errorf("invalid -end: %v", err)
+		return exitCodeFailure
 	}
 
-	log.Printf("Obtaining candidate set of invalidate channels...")
+	// The checker config is shared by all nodes we scan, only the client
+	// differs between them.
+	baseCfg := chanleak.Config{
+		GraphMode:           effectiveGraphMode(),
+		ChannelState:        *channelState,
+		NumWorkers:          *numWorkers,
+		IncludePrivate:      *includePrivate,
+		IncludeClosed:       *includeClosed || *closedExplorer != "",
+		MaxRetries:          *retries,
+		RateLimit:           *rateLimit,
+		ForwardingStartTime: fwdStartTime,
+		ForwardingEndTime:   fwdEndTime,
+		StrictGraph:         *strictGraph,
+		RecordForwards:      *showForwards,
+	}
 
-	// Now that we have our channels, we'll now construct our subjective
-	// view of a channels existence as well as its total capacity.
-	subjectiveChanView := make(map[lnwire.ShortChannelID]btcutil.Amount)
-	for _, channel := range channelResp.Channels {
-		cid := lnwire.NewShortChanIDFromInt(channel.ChanId)
+	if *minCapacity > 0 {
+		log.Infof("Only verifying channels with a capacity of at "+
+			"least %v", btcutil.Amount(*minCapacity))
 
-		subjectiveChanView[cid] = btcutil.Amount(channel.Capacity)
+		baseCfg.ChannelFilter = chanleak.MinCapacityFilter(
+			btcutil.Amount(*minCapacity),
+		)
 	}
 
-	log.Printf("Filtering out valid channels...")
+	if *sinceHeight > 0 {
+		log.Infof("Only verifying channels opened at or above height "+
+			"%v", *sinceHeight)
 
-	// Now that we have our subjective view of channels, we'll check
-	// against the objective channel graph (properly reject invalid
-	// channels and fully derives their full value from the chain) to see
-	// if things match up. If they don't, then we've accepted a fake
-	// channel.
-	invalidChannels := make(map[lnwire.ShortChannelID]struct{})
-	for cid, subjectiveSize := range subjectiveChanView {
-		// Given a channel ID, we'll query the channel graph for the
-		// actual information concerning that channel.
-		graphChan, err := lndClient.GetChanInfo(
-			context.Background(), &lnrpc.ChanInfoRequest{
-				ChanId: cid.ToUint64(),
-			})
+		baseCfg.ChannelFilter = chanleak.AllFilters(
+			baseCfg.ChannelFilter,
+			chanleak.MinHeightFilter(uint32(*sinceHeight)),
+		)
+	}
+
+	if *channelState != chanleak.ChannelStateAll {
+		log.Infof("Only verifying %v channels", *channelState)
+	}
+
+	if *tolerance > 0 {
+		log.Warnf("Treating capacity mismatches of up to %v as valid",
+			btcutil.Amount(*tolerance))
+
+		baseCfg.CapacityTolerance = btcutil.Amount(*tolerance)
+	}
+
+	if *allowlistPath != "" {
+		allowlist, err := loadAllowlist(*allowlistPath)
 		if err != nil {
-			log.Printf("unable to obtain graph channel for "+
-				"cid(%v): %v", cid, err)
+			log.Errorf("%v", err)
+			return exitCodeFailure
+		}
 
-			// If we can't find the channel in the channel graph,
-			// then we assume that it's invalid.
-			invalidChannels[cid] = struct{}{}
-			continue
+		baseCfg.Allowlist = allowlist
+	}
+
+	if *channelsFallback != "" {
+		channels, err := loadChannelsFallback(*channelsFallback)
+		if err != nil {
+			log.Errorf("%v", err)
+			return exitCodeFailure
 		}
 
-		// This is where we hold our breath...
-		//
-		// If size of the channel from the PoV of the channel graph
-		// doesn't match how big _we_ think the channel is, then it's
-		// invalid.
-		if graphChan.Capacity != int64(subjectiveSize) {
-			log.Printf("**** FAKE CHANNEL FOUND ****")
-			log.Printf("CID: %v")
-			log.Printf("Actual channel value: %v", graphChan.Capacity)
-			log.Printf("Subjective channel value: %v", subjectiveSize)
-			log.Printf("****************************")
-
-			invalidChannels[cid] = struct{}{}
+		baseCfg.FallbackChannels = channels
+	}
+
+	if *channel != "" {
+		chanFilter, err := parseChannelSelector(*channel)
+		if err != nil {
+			log.Errorf("invalid -channel: %v", err)
+			return exitCodeFailure
 		}
+
+		baseCfg.ChannelFilter = chanleak.AllFilters(
+			baseCfg.ChannelFilter, chanFilter,
+		)
 	}
 
-	log.Printf("Num invalid channels found: %v", len(invalidChannels))
+	if *peer != "" {
+		// The flag was validated before, so it can't fail to parse.
+		peerKey, _ := chanleak.ParsePeer(*peer)
+		log.Infof("Only verifying channels with peer %v", peerKey)
 
-	// If no invalid channels were found (yay!!!), then we're done here.
-	if len(invalidChannels) == 0 {
-		log.Printf("Your node was not affected by CVE-2019-12999!")
-		return
+		baseCfg.ChannelFilter = chanleak.AllFilters(
+			baseCfg.ChannelFilter, chanleak.PeerFilter(peerKey),
+		)
 	}
 
-	log.Printf("Quantifying amount lost due to forwards over invalid channels...")
+	// If requested, we'll also connect to a chain backend, so we can
+	// verify the channel graph itself against the chain.
+	if *chainVerify {
+		chainBackend, err := newChainBackend()
+		if err != nil {
+			log.Errorf("%v", err)
+			return exitCodeFailure
+		}
+		defer chainBackend.Stop()
 
-	// At this point, we suspect that a channel is invalid. As a result,
-	// we'll attempt to compute the total amount of coins that may have
-	// been drained using the channel. To do that, we'll obtain the history
-	// of all HTLCs successfully forwarded through this node.
-	fwdHistoryReq := &lnrpc.ForwardingHistoryRequest{
-		StartTime:    1,
-		EndTime:      uint64(time.Now().Unix()),
-		NumMaxEvents: math.MaxUint32,
+		baseCfg.ChainBackend = chainBackend
 	}
-	forwardingHistory, err := lndClient.ForwardingHistory(
-		context.Background(), fwdHistoryReq,
-	)
+
+	// Closed channels may be verified against a block explorer of their
+	// own, so their loss can be quantified without a full node.
+	if *closedExplorer != "" {
+		log.Infof("Verifying closed channels against the Esplora API "+
+			"at %v", *closedExplorer)
+
+		explorer := chanleak.NewEsploraBackend(*closedExplorer)
+		defer explorer.Stop()
+
+		baseCfg.ClosedChainBackend = explorer
+	}
+
+	// If requested, we'll also look up every channel within the graph of a
+	// second node, so we don't have to trust the target node's graph
+	// alone.
+	var crossPubkey string
+	if *crossHost != "" {
+		crossClient, info, err := connectCrossNode(rootCtx)
+		if err != nil {
+			log.Errorf("%v", err)
+			return exitCodeFailure
+		}
+
+		baseCfg.CrossClient = crossClient
+		crossPubkey = info.IdentityPubkey
+	}
+
+	// If a config file was given, we'll scan all nodes listed within it
+	// rather than the single node specified on the command line.
+	if *configPath != "" {
+		profiles, err := loadFleetConfig(*configPath)
+		if err != nil {
+			log.Errorf("%v", err)
+			return exitCodeFailure
+		}
+
+		return runFleet(rootCtx, interrupted, baseCfg, profiles)
+	}
+
+	// To start, we'll create a new client for the target lnd node. This'll
+	// be our source for all the information of the target node.
+	profile, err := profileFromFlags()
+	if err != nil {
+		log.Errorf("%v", err)
+		return exitCodeFailure
+	}
+
+	lndClient, nodeInfo, err := connectNode(rootCtx, profile)
 	if err != nil {
-		log.Fatalf("unable to obtain forwarding history: %v", err)
+		log.Errorf("%v", err)
+		return exitCodeFailure
 	}
 
-	//
-	chanForwardHistory := make(map[lnwire.ShortChannelID]btcutil.Amount)
-	for _, fwdEvent := range forwardingHistory.ForwardingEvents {
-		cidIn := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdIn)
-		cidOut := lnwire.NewShortChanIDFromInt(fwdEvent.ChanIdOut)
+	// A node cross-checked against itself wouldn't tell us anything.
+	if *crossHost != "" && crossPubkey == nodeInfo.IdentityPubkey {
+		log.Errorf("-crosshost must be a different node than the " +
+			"target node")
+		return exitCodeFailure
+	}
 
-		// If this forwarding event doesn't involve this channel, then
-		// we'll skip it.
-		_, incomingInvalidChan := invalidChannels[cidIn]
-		_, outgoingInvalidChan := invalidChannels[cidOut]
-		if !(incomingInvalidChan || outgoingInvalidChan) {
-			continue
+	// Channel events can only be streamed from lnd itself, so we'll grab
+	// the subscriber before the client is wrapped any further.
+	var subscriber channelEventSubscriber
+	if *follow {
+		var ok bool
+		subscriber, ok = lndClient.(channelEventSubscriber)
+		if !ok {
+			log.Errorf("-follow requires lnd's gRPC interface, " +
+				"as channel events can't be streamed over REST")
+			return exitCodeFailure
 		}
+	}
+
+	// If requested, we'll reuse the channel graph of previous runs for
+	// as long as it's fresh.
+	if *graphCachePath != "" {
+		lndClient = newGraphCacheClient(
+			lndClient, *graphCachePath, *graphCacheTTL, *refresh,
+		)
+	}
+
+	// In watch, follow and serve mode, the same channels are looked up
+	// over and over, so we'll reuse the results for a while.
+	if (*watch || *follow || *serveAddr != "") && *chanInfoTTL > 0 {
+		lndClient = newChanInfoCacheClient(
+			lndClient, chanInfoCacheTTL(),
+		)
+	}
+
+	// If requested, we'll record all responses of the node, and write them
+	// to a snapshot once we're done.
+	if *dumpPath != "" {
+		recorder := newRecordingClient(lndClient, nodeInfo)
+		lndClient = recorder
+
+		defer func() {
+			err := recorder.writeSnapshot(*dumpPath)
+			if err != nil {
+				log.Errorf("Unable to write snapshot: %v", err)
+				return
+			}
+
+			log.Infof("Wrote snapshot to %v", *dumpPath)
+		}()
+	}
+
+	// The progress of a single scan is reported as it goes, as it may take
+	// a while on large nodes. In watch and follow mode, we'll stay quiet
+	// unless the results change.
+	var progress *progressReporter
+	cfg := baseCfg
+	cfg.Client = lndClient
+	if !*watch && !*follow && !*plan && *serveAddr == "" {
+		progress = newProgressReporter()
+		cfg.Progress = progress.update
+	}
+	if *jsonStream {
+		events = newEventStream(os.Stdout)
+		cfg.ChannelChecked = events.channelChecked
+	}
+
+	// The checker wraps the client of the config it's created with, so
+	// we'll hold on to the config as given for the checkers the follower
+	// derives from it.
+	followCfg := cfg
+	checker, err := chanleak.NewChecker(&cfg)
+	if err != nil {
+		log.Errorf("unable to create checker: %v", err)
+		return exitCodeFailure
+	}
+
+	// The metrics are always tracked, but only exposed if requested.
+	metrics := &scanMetrics{}
+	if *metricsAddr != "" {
+		startMetricsServer(*metricsAddr, metrics)
+	}
+
+	// In plan mode, we'll only report what a scan would entail. In watch
+	// mode, we'll keep scanning the node until we're interrupted, in follow
+	// mode, we'll verify each channel as it's opened, and in serve mode,
+	// we'll scan it whenever asked to. Otherwise a single scan is carried
+	// out.
+	if *plan {
+		ctx, cancelPlan := scanContext(rootCtx)
+		defer cancelPlan()
 
-		// Otherwise, if this was channel was used as the incoming
-		// link, then this forward means we've lost the amount we
-		// accepted inbound as well as the fee. These funds were lost
-		// as we accepted "fake" coins on an incoming channel an
-		// exchanged them for real coins on the outgoing channel. We
-		// lose the fee as well since we thought we were keeping some
-		// extra on the incoming channel. This wasn't the case.
-		chanForwardHistory[cidIn] += -btcutil.Amount(
-			fwdEvent.AmtIn - fwdEvent.Fee,
+		return runPlan(ctx, interrupted, checker)
+	}
+	if *watch {
+		return runWatch(rootCtx, checker, metrics, nodeInfo)
+	}
+	if *follow {
+		return runFollow(
+			rootCtx, checker, followCfg, subscriber, metrics,
+			nodeInfo,
 		)
+	}
+	if *serveAddr != "" {
+		return runServe(
+			rootCtx, interrupted, checker, metrics, nodeInfo,
+		)
+	}
 
-		// If we ever completed a forward that went _out_ through this
-		// channel, then we've gained funds as we exchanged real coins
-		// (incoming) for fake coins.
-		chanForwardHistory[cidOut] += btcutil.Amount(fwdEvent.AmtOut)
+	ctx, cancelScan := scanContext(rootCtx)
+	defer cancelScan()
+
+	return runScan(ctx, interrupted, checker, metrics, nodeInfo, progress)
+}
+
+// loadAllowlist reads the allowlist at the given path.
+func loadAllowlist(path string) (*chanleak.Allowlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open allowlist: %v", err)
 	}
+	defer f.Close()
 
-	// Next, we'll print out each channel along with a breakdown for how
-	// many coins were lost as a result of it.
-	var totalLoss btcutil.Amount
-	for chanID, amtLost := range chanForwardHistory {
-		log.Printf("FakeChannel(%v) resulted in loss of: %v", chanID, amtLost)
+	allowlist, err := chanleak.ParseAllowlist(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowlist %v: %v", path, err)
+	}
+
+	log.Infof("Loaded %v allowlisted channels from %v", allowlist.Len(),
+		path)
+
+	return allowlist, nil
+}
+
+// scanContext derives the context for a single scan from the root context. If
+// a timeout was set, the context will be canceled automatically once it
+// expires.
+func scanContext(rootCtx context.Context) (context.Context, func()) {
+	if *timeout != 0 {
+		return context.WithTimeout(rootCtx, *timeout)
+	}
 
-		totalLoss += amtLost
+	return context.WithCancel(rootCtx)
+}
+
+// parseChannelSelector returns a filter matching the channel identified by the
+// given string, which is either a short channel ID or a funding outpoint.
+func parseChannelSelector(s string) (chanleak.ChannelFilter, error) {
+	if strings.Count(s, ":") == 1 {
+		op, err := chanleak.ParseOutPoint(s)
+		if err != nil {
+			return nil, err
+		}
+
+		return chanleak.ChanPointFilter(op), nil
+	}
+
+	cid, err := chanleak.ParseShortChanID(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return chanleak.ChanIDFilter(cid), nil
+}
+
+// parseTimestamp parses a timestamp given either in the RFC3339 format or as
+// Unix seconds. An empty string results in the zero time.
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is neither "+
+			"RFC3339 nor Unix seconds", s)
 	}
 
-	// We'll then take the sum of net balances of each channel to produce
-	// our calculation of the amount of coins lost.
-	log.Printf("Amount lost: %v", totalLoss)
+	return t, nil
 }
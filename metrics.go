@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// scanMetrics tracks the results of the latest scan, and exposes them over
+// HTTP in the Prometheus text exposition format.
+type scanMetrics struct {
+	mu sync.Mutex
+
+	invalidChannels int
+	notInGraph      int
+	totalLoss       btcutil.Amount
+	channelsScanned int
+	lastScan        time.Time
+}
+
+// update records the results of a completed scan.
+func (m *scanMetrics) update(invalidChannels, notInGraph, channelsScanned int,
+	totalLoss btcutil.Amount) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invalidChannels = invalidChannels
+	m.notInGraph = notInGraph
+	m.channelsScanned = channelsScanned
+	m.totalLoss = totalLoss
+	m.lastScan = time.Now()
+}
+
+// ServeHTTP writes the current metrics in the Prometheus text exposition
+// format.
+func (m *scanMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lastScan int64
+	if !m.lastScan.IsZero() {
+		lastScan = m.lastScan.Unix()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeGauge(w, "chanleakcheck_invalid_channels_total", "Number of "+
+		"invalid channels found by the latest scan.",
+		int64(m.invalidChannels))
+	writeGauge(w, "chanleakcheck_channels_not_in_graph_total", "Number "+
+		"of channels not found within the channel graph by the "+
+		"latest scan.", int64(m.notInGraph))
+	writeGauge(w, "chanleakcheck_total_loss_sats", "Amount lost due to "+
+		"forwards over invalid channels in satoshis.",
+		int64(m.totalLoss))
+	writeGauge(w, "chanleakcheck_channels_scanned_total", "Number of "+
+		"channels verified by the latest scan.",
+		int64(m.channelsScanned))
+	writeGauge(w, "chanleakcheck_last_scan_timestamp", "Unix timestamp "+
+		"of the latest completed scan.", lastScan)
+}
+
+// writeGauge writes a single gauge along with its metadata.
+func writeGauge(w http.ResponseWriter, name, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// startMetricsServer starts serving the given metrics at /metrics on the given
+// address in the background.
+func startMetricsServer(addr string, metrics *scanMetrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	go func() {
+		log.Infof("Serving metrics on %v/metrics", addr)
+
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// connectNode creates a client for the node of the given profile, and logs
+// the node's identity. An error is returned if the node runs on another
+// network than the profile's, or if it isn't synced to the graph, unless
+// -force is set, as a node that's still syncing the graph would have us report
+// a flood of valid channels as missing from it.
+func connectNode(ctx context.Context,
+	profile *nodeProfile) (nodeClient, *lnrpc.GetInfoResponse, error) {
+
+	client, err := newLndClient(profile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create client for %v: "+
+			"%v", profile.Name, err)
+	}
+
+	// Before anything else, we'll record which node we're about to scan,
+	// and whether it's in a state we can trust the results of.
+	nodeInfo, err := client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to obtain node info of "+
+			"%v: %v", profile.Name, err)
+	}
+	logNodeInfo(nodeInfo, profile.Network)
+
+	if err := checkNetwork(nodeInfo, profile.Network); err != nil {
+		return nil, nil, fmt.Errorf("node %v: %v", profile.Name, err)
+	}
+
+	if !nodeInfo.SyncedToGraph && !*force {
+		return nil, nil, fmt.Errorf("node %v is not synced to the "+
+			"graph, wait for the sync to complete or use -force "+
+			"to scan it anyway", profile.Name)
+	}
+
+	return client, nodeInfo, nil
+}
+
+// connectCrossNode creates a client for the second node given by -crosshost,
+// whose channel graph the channels of the target node are cross-checked
+// against. Like the target node, it must run on the same network and be synced
+// to the graph, or it would flag valid channels as disagreeing.
+func connectCrossNode(ctx context.Context) (nodeClient,
+	*lnrpc.GetInfoResponse, error) {
+
+	profile := &nodeProfile{
+		Name:         *crossHost,
+		Host:         *crossHost,
+		TLSPath:      *crossTLSPath,
+		MacaroonPath: *crossMacPath,
+		Network:      *network,
+		SOCKS:        *socks,
+	}
+
+	client, err := newGRPCClient(profile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create client for "+
+			"cross-check node %v: %v", profile.Name, err)
+	}
+
+	nodeInfo, err := client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to obtain node info of "+
+			"cross-check node %v: %v", profile.Name, err)
+	}
+	log.Infof("Cross-checking the channel graph against node %v "+
+		"(alias=%q)", nodeInfo.IdentityPubkey, nodeInfo.Alias)
+
+	if err := checkNetwork(nodeInfo, profile.Network); err != nil {
+		return nil, nil, fmt.Errorf("cross-check node %v: %v",
+			profile.Name, err)
+	}
+
+	if !nodeInfo.SyncedToGraph && !*force {
+		return nil, nil, fmt.Errorf("cross-check node %v is not "+
+			"synced to the graph, wait for the sync to complete or "+
+			"use -force to scan anyway", profile.Name)
+	}
+
+	return client, nodeInfo, nil
+}
+
+// runCheckConn connects to the target node and verifies it's synced, without
+// scanning it, and returns the exit code the process should terminate with.
+// Only OK is printed to stdout on success, so the result is easy to consume
+// by container healthchecks and scripts.
+func runCheckConn(ctx context.Context) int {
+	profile, err := profileFromFlags()
+	if err != nil {
+		log.Errorf("%v", err)
+		return exitCodeFailure
+	}
+
+	// Connecting to the node already verifies it's synced to the graph,
+	// but a node that lags behind the chain would also be unfit for a
+	// scan.
+	_, nodeInfo, err := connectNode(ctx, profile)
+	if err != nil {
+		log.Errorf("%v", err)
+		return exitCodeFailure
+	}
+	if !nodeInfo.SyncedToChain && !*force {
+		log.Errorf("node %v is not synced to the chain", profile.Name)
+		return exitCodeFailure
+	}
+
+	fmt.Println("OK")
+
+	return exitCodeClean
+}
+
+// checkNetwork returns an error if the node runs on a different network than
+// the configured one. Otherwise the scan would silently compare the node
+// against the wrong expectations, such as the chain backend of another
+// network.
+func checkNetwork(info *lnrpc.GetInfoResponse, network string) error {
+	for _, chain := range info.Chains {
+		if chain.Network != network {
+			return fmt.Errorf("node is running on %v %v, but the "+
+				"configured network is %v, use -network to "+
+				"select the node's network", chain.Chain,
+				chain.Network, network)
+		}
+	}
+
+	return nil
+}
+
+// logNodeInfo logs the identity and sync state of the scanned node, so a saved
+// log records which node it belongs to. As the results of a scan can only be
+// trusted if the node is fully synced, we'll warn if it isn't.
+func logNodeInfo(info *lnrpc.GetInfoResponse, network string) {
+	log.Infof("Scanning node %v (alias=%q)", info.IdentityPubkey,
+		info.Alias)
+	log.Infof("lnd version: %v", info.Version)
+	log.Infof("Network: %v", network)
+	log.Infof("Block height: %v (%v)", info.BlockHeight, info.BlockHash)
+	log.Infof("Synced to chain: %v, synced to graph: %v",
+		info.SyncedToChain, info.SyncedToGraph)
+
+	if !info.SyncedToChain {
+		log.Warnf("Node is not synced to the chain, the scan results " +
+			"may be incomplete")
+	}
+
+	if !info.SyncedToGraph {
+		log.Warnf("**** NODE IS NOT SYNCED TO THE GRAPH ****")
+		log.Warnf("The node's channel graph is out of date, so valid " +
+			"channels may be reported as missing from the graph.")
+		log.Warnf("*****************************************")
+	}
+}
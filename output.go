@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/routing/route"
+)
+
+const (
+	// outputText is the default output format: human readable log lines
+	// written to stderr.
+	outputText = "text"
+
+	// outputJSON emits a single JSON document describing the scan results
+	// to stdout, in addition to the regular log lines on stderr.
+	outputJSON = "json"
+
+	// blockInterval is the average time between two blocks, used to
+	// estimate when a channel was opened from its funding block height.
+	blockInterval = 10 * time.Minute
+
+	// openDateLayout is the layout of the approximate date a channel was
+	// opened at. As the date is only an estimate, we'll leave out the
+	// time of day.
+	openDateLayout = "2006-01-02"
+
+	// sortChanID orders the channels of a report by their short channel
+	// ID.
+	sortChanID = "chanid"
+
+	// sortLoss orders the channels of a report by the amount lost over
+	// them, with the largest loss first.
+	sortLoss = "loss"
+
+	// sortSeverity orders the invalid channels of a report by their
+	// severity, with the most severe first.
+	sortSeverity = "severity"
+)
+
+// sortInvalidChannels sorts the given channels by their short channel ID. The
+// checker reports them in the order their lookups completed, so without this
+// the output would differ from run to run.
+func sortInvalidChannels(channels []chanleak.InvalidChannel) {
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].ChanID.ToUint64() <
+			channels[j].ChanID.ToUint64()
+	})
+}
+
+// sortedLossChannels returns the channels of the given per-channel losses in
+// the order selected by -sort. Ties are broken by short channel ID.
+func sortedLossChannels(
+	losses map[lnwire.ShortChannelID]btcutil.Amount) []lnwire.ShortChannelID {
+
+	cids := make([]lnwire.ShortChannelID, 0, len(losses))
+	for cid := range losses {
+		cids = append(cids, cid)
+	}
+
+	sort.Slice(cids, func(i, j int) bool {
+		lossI, lossJ := losses[cids[i]], losses[cids[j]]
+		if *sortOrder == sortLoss && lossI != lossJ {
+			return lossI > lossJ
+		}
+
+		return cids[i].ToUint64() < cids[j].ToUint64()
+	})
+
+	return cids
+}
+
+// sortedCloseChannels returns the channels of the given closes sorted by their
+// short channel ID.
+func sortedCloseChannels(
+	closes map[lnwire.ShortChannelID]chanleak.ChannelClose) (
+	cids []lnwire.ShortChannelID) {
+
+	cids = make([]lnwire.ShortChannelID, 0, len(closes))
+	for cid := range closes {
+		cids = append(cids, cid)
+	}
+
+	sort.Slice(cids, func(i, j int) bool {
+		return cids[i].ToUint64() < cids[j].ToUint64()
+	})
+
+	return cids
+}
+
+// newJSONCoverage returns the coverage of the given scan result.
+func newJSONCoverage(result *chanleak.ScanResult) *chanleak.ReportCoverage {
+	coverage := &chanleak.ReportCoverage{
+		NumChannels: result.NumChannels + result.NumPrivateSkipped,
+		NumVerified: result.NumVerified,
+		TotalCapacity: int64(
+			result.TotalCapacity + result.PrivateSkippedCapacity,
+		),
+		VerifiedCapacity: int64(result.VerifiedCapacity),
+	}
+	coverage.VerifiedPercent = percent(
+		int64(coverage.NumVerified), int64(coverage.NumChannels),
+	)
+	coverage.VerifiedCapacityPercent = percent(
+		coverage.VerifiedCapacity, coverage.TotalCapacity,
+	)
+
+	return coverage
+}
+
+// percent returns the given part of the total as a percentage. An empty total
+// is considered to be fully covered.
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+
+	return 100 * float64(part) / float64(total)
+}
+
+// jsonReport is the top-level JSON document written to stdout when the JSON
+// output mode is selected. Its schema is defined by the library, so other
+// tools can decode it, while we'll keep the logic filling it in here.
+type jsonReport struct {
+	chanleak.Report
+}
+
+// newJSONReport returns an empty report with all lists initialized, so they
+// serialize as empty arrays rather than null.
+func newJSONReport() *jsonReport {
+	return &jsonReport{chanleak.Report{
+		SchemaVersion:   chanleak.ReportSchemaVersion,
+		ToolVersion:     versionString(),
+		LossStatus:      chanleak.LossComputed,
+		InvalidChannels: []chanleak.ReportChannel{},
+		NotInGraph:      []chanleak.ReportChannel{},
+		CheckFailed:     []chanleak.ReportChannel{},
+		Collisions:      []chanleak.ReportCollision{},
+		ChannelLosses:   []chanleak.ReportChannelLoss{},
+		PeerLosses:      []chanleak.ReportPeerLoss{},
+		ChannelCloses:   []chanleak.ReportChannelClose{},
+		BalanceChecks:   []chanleak.ReportBalanceCheck{},
+	}}
+}
+
+// addInvalidChannel records a confirmed invalid channel within the report.
+// Until the loss is quantified, its severity only accounts for its mismatch.
+func (r *jsonReport) addInvalidChannel(channel chanleak.InvalidChannel) {
+	jsonChannel := newJSONInvalidChannel(channel)
+	mismatch := chanleak.MismatchMagnitude(channel)
+	jsonChannel.Mismatch = int64(mismatch)
+	jsonChannel.Severity = chanleak.Severity(mismatch, 0)
+
+	r.InvalidChannels = append(r.InvalidChannels, jsonChannel)
+}
+
+// addNotInGraphChannel records a channel that couldn't be found within the
+// channel graph within the report.
+func (r *jsonReport) addNotInGraphChannel(channel chanleak.InvalidChannel) {
+	r.NotInGraph = append(r.NotInGraph, newJSONInvalidChannel(channel))
+}
+
+// addCheckFailedChannel records a channel whose lookup within the channel
+// graph failed within the report.
+func (r *jsonReport) addCheckFailedChannel(channel chanleak.InvalidChannel) {
+	jsonChannel := newJSONInvalidChannel(channel)
+	jsonChannel.LookupError = channel.LookupErr.Error()
+	r.CheckFailed = append(r.CheckFailed, jsonChannel)
+}
+
+// newJSONInvalidChannel returns the JSON representation of the given channel.
+func newJSONInvalidChannel(
+	channel chanleak.InvalidChannel) chanleak.ReportChannel {
+
+	jsonChannel := chanleak.ReportChannel{
+		ChanID:             channel.ChanID.ToUint64(),
+		ShortChanID:        channel.ChanID.String(),
+		OpenHeight:         channel.ChanID.BlockHeight,
+		RemotePubkey:       channel.RemotePubkey,
+		ChannelPoint:       channel.ChannelPoint,
+		PeerAlias:          channel.PeerAlias,
+		PeerAddresses:      channel.PeerAddresses,
+		Initiator:          channel.Initiator.String(),
+		Inactive:           channel.Inactive,
+		SubjectiveCapacity: int64(channel.SubjectiveCapacity),
+		GraphCapacity:      int64(channel.GraphCapacity),
+		InGraph:            channel.InGraph,
+		Private:            channel.Private,
+		Malformed:          channel.Malformed,
+		Closed:             channel.Closed,
+		InCrossGraph:       channel.InCrossGraph,
+		CrossGraphCapacity: int64(channel.CrossGraphCapacity),
+		CrossMismatch:      channel.CrossMismatch,
+	}
+
+	if mismatch := channel.ChainMismatch; mismatch != nil {
+		jsonChannel.ChainMismatch = &chanleak.ReportChainMismatch{
+			FundingOutpoint: mismatch.FundingOutpoint.String(),
+			State:           mismatch.State.String(),
+			ChainValue:      int64(mismatch.ChainValue),
+		}
+	}
+
+	for _, mismatch := range channel.PolicyMismatches {
+		jsonChannel.PolicyMismatches = append(
+			jsonChannel.PolicyMismatches,
+			chanleak.ReportPolicyMismatch{
+				NodePubkey:  mismatch.NodePubkey,
+				MaxHTLCMsat: uint64(mismatch.MaxHTLC),
+			},
+		)
+	}
+
+	return jsonChannel
+}
+
+// addCollisions records the given collisions of the node's open channels
+// within the report.
+func (r *jsonReport) addCollisions(collisions []chanleak.ChannelCollision) {
+	for _, collision := range collisions {
+		jsonCollision := chanleak.ReportCollision{
+			Kind: string(collision.Kind),
+			Key:  collision.Key,
+		}
+		for _, channel := range collision.Channels {
+			jsonCollision.Channels = append(
+				jsonCollision.Channels,
+				chanleak.ReportCollidingChannel{
+					ChanID:       channel.ChanID.ToUint64(),
+					ShortChanID:  channel.ChanID.String(),
+					ChannelPoint: channel.ChannelPoint,
+					RemotePubkey: channel.RemotePubkey,
+					Capacity:     int64(channel.Capacity),
+				},
+			)
+		}
+
+		r.Collisions = append(r.Collisions, jsonCollision)
+	}
+}
+
+// addChannelLoss records the amount lost over a particular channel within the
+// report, along with the forwards it's made up of, if they were kept.
+func (r *jsonReport) addChannelLoss(cid lnwire.ShortChannelID,
+	loss btcutil.Amount, forwards []chanleak.AttributedForward) {
+
+	channelLoss := chanleak.ReportChannelLoss{
+		ChanID:      cid.ToUint64(),
+		ShortChanID: cid.String(),
+		Loss:        int64(loss),
+	}
+	for _, forward := range forwards {
+		channelLoss.Forwards = append(
+			channelLoss.Forwards, chanleak.ReportForward{
+				Timestamp:    forward.Timestamp.UTC(),
+				ChanIDIn:     forward.ChanIn.ToUint64(),
+				ChanIDOut:    forward.ChanOut.ToUint64(),
+				Counterparty: forward.Counterparty,
+				AmtIn:        int64(forward.AmtIn),
+				AmtOut:       int64(forward.AmtOut),
+				Fee:          int64(forward.Fee),
+				Loss:         int64(forward.Loss),
+			},
+		)
+	}
+
+	r.ChannelLosses = append(r.ChannelLosses, channelLoss)
+}
+
+// setSeverities updates the severity of each invalid channel of the report
+// with the loss attributed to it. This must be called after all channel losses
+// have been added.
+func (r *jsonReport) setSeverities() {
+	losses := r.channelLosses()
+	for i := range r.InvalidChannels {
+		channel := &r.InvalidChannels[i]
+		channel.Severity = chanleak.Severity(
+			btcutil.Amount(channel.Mismatch),
+			btcutil.Amount(losses[channel.ChanID]),
+		)
+	}
+}
+
+// applySortOrder reorders the invalid channels of the report by the amount
+// lost over them or by their severity if -sort selects either. This must be
+// called after all channel losses have been added. Channels that tie keep
+// their order by short channel ID.
+func (r *jsonReport) applySortOrder() {
+	losses := r.channelLosses()
+	channels := r.InvalidChannels
+	switch *sortOrder {
+	case sortLoss:
+		sort.SliceStable(channels, func(i, j int) bool {
+			return losses[channels[i].ChanID] >
+				losses[channels[j].ChanID]
+		})
+
+	case sortSeverity:
+		sort.SliceStable(channels, func(i, j int) bool {
+			return channels[i].Severity > channels[j].Severity
+		})
+	}
+}
+
+// channelLosses returns the loss over each channel of the report, keyed by
+// the compact form of its short channel ID.
+func (r *jsonReport) channelLosses() map[uint64]int64 {
+	losses := make(map[uint64]int64, len(r.ChannelLosses))
+	for _, channelLoss := range r.ChannelLosses {
+		losses[channelLoss.ChanID] = channelLoss.Loss
+	}
+
+	return losses
+}
+
+// mostSevere returns the most severe invalid channel of the report, or nil if
+// there are none.
+func (r *jsonReport) mostSevere() *chanleak.ReportChannel {
+	var most *chanleak.ReportChannel
+	for i := range r.InvalidChannels {
+		channel := &r.InvalidChannels[i]
+		if most == nil || channel.Severity > most.Severity {
+			most = channel
+		}
+	}
+
+	return most
+}
+
+// setLossStats summarizes the distribution of the losses across the invalid
+// channels of the report. The invalid channels without any forwards over them
+// count as a loss of zero. This must be called after all invalid channels and
+// channel losses have been added.
+func (r *jsonReport) setLossStats() {
+	if len(r.InvalidChannels) == 0 {
+		return
+	}
+
+	losses := r.channelLosses()
+	stats := &chanleak.ReportLossStats{}
+	sorted := make([]int64, 0, len(r.InvalidChannels))
+	var sum int64
+	for _, channel := range r.InvalidChannels {
+		loss, ok := losses[channel.ChanID]
+		switch {
+		case !ok:
+			stats.NumWithoutForwards++
+
+		case loss != 0:
+			stats.NumWithLoss++
+		}
+
+		sorted = append(sorted, loss)
+		sum += loss
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	n := len(sorted)
+	stats.MeanLoss = float64(sum) / float64(n)
+	stats.MaxLoss = sorted[n-1]
+	if n%2 == 1 {
+		stats.MedianLoss = float64(sorted[n/2])
+	} else {
+		stats.MedianLoss = float64(sorted[n/2-1]+sorted[n/2]) / 2
+	}
+
+	r.LossStats = stats
+}
+
+// addPeerLosses records the amount lost to each remote peer within the
+// report, ranked by loss. This must be called after all invalid channels have
+// been added, so the peers' aliases can be filled in.
+func (r *jsonReport) addPeerLosses(peerLosses map[route.Vertex]btcutil.Amount) {
+	aliases := make(map[string]string, len(r.InvalidChannels))
+	for _, channel := range r.InvalidChannels {
+		aliases[channel.RemotePubkey] = channel.PeerAlias
+	}
+
+	for peer, loss := range peerLosses {
+		r.PeerLosses = append(r.PeerLosses, chanleak.ReportPeerLoss{
+			RemotePubkey: peer.String(),
+			PeerAlias:    aliases[peer.String()],
+			Loss:         int64(loss),
+		})
+	}
+
+	sort.Slice(r.PeerLosses, func(i, j int) bool {
+		if r.PeerLosses[i].Loss != r.PeerLosses[j].Loss {
+			return r.PeerLosses[i].Loss > r.PeerLosses[j].Loss
+		}
+
+		return r.PeerLosses[i].RemotePubkey <
+			r.PeerLosses[j].RemotePubkey
+	})
+}
+
+// addChannelClose records how an invalid channel was closed within the report.
+func (r *jsonReport) addChannelClose(cid lnwire.ShortChannelID,
+	chanClose chanleak.ChannelClose) {
+
+	r.ChannelCloses = append(r.ChannelCloses, chanleak.ReportChannelClose{
+		ChanID:            cid.ToUint64(),
+		ShortChanID:       cid.String(),
+		CloseType:         chanClose.CloseType.String(),
+		ClosingTxHash:     chanClose.ClosingTxHash,
+		CloseHeight:       chanClose.CloseHeight,
+		SettledBalance:    int64(chanClose.SettledBalance),
+		TimeLockedBalance: int64(chanClose.TimeLockedBalance),
+	})
+}
+
+// addBalanceChecks records the reconciliation of the balances of the invalid
+// channels within the report, sorted by channel ID.
+func (r *jsonReport) addBalanceChecks(
+	checks map[lnwire.ShortChannelID]chanleak.BalanceCheck) {
+
+	for cid, check := range checks {
+		r.BalanceChecks = append(
+			r.BalanceChecks, chanleak.ReportBalanceCheck{
+				ChanID:        cid.ToUint64(),
+				ShortChanID:   cid.String(),
+				LocalBalance:  int64(check.LocalBalance),
+				RemoteBalance: int64(check.RemoteBalance),
+				NetForwarded:  int64(check.NetForwarded),
+				ExpectedLocalBalance: int64(
+					check.ExpectedLocalBalance,
+				),
+				Discrepancy: int64(check.Discrepancy),
+				Incomplete:  check.Incomplete,
+			},
+		)
+	}
+
+	sort.Slice(r.BalanceChecks, func(i, j int) bool {
+		return r.BalanceChecks[i].ChanID < r.BalanceChecks[j].ChanID
+	})
+
+	if r.lossIncomplete() {
+		r.LossStatus = chanleak.LossPartial
+	}
+}
+
+// setLossError records that the loss couldn't be quantified due to the given
+// error.
+func (r *jsonReport) setLossError(err error) {
+	r.LossStatus = chanleak.LossUnavailable
+	r.LossError = err.Error()
+}
+
+// lossIncomplete returns true if the balance of any invalid channel hints at
+// forwarding events missing from the history.
+func (r *jsonReport) lossIncomplete() bool {
+	for _, check := range r.BalanceChecks {
+		if check.Incomplete {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setFiatRate converts all losses within the report to the fiat currency of
+// the given rate. This must be called after all losses have been added.
+func (r *jsonReport) setFiatRate(rate *fiatRate) {
+	if rate == nil {
+		return
+	}
+
+	r.FiatCurrency = rate.currency
+	r.FiatPrice = rate.price
+
+	for i := range r.ChannelLosses {
+		loss := btcutil.Amount(r.ChannelLosses[i].Loss)
+		lossFiat := rate.convert(loss)
+		r.ChannelLosses[i].LossFiat = &lossFiat
+	}
+
+	for i := range r.PeerLosses {
+		loss := btcutil.Amount(r.PeerLosses[i].Loss)
+		lossFiat := rate.convert(loss)
+		r.PeerLosses[i].LossFiat = &lossFiat
+	}
+
+	totalLossFiat := rate.convert(btcutil.Amount(r.TotalLoss))
+	r.TotalLossFiat = &totalLossFiat
+}
+
+// setOpenDates estimates the date each invalid channel within the report was
+// opened at, by counting back from the node's best block at the average block
+// interval. This must be called after all invalid channels have been added.
+func (r *jsonReport) setOpenDates(nodeInfo *lnrpc.GetInfoResponse) {
+	if nodeInfo == nil || nodeInfo.BestHeaderTimestamp == 0 {
+		return
+	}
+	bestTime := time.Unix(nodeInfo.BestHeaderTimestamp, 0)
+
+	for i := range r.InvalidChannels {
+		channel := &r.InvalidChannels[i]
+		if channel.OpenHeight > nodeInfo.BlockHeight {
+			continue
+		}
+
+		depth := nodeInfo.BlockHeight - channel.OpenHeight
+		opened := bestTime.Add(-time.Duration(depth) * blockInterval)
+		channel.ApproxOpenDate = opened.UTC().Format(openDateLayout)
+	}
+}
+
+// writeJSONReport serializes the report as a single JSON document to stdout.
+func writeJSONReport(report *jsonReport) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("unable to write json report: %v", err)
+	}
+
+	return nil
+}
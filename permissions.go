@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+const (
+	// permInfoRead is the macaroon permission lnd requires to read the
+	// node's info and its view of the channel graph.
+	permInfoRead = "info:read"
+
+	// permOffchainRead is the macaroon permission lnd requires to read the
+	// node's channels and its forwarding history.
+	permOffchainRead = "offchain:read"
+
+	// bakeMacaroonCmd is the command baking a macaroon with the minimal
+	// set of permissions chanleakcheck relies on.
+	bakeMacaroonCmd = "lncli bakemacaroon " + permInfoRead + " " +
+		permOffchainRead
+)
+
+// rpcPermissions maps each RPC chanleakcheck issues to the macaroon permission
+// lnd requires for it.
+var rpcPermissions = map[string]string{
+	"GetInfo":                permInfoRead,
+	"ListChannels":           permOffchainRead,
+	"ClosedChannels":         permOffchainRead,
+	"DescribeGraph":          permInfoRead,
+	"GetChanInfo":            permInfoRead,
+	"GetNodeInfo":            permInfoRead,
+	"ForwardingHistory":      permOffchainRead,
+	"SubscribeChannelEvents": permOffchainRead,
+}
+
+// requiredRPCs are the RPCs no scan can do without, so they must always be
+// part of -bakemac.
+var requiredRPCs = []string{"GetInfo", "ListChannels"}
+
+// parseBakemac parses the comma-separated list of RPCs the macaroon can access,
+// as given by -bakemac. An empty list means the macaroon is assumed to access
+// all of them, in which case nil is returned.
+func parseBakemac(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	allowed := make(map[string]bool)
+	for _, rpc := range strings.Split(s, ",") {
+		rpc = strings.TrimSpace(rpc)
+		if _, ok := rpcPermissions[rpc]; !ok {
+			return nil, fmt.Errorf("unknown RPC %q, must be one of %v",
+				rpc, strings.Join(knownRPCs(), ", "))
+		}
+		allowed[rpc] = true
+	}
+
+	for _, rpc := range requiredRPCs {
+		// The open channels may also be read from -channelsfallback.
+		if rpc == "ListChannels" && *channelsFallback != "" {
+			continue
+		}
+
+		if !allowed[rpc] {
+			return nil, fmt.Errorf("%v is required for any scan", rpc)
+		}
+	}
+
+	return allowed, nil
+}
+
+// effectiveGraphMode returns the graph mode scans should use. If DescribeGraph
+// isn't part of -bakemac, we'll fall back to looking up each channel, which
+// validateBakemacFlags ensures the macaroon can do.
+func effectiveGraphMode() string {
+	allowed, err := parseBakemac(*bakeMac)
+	if err != nil || allowed == nil || allowed["DescribeGraph"] {
+		return *graphMode
+	}
+
+	log.Infof("DescribeGraph isn't part of -bakemac, looking up each " +
+		"channel individually")
+
+	return chanleak.GraphModeLookup
+}
+
+// knownRPCs returns the names of all RPCs chanleakcheck issues, sorted.
+func knownRPCs() []string {
+	rpcs := make([]string, 0, len(rpcPermissions))
+	for rpc := range rpcPermissions {
+		rpcs = append(rpcs, rpc)
+	}
+	sort.Strings(rpcs)
+
+	return rpcs
+}
+
+// isPermissionErr returns true if the given RPC error was caused by the
+// macaroon lacking the permission the RPC requires. lnd's macaroon checker
+// reports this as a plain error, which the REST proxy passes on as is, so
+// we'll recognize it by its message.
+func isPermissionErr(err error) bool {
+	return strings.Contains(err.Error(), "permission denied")
+}
+
+// permissionClient wraps a node client, never issuing the RPCs the macaroon is
+// known not to access, and reporting which permission is missing whenever an
+// RPC is denied.
+type permissionClient struct {
+	nodeClient
+
+	// allowed is the set of RPCs the macaroon can access. If nil, it's
+	// assumed to access all of them.
+	allowed map[string]bool
+
+	// skippedCloses ensures we only log once that the closes of invalid
+	// channels are skipped.
+	skippedCloses sync.Once
+}
+
+// newPermissionClient returns a client enforcing the given set of RPCs the
+// macaroon can access. If the given client can stream channel events, so can
+// the returned one.
+func newPermissionClient(client nodeClient,
+	allowed map[string]bool) nodeClient {
+
+	permClient := &permissionClient{
+		nodeClient: client,
+		allowed:    allowed,
+	}
+
+	subscriber, ok := client.(channelEventSubscriber)
+	if !ok {
+		return permClient
+	}
+
+	return &permissionSubscriberClient{
+		permissionClient: permClient,
+		subscriber:       subscriber,
+	}
+}
+
+// check returns an error if the macaroon is known not to access the given RPC.
+func (p *permissionClient) check(rpc string) error {
+	if p.allowed == nil || p.allowed[rpc] {
+		return nil
+	}
+
+	return fmt.Errorf("%v isn't part of -bakemac, so it's skipped", rpc)
+}
+
+// permissionErr returns the given error of the given RPC, explaining which permission
+// is missing if the macaroon was denied.
+func permissionErr(rpc string, err error) error {
+	if err == nil || !isPermissionErr(err) {
+		return err
+	}
+
+	return fmt.Errorf("%v requires the %v permission, which the macaroon "+
+		"lacks, bake one with `%v`: %v", rpc, rpcPermissions[rpc],
+		bakeMacaroonCmd, err)
+}
+
+// GetInfo returns general information about the node.
+func (p *permissionClient) GetInfo(ctx context.Context,
+	in *lnrpc.GetInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+
+	if err := p.check("GetInfo"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.GetInfo(ctx, in, opts...)
+	return resp, permissionErr("GetInfo", err)
+}
+
+// ListChannels returns the set of currently open channels of the node.
+func (p *permissionClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	if err := p.check("ListChannels"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.ListChannels(ctx, in, opts...)
+	return resp, permissionErr("ListChannels", err)
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+// If the macaroon can't access them, the closes of invalid channels are merely
+// a detail of the loss, so we'll report none rather than fail it. Scanning the
+// closed channels themselves is rejected before any RPC is made.
+func (p *permissionClient) ClosedChannels(ctx context.Context,
+	in *lnrpc.ClosedChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	if err := p.check("ClosedChannels"); err != nil {
+		p.skippedCloses.Do(func() {
+			log.Infof("%v, the closes of invalid channels won't "+
+				"be reported", err)
+		})
+
+		return &lnrpc.ClosedChannelsResponse{}, nil
+	}
+
+	resp, err := p.nodeClient.ClosedChannels(ctx, in, opts...)
+	return resp, permissionErr("ClosedChannels", err)
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (p *permissionClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	if err := p.check("GetChanInfo"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.GetChanInfo(ctx, in, opts...)
+	return resp, permissionErr("GetChanInfo", err)
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (p *permissionClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	if err := p.check("DescribeGraph"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.DescribeGraph(ctx, in, opts...)
+	return resp, permissionErr("DescribeGraph", err)
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (p *permissionClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	if err := p.check("GetNodeInfo"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.GetNodeInfo(ctx, in, opts...)
+	return resp, permissionErr("GetNodeInfo", err)
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (p *permissionClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	if err := p.check("ForwardingHistory"); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.nodeClient.ForwardingHistory(ctx, in, opts...)
+	return resp, permissionErr("ForwardingHistory", err)
+}
+
+// permissionSubscriberClient is a permissionClient able to stream the channel
+// events of a node.
+type permissionSubscriberClient struct {
+	*permissionClient
+
+	subscriber channelEventSubscriber
+}
+
+// SubscribeChannelEvents streams an update whenever a channel of the node is
+// opened, closed, or changes its state.
+func (p *permissionSubscriberClient) SubscribeChannelEvents(
+	ctx context.Context, in *lnrpc.ChannelEventSubscription,
+	opts ...grpc.CallOption) (lnrpc.Lightning_SubscribeChannelEventsClient,
+	error) {
+
+	if err := p.check("SubscribeChannelEvents"); err != nil {
+		return nil, err
+	}
+
+	stream, err := p.subscriber.SubscribeChannelEvents(ctx, in, opts...)
+	return stream, permissionErr("SubscribeChannelEvents", err)
+}
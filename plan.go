@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+// runPlan reports the work a scan of the target node would incur without
+// carrying it out, and returns the exit code the process should terminate
+// with.
+func runPlan(ctx context.Context, interrupted <-chan struct{},
+	checker *chanleak.Checker) int {
+
+	plan, err := checker.Plan(ctx)
+	if err != nil {
+		return scanFailure(ctx, interrupted, err)
+	}
+
+	log.Infof("Channels to scan: %v (%v private)", plan.NumChannels,
+		plan.NumPrivate)
+	if plan.NumPrivateSkipped > 0 {
+		log.Infof("Private channels skipped: %v",
+			plan.NumPrivateSkipped)
+	}
+	log.Infof("DescribeGraph calls: %v", plan.NumDescribeGraphCalls)
+	log.Infof("GetChanInfo calls: %v", plan.NumChanInfoCalls)
+	if plan.NumChainLookups > 0 {
+		log.Infof("On-chain funding output lookups: %v",
+			plan.NumChainLookups)
+	}
+	log.Infof("If invalid channels are found, the forwarding history is "+
+		"fetched in pages of %v events", plan.ForwardingHistoryPageSize)
+
+	return exitCodeClean
+}
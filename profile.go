@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+// nodeProfile holds the connection details and credentials of a single lnd
+// node.
+type nodeProfile struct {
+	// Name is a human readable name of the node, used to tell nodes apart
+	// within the logs.
+	Name string `json:"name"`
+
+	// Host is the address of the node's RPC interface.
+	Host string `json:"host"`
+
+	// TLSPath is the path to the node's TLS cert.
+	TLSPath string `json:"tlspath"`
+
+	// TLSCert is the hex or base64 encoded TLS cert of the node. If set,
+	// it takes precedence over TLSPath.
+	TLSCert string `json:"tlscert"`
+
+	// MacaroonPath is the path to the macaroon used to authenticate with
+	// the node.
+	MacaroonPath string `json:"macaroonpath"`
+
+	// Macaroon is the hex or base64 encoded macaroon used to authenticate
+	// with the node. If set, it takes precedence over MacaroonPath.
+	Macaroon string `json:"macaroon"`
+
+	// Network is the network the node is expected to run on.
+	Network string `json:"network"`
+
+	// REST selects lnd's REST interface over its gRPC interface.
+	REST bool `json:"rest"`
+
+	// SOCKS is the SOCKS5 proxy to connect through, if any.
+	SOCKS string `json:"socks"`
+}
+
+// profileFromFlags returns the profile of the node specified on the command
+// line.
+func profileFromFlags() (*nodeProfile, error) {
+	profile := &nodeProfile{
+		Host:     *host,
+		TLSPath:  *tlsPath,
+		TLSCert:  credentialFromFlagOrEnv(*tlsCert, tlsCertEnv),
+		Macaroon: credentialFromFlagOrEnv(*macaroonHex, macaroonEnv),
+		Network:  *network,
+		REST:     *rest,
+		SOCKS:    *socks,
+	}
+	profile.Name = profile.Host
+
+	// The default host points at lnd's gRPC port, so we'll swap it out
+	// for the REST port if the REST interface was selected.
+	if profile.REST && !flagIsSet("host") {
+		profile.Host = net.JoinHostPort("localhost", defaultRESTPort)
+	}
+
+	// An encoded macaroon takes the place of the macaroon file, so we'll
+	// only go looking for the file if we need it. When replaying a
+	// snapshot or scanning exports, no credentials are needed at all.
+	if profile.Macaroon == "" && *replayPath == "" && !exportMode() {
+		macPath, err := resolveMacaroonPath()
+		if err != nil {
+			return nil, err
+		}
+		profile.MacaroonPath = macPath
+	}
+
+	return profile, nil
+}
+
+// fleetConfig is the layout of the config file listing the nodes to scan in
+// fleet mode.
+type fleetConfig struct {
+	// Nodes is the set of nodes to scan.
+	Nodes []*nodeProfile `json:"nodes"`
+}
+
+// loadFleetConfig reads the profiles of all nodes to scan from the JSON config
+// file at the given path.
+func loadFleetConfig(path string) ([]*nodeProfile, error) {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %v", err)
+	}
+
+	var cfg fleetConfig
+	if err := json.Unmarshal(configBytes, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse config %v: %v", path,
+			err)
+	}
+
+	if len(cfg.Nodes) == 0 {
+		return nil, fmt.Errorf("config %v lists no nodes", path)
+	}
+
+	for i, profile := range cfg.Nodes {
+		if profile.Host == "" {
+			return nil, fmt.Errorf("node %d within config has no "+
+				"host", i)
+		}
+		if profile.Name == "" {
+			profile.Name = profile.Host
+		}
+		if profile.Network == "" {
+			profile.Network = defaultNet
+		}
+		if err := validateNetwork(profile.Network); err != nil {
+			return nil, fmt.Errorf("node %v: %v", profile.Name,
+				err)
+		}
+
+		if profile.TLSCert == "" && profile.TLSPath == "" {
+			return nil, fmt.Errorf("node %v has neither tlscert "+
+				"nor tlspath", profile.Name)
+		}
+
+		if profile.Macaroon == "" {
+			if profile.MacaroonPath == "" {
+				return nil, fmt.Errorf("node %v has neither "+
+					"macaroon nor macaroonpath",
+					profile.Name)
+			}
+
+			_, err := os.Stat(profile.MacaroonPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read "+
+					"macaroon of node %v: %v",
+					profile.Name, err)
+			}
+		}
+	}
+
+	return cfg.Nodes, nil
+}
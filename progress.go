@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// progressLogInterval is the interval at which progress is logged if
+	// stderr isn't a terminal.
+	progressLogInterval = 5 * time.Second
+
+	// progressDrawInterval is the interval at which the progress bar is
+	// redrawn if stderr is a terminal.
+	progressDrawInterval = 100 * time.Millisecond
+
+	// progressBarWidth is the number of characters of the progress bar
+	// itself, excluding the counters.
+	progressBarWidth = 40
+)
+
+// progressReporter reports the progress of a scan. If stderr is a terminal, a
+// single updating progress bar is drawn, otherwise a progress line is logged
+// periodically. Progress is only reported at the info log level or below, and
+// the bar is only drawn at the info level with the text log format, as debug
+// output would break it up, and structured log lines must remain parseable.
+type progressReporter struct {
+	enabled bool
+	bar     bool
+
+	lastUpdate time.Time
+	drawn      bool
+}
+
+// newProgressReporter returns a progress reporter for a single scan.
+func newProgressReporter() *progressReporter {
+	level := log.Level()
+
+	return &progressReporter{
+		enabled: level <= btclog.LevelInfo,
+		bar: level == btclog.LevelInfo &&
+			*logFormat == logFormatText &&
+			terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// update records the latest progress of the scan. It's meant to be used as
+// the Progress callback of the checker.
+func (p *progressReporter) update(numChecked, numChannels, numInvalid int) {
+	if !p.enabled {
+		return
+	}
+
+	// We'll always report the final state, but otherwise rate limit our
+	// output.
+	interval := progressLogInterval
+	if p.bar {
+		interval = progressDrawInterval
+	}
+	finished := numChecked == numChannels
+	if !finished && time.Since(p.lastUpdate) < interval {
+		return
+	}
+	p.lastUpdate = time.Now()
+
+	if !p.bar {
+		// The final state is logged by the caller as part of the
+		// results anyway, so there's no need for a progress line.
+		if !finished {
+			log.Infof("Checked %v of %v channels, %v invalid so "+
+				"far", numChecked, numChannels, numInvalid)
+		}
+		return
+	}
+
+	filled := progressBarWidth
+	if numChannels > 0 {
+		filled = progressBarWidth * numChecked / numChannels
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %v/%v channels, %v invalid",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled), numChecked,
+		numChannels, numInvalid)
+	p.drawn = true
+}
+
+// done terminates the progress bar if one was drawn, so subsequent log lines
+// start on a fresh line. It's safe to call on a nil reporter.
+func (p *progressReporter) done() {
+	if p != nil && p.drawn {
+		fmt.Fprintln(os.Stderr)
+		p.drawn = false
+	}
+}
@@ -31,6 +31,12 @@ PACKAGESRC="$MAINDIR/$PACKAGE-source-$TAG.tar"
 git archive -o $PACKAGESRC HEAD
 gzip -f $PACKAGESRC > "$PACKAGESRC.gz"
 
+# The commit and build date are embedded into the binaries, so a report can
+# be traced back to the build that produced it.
+COMMIT=$(git rev-parse HEAD)
+BUILDDATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS="-X main.commit=$COMMIT -X main.buildDate=$BUILDDATE"
+
 cd $MAINDIR
 
 # If LNDBUILDSYS is set the default list is ignored. Useful to release
@@ -57,7 +63,7 @@ for i in $SYS; do
     cd $PACKAGE-$i-$TAG
 
     echo "Building:" $OS $ARCH $ARM
-    env GOOS=$OS GOARCH=$ARCH GOARM=$ARM go build -v -trimpath github.com/lightninglabs/chanleakcheck
+    env GOOS=$OS GOARCH=$ARCH GOARM=$ARM go build -v -trimpath -ldflags "$LDFLAGS" github.com/lightninglabs/chanleakcheck
     cd ..
 
     if [[ $OS = "windows" ]]; then
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// scanSummary describes the circumstances of a completed scan, for inclusion
+// in the text report.
+type scanSummary struct {
+	// nodeInfo is the identity of the scanned node.
+	nodeInfo *lnrpc.GetInfoResponse
+
+	// started is the time the scan was started at.
+	started time.Time
+
+	// duration is the time it took to complete the scan.
+	duration time.Duration
+
+	// numChecked is the number of channels that were verified.
+	numChecked int
+
+	// numChannels is the number of channels selected for the scan.
+	numChannels int
+}
+
+// newScanSummary returns the summary of a scan that was started at the given
+// time and just completed with the given result.
+func newScanSummary(nodeInfo *lnrpc.GetInfoResponse, started time.Time,
+	result *chanleak.ScanResult) *scanSummary {
+
+	return &scanSummary{
+		nodeInfo:    nodeInfo,
+		started:     started,
+		duration:    time.Since(started),
+		numChecked:  result.NumChecked,
+		numChannels: result.NumChannels,
+	}
+}
+
+// writeTextReport writes a self-contained, human readable report of the scan
+// to the given path, suitable for attaching to a support ticket. The file is
+// written atomically, so a crash midway never leaves a partial file behind.
+func writeTextReport(path string, report *jsonReport,
+	summary *scanSummary) error {
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		p := &reportPrinter{w: w}
+
+		p.printf("chanleakcheck report\n")
+		p.printf("====================\n\n")
+		p.printf("Generated:       %v\n",
+			time.Now().UTC().Format(time.RFC3339))
+		p.printf("Tool version:    %v\n", versionString())
+		p.printf("Scan started:    %v\n",
+			summary.started.UTC().Format(time.RFC3339))
+		p.printf("Scan duration:   %v\n",
+			summary.duration.Round(time.Millisecond))
+		if stats := report.Stats; stats != nil {
+			p.printf("RPCs issued:     %v\n", stats.RPCs.Total)
+		}
+		p.printf("\n")
+
+		p.printf("Node\n----\n")
+		if info := summary.nodeInfo; info != nil {
+			p.printf("Pubkey:          %v\n", info.IdentityPubkey)
+			p.printf("Alias:           %v\n", info.Alias)
+			p.printf("lnd version:     %v\n", info.Version)
+			p.printf("Synced to chain: %v\n", info.SyncedToChain)
+			p.printf("Synced to graph: %v\n", info.SyncedToGraph)
+			for _, chain := range info.Chains {
+				p.printf("Network:         %v %v\n",
+					chain.Chain, chain.Network)
+			}
+		}
+		p.printf("\n")
+
+		p.printf("Scan\n----\n")
+		p.printf("Channels scanned:      %v of %v\n",
+			summary.numChecked, summary.numChannels)
+		if coverage := report.Coverage; coverage != nil {
+			p.printf("Channels verified:     %v of %v (%.1f%%)\n",
+				coverage.NumVerified, coverage.NumChannels,
+				coverage.VerifiedPercent)
+			p.printf("Capacity verified:     %v of %v (%.1f%%)\n",
+				btcutil.Amount(coverage.VerifiedCapacity),
+				btcutil.Amount(coverage.TotalCapacity),
+				coverage.VerifiedCapacityPercent)
+		}
+		p.printf("Channels not in graph: %v\n", len(report.NotInGraph))
+		if len(report.CheckFailed) > 0 {
+			p.printf("Channels unchecked:    %v\n",
+				len(report.CheckFailed))
+		}
+		if report.IncompleteGraph != "" {
+			p.printf("Incomplete graph:      %v\n",
+				report.IncompleteGraph)
+		}
+		p.printf("Invalid channels:      %v\n\n",
+			len(report.InvalidChannels))
+
+		if len(report.InvalidChannels) > 0 {
+			p.printf("Invalid channels\n----------------\n")
+			for _, channel := range report.InvalidChannels {
+				p.printInvalidChannel(channel)
+			}
+			p.printf("\n")
+		}
+
+		if len(report.NotInGraph) > 0 {
+			p.printf("Channels not in graph\n")
+			p.printf("---------------------\n")
+			for _, channel := range report.NotInGraph {
+				p.printf("%v (chan_id=%v), capacity %v\n",
+					channel.ShortChanID, channel.ChanID,
+					btcutil.Amount(
+						channel.SubjectiveCapacity,
+					))
+			}
+			p.printf("\n")
+		}
+
+		if len(report.CheckFailed) > 0 {
+			p.printf("Channels whose check failed\n")
+			p.printf("---------------------------\n")
+			for _, channel := range report.CheckFailed {
+				p.printf("%v (chan_id=%v): %v\n",
+					channel.ShortChanID, channel.ChanID,
+					channel.LookupError)
+			}
+			p.printf("\n")
+		}
+
+		if len(report.ChannelCloses) > 0 {
+			p.printf("Closed invalid channels\n")
+			p.printf("-----------------------\n")
+			for _, chanClose := range report.ChannelCloses {
+				p.printChannelClose(chanClose)
+			}
+			p.printf("\n")
+		}
+
+		if len(report.BalanceChecks) > 0 {
+			p.printf("Balance reconciliation\n")
+			p.printf("----------------------\n")
+			for _, check := range report.BalanceChecks {
+				p.printBalanceCheck(check)
+			}
+			p.printf("\n")
+		}
+
+		if len(report.PeerLosses) > 0 {
+			p.printf("Loss by peer\n------------\n")
+			for _, peerLoss := range report.PeerLosses {
+				p.printPeerLoss(peerLoss, report.FiatCurrency)
+			}
+			p.printf("\n")
+		}
+
+		p.printf("Loss\n----\n")
+		if report.LossError != "" {
+			p.printf("Loss %v (insufficient history): %v\n",
+				report.LossStatus, report.LossError)
+
+			return p.err
+		}
+		for _, channelLoss := range report.ChannelLosses {
+			p.printf("%v: %v", channelLoss.ShortChanID,
+				btcutil.Amount(channelLoss.Loss))
+			if channelLoss.LossFiat != nil {
+				p.printf(" (~%.2f %v)", *channelLoss.LossFiat,
+					report.FiatCurrency)
+			}
+			p.printf("\n")
+		}
+		p.printf("Total loss: %v", btcutil.Amount(report.TotalLoss))
+		if report.TotalLossFiat != nil {
+			p.printf(" (~%.2f %v at %.2f %v/BTC)",
+				*report.TotalLossFiat, report.FiatCurrency,
+				report.FiatPrice, report.FiatCurrency)
+		}
+		p.printf("\n")
+		if stats := report.LossStats; len(report.InvalidChannels) > 1 {
+			p.printf("Loss per channel: mean %v, median %v, max "+
+				"%v\n", roundSats(stats.MeanLoss),
+				roundSats(stats.MedianLoss),
+				btcutil.Amount(stats.MaxLoss))
+			p.printf("Channels with a nonzero loss: %v, without "+
+				"any forwards: %v\n", stats.NumWithLoss,
+				stats.NumWithoutForwards)
+		}
+		if report.lossIncomplete() {
+			p.printf("The loss figure may be incomplete, see the " +
+				"balance reconciliation above\n")
+		}
+
+		return p.err
+	})
+}
+
+// reportPrinter writes formatted text to a writer, remembering the first
+// error encountered so the caller only needs to check once at the end.
+type reportPrinter struct {
+	w   io.Writer
+	err error
+}
+
+// printf writes the formatted string, unless a previous write failed.
+func (p *reportPrinter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+// printInvalidChannel writes the details of a single invalid channel.
+func (p *reportPrinter) printInvalidChannel(channel chanleak.ReportChannel) {
+	p.printf("%v (chan_id=%v)\n", channel.ShortChanID, channel.ChanID)
+	p.printf("  Remote peer:         %v\n", channel.RemotePubkey)
+	if channel.ApproxOpenDate != "" {
+		p.printf("  Opened at height:    %v (around %v)\n",
+			channel.OpenHeight, channel.ApproxOpenDate)
+	} else {
+		p.printf("  Opened at height:    %v\n", channel.OpenHeight)
+	}
+	if channel.PeerAlias != "" {
+		p.printf("  Peer alias:          %v\n", channel.PeerAlias)
+	}
+	p.printf("  Initiator:           %v\n", channel.Initiator)
+	switch {
+	case channel.Closed:
+		p.printf("  State:               closed\n")
+
+	case channel.Inactive:
+		p.printf("  State:               inactive\n")
+
+	default:
+		p.printf("  State:               active\n")
+	}
+	p.printf("  Subjective capacity: %v\n",
+		btcutil.Amount(channel.SubjectiveCapacity))
+	p.printf("  Graph capacity:      %v\n",
+		btcutil.Amount(channel.GraphCapacity))
+	if channel.InCrossGraph {
+		p.printf("  Cross graph:         %v\n",
+			btcutil.Amount(channel.CrossGraphCapacity))
+	}
+	if channel.Malformed != "" {
+		p.printf("  Malformed:           %v\n", channel.Malformed)
+	}
+	p.printf("  Severity:            %v (mismatch %v)\n",
+		channel.Severity, btcutil.Amount(channel.Mismatch))
+
+	if mismatch := channel.ChainMismatch; mismatch != nil {
+		p.printf("  Funding output:      %v (%v, %v)\n",
+			mismatch.FundingOutpoint, mismatch.State,
+			btcutil.Amount(mismatch.ChainValue))
+	}
+	for _, mismatch := range channel.PolicyMismatches {
+		p.printf("  Max HTLC:            %v by %v\n",
+			lnwire.MilliSatoshi(mismatch.MaxHTLCMsat),
+			mismatch.NodePubkey)
+	}
+}
+
+// printChannelClose writes how a single invalid channel was closed.
+func (p *reportPrinter) printChannelClose(
+	chanClose chanleak.ReportChannelClose) {
+
+	p.printf("%v (chan_id=%v)\n", chanClose.ShortChanID, chanClose.ChanID)
+	p.printf("  Close type:          %v\n", chanClose.CloseType)
+	p.printf("  Closing tx:          %v\n", chanClose.ClosingTxHash)
+	p.printf("  Close height:        %v\n", chanClose.CloseHeight)
+	p.printf("  Settled balance:     %v\n",
+		btcutil.Amount(chanClose.SettledBalance))
+	p.printf("  Time-locked balance: %v\n",
+		btcutil.Amount(chanClose.TimeLockedBalance))
+}
+
+// printBalanceCheck writes how the balance of a single invalid channel
+// reconciles with the forwards over it.
+func (p *reportPrinter) printBalanceCheck(check chanleak.ReportBalanceCheck) {
+	p.printf("%v (chan_id=%v)\n", check.ShortChanID, check.ChanID)
+	p.printf("  Local balance:       %v\n",
+		btcutil.Amount(check.LocalBalance))
+	p.printf("  Remote balance:      %v\n",
+		btcutil.Amount(check.RemoteBalance))
+	p.printf("  Net forwarded:       %v\n",
+		btcutil.Amount(check.NetForwarded))
+	p.printf("  Expected balance:    %v\n",
+		btcutil.Amount(check.ExpectedLocalBalance))
+	if check.Incomplete {
+		p.printf("  Loss figure may be incomplete, off by %v\n",
+			btcutil.Amount(check.Discrepancy))
+	}
+}
+
+// printPeerLoss writes the amount lost to a single remote peer.
+func (p *reportPrinter) printPeerLoss(peerLoss chanleak.ReportPeerLoss,
+	fiatCurrency string) {
+
+	p.printf("%v", peerLoss.RemotePubkey)
+	if peerLoss.PeerAlias != "" {
+		p.printf(" (alias=%q)", peerLoss.PeerAlias)
+	}
+	p.printf(": %v", btcutil.Amount(peerLoss.Loss))
+	if peerLoss.LossFiat != nil {
+		p.printf(" (~%.2f %v)", *peerLoss.LossFiat, fiatCurrency)
+	}
+	p.printf("\n")
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/tor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultRESTPort is the port we'll connect to in REST mode if the
+	// host doesn't specify one.
+	defaultRESTPort = "8080"
+
+	// macaroonHeader is the HTTP header lnd's REST proxy expects the hex
+	// encoded macaroon in.
+	macaroonHeader = "Grpc-Metadata-macaroon"
+)
+
+// restClient talks to lnd's REST proxy rather than its gRPC interface. This
+// allows checking nodes of hosting providers that only expose the REST API.
+// Errors are translated into gRPC status errors, so callers can handle them
+// the same way regardless of the transport.
+type restClient struct {
+	baseURL     string
+	macaroonHex string
+	maxRespSize int64
+	httpClient  *http.Client
+}
+
+// A compile-time check to ensure restClient satisfies nodeClient.
+var _ nodeClient = (*restClient)(nil)
+
+// newRESTClient creates a new REST client for the node of the given profile.
+func newRESTClient(profile *nodeProfile) (*restClient, error) {
+	certPool, err := loadTLSCertPool(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := loadMacaroon(profile)
+	if err != nil {
+		return nil, err
+	}
+	macBytes, err := mac.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode macaroon: %v", err)
+	}
+
+	addr := profile.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultRESTPort)
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs: certPool,
+		},
+	}
+	if socksAddr := socksProxy(profile); socksAddr != "" {
+		log.Infof("Connecting to %v through SOCKS proxy %v", addr,
+			socksAddr)
+
+		transport.Dial = func(_, addr string) (net.Conn, error) {
+			return tor.Dial(addr, socksAddr, false)
+		}
+	}
+
+	return &restClient{
+		baseURL:     "https://" + addr,
+		macaroonHex: hex.EncodeToString(macBytes),
+		maxRespSize: int64(*maxMsgSize) * 1024 * 1024,
+		httpClient: &http.Client{
+			Transport: transport,
+		},
+	}, nil
+}
+
+// restError is the body of an error response of lnd's REST proxy.
+type restError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// call sends a request for the given path to the REST proxy, and decodes the
+// response into resp. If req is set, it's sent as the JSON encoded body of a
+// POST request, otherwise a GET request is sent.
+func (r *restClient) call(ctx context.Context, path string, req,
+	resp proto.Message) error {
+
+	method := http.MethodGet
+	var body io.Reader
+	if req != nil {
+		marshaler := jsonpb.Marshaler{OrigName: true}
+		reqJSON, err := marshaler.MarshalToString(req)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to encode "+
+				"request: %v", err)
+		}
+
+		method = http.MethodPost
+		body = bytes.NewBufferString(reqJSON)
+	}
+
+	httpReq, err := http.NewRequest(method, r.baseURL+path, body)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	httpReq.Header.Set(macaroonHeader, r.macaroonHex)
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	// Failing to reach the node at all is the REST equivalent of the
+	// gRPC connection being unavailable, which allows failed requests to
+	// be retried.
+	httpResp, err := r.httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return status.Error(codes.DeadlineExceeded, err.Error())
+		}
+		if ctx.Err() == context.Canceled {
+			return status.Error(codes.Canceled, err.Error())
+		}
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer httpResp.Body.Close()
+
+	// We'll read at most one byte more than the maximum response size, so
+	// we can tell if the limit was exceeded.
+	respBody, err := ioutil.ReadAll(
+		io.LimitReader(httpResp.Body, r.maxRespSize+1),
+	)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if int64(len(respBody)) > r.maxRespSize {
+		return status.Errorf(codes.ResourceExhausted, "response "+
+			"larger than max (%d bytes)", r.maxRespSize)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		var restErr restError
+		if err := json.Unmarshal(respBody, &restErr); err != nil ||
+			restErr.Error == "" {
+
+			return status.Errorf(codes.Unknown, "REST request "+
+				"failed with status %v", httpResp.Status)
+		}
+
+		return status.Error(codes.Code(restErr.Code), restErr.Error)
+	}
+
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	err = unmarshaler.Unmarshal(bytes.NewReader(respBody), resp)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to decode "+
+			"response: %v", err)
+	}
+
+	return nil
+}
+
+// GetInfo returns general information about the node.
+func (r *restClient) GetInfo(ctx context.Context, _ *lnrpc.GetInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+
+	resp := &lnrpc.GetInfoResponse{}
+	if err := r.call(ctx, "/v1/getinfo", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ListChannels returns the set of currently open channels of the node.
+//
+// NOTE: The filters of the request aren't supported, all open channels are
+// always returned.
+func (r *restClient) ListChannels(ctx context.Context,
+	_ *lnrpc.ListChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	resp := &lnrpc.ListChannelsResponse{}
+	if err := r.call(ctx, "/v1/channels", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+//
+// NOTE: The filters of the request aren't supported, all closed channels are
+// always returned.
+func (r *restClient) ClosedChannels(ctx context.Context,
+	_ *lnrpc.ClosedChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	resp := &lnrpc.ClosedChannelsResponse{}
+	if err := r.call(ctx, "/v1/channels/closed", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (r *restClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	path := "/v1/graph/edge/" + strconv.FormatUint(in.ChanId, 10)
+
+	resp := &lnrpc.ChannelEdge{}
+	if err := r.call(ctx, path, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (r *restClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	path := "/v1/graph?include_unannounced=" +
+		strconv.FormatBool(in.IncludeUnannounced)
+
+	resp := &lnrpc.ChannelGraph{}
+	if err := r.call(ctx, path, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (r *restClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	path := "/v1/graph/node/" + url.PathEscape(in.PubKey) +
+		"?include_channels=" + strconv.FormatBool(in.IncludeChannels)
+
+	resp := &lnrpc.NodeInfo{}
+	if err := r.call(ctx, path, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (r *restClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	_ ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	resp := &lnrpc.ForwardingHistoryResponse{}
+	if err := r.call(ctx, "/v1/switch", in, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
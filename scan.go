@@ -0,0 +1,736 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// runScan carries out a single scan of the target node, and returns the exit
+// code the process should terminate with.
+func runScan(ctx context.Context, interrupted <-chan struct{},
+	checker *chanleak.Checker, metrics *scanMetrics,
+	nodeInfo *lnrpc.GetInfoResponse, progress *progressReporter) int {
+
+	report, summary, exitCode := scanNode(
+		ctx, interrupted, checker, metrics, nodeInfo, progress,
+	)
+	if report == nil {
+		return exitCode
+	}
+
+	if err := emitReport(report, summary); err != nil {
+		log.Errorf("%v", err)
+		return exitCodeFailure
+	}
+
+	if *quiet {
+		color := outcomeColor(len(report.InvalidChannels))
+		fmt.Println(colorize(
+			verdict(report), color, useColor(os.Stdout),
+		))
+	}
+
+	return exitCode
+}
+
+// verdict returns a single line summarizing the outcome of a scan, as printed
+// in quiet mode.
+func verdict(report *jsonReport) string {
+	numInvalid := len(report.InvalidChannels)
+
+	// Channels sharing a short channel ID or outpoint aren't confirmed to
+	// be fake, but neither is a node with any of them clean.
+	if numInvalid == 0 && len(report.Collisions) > 0 {
+		return fmt.Sprintf("SUSPICIOUS, %v channel collisions.",
+			len(report.Collisions))
+	}
+
+	// Likewise, we can't call a node clean whose channels we couldn't all
+	// check.
+	if numInvalid == 0 && len(report.CheckFailed) > 0 {
+		return fmt.Sprintf("INCOMPLETE, %v channels couldn't be "+
+			"checked.", len(report.CheckFailed))
+	}
+
+	switch numInvalid {
+	case 0:
+		return "CLEAN"
+
+	case 1:
+		if report.LossError != "" {
+			return "1 fake channel, amount at risk unknown."
+		}
+
+		return fmt.Sprintf("1 fake channel, %v sats at risk.",
+			report.TotalLoss)
+
+	default:
+		if report.LossError != "" {
+			return fmt.Sprintf("%v fake channels, amount at risk "+
+				"unknown.", numInvalid)
+		}
+
+		return fmt.Sprintf("%v fake channels, %v sats at risk.",
+			numInvalid, report.TotalLoss)
+	}
+}
+
+// scanNode carries out a single scan of a node and logs its results. The
+// report of the scan is returned along with the exit code matching its
+// outcome. If the scan failed, no report is returned. If the checker reports
+// its progress to the given reporter, it's terminated once the scan completes.
+func scanNode(ctx context.Context, interrupted <-chan struct{},
+	checker *chanleak.Checker, metrics *scanMetrics,
+	nodeInfo *lnrpc.GetInfoResponse,
+	progress *progressReporter) (*jsonReport, *scanSummary, int) {
+
+	started := time.Now()
+	stats := newScanStats(checker, started)
+
+	// The report collects the results of the scan, so we can emit them in
+	// one go if the JSON output mode was selected.
+	report := newJSONReport()
+
+	events.scanStarted(nodeInfo.IdentityPubkey)
+
+	log.Infof("Obtaining candidate set of invalidate channels...")
+	log.Infof("Filtering out valid channels...")
+
+	var invalidChannels, notInGraph, checkFailed []chanleak.InvalidChannel
+	endCheck := stats.phase(phaseCheck)
+	scanResult, err := checker.CheckChannels(ctx)
+	endCheck()
+	progress.done()
+	if scanResult != nil {
+		invalidChannels = scanResult.InvalidChannels
+		notInGraph = scanResult.NotInGraph
+		checkFailed = scanResult.CheckFailed
+	}
+
+	// Before reporting the invalid channels, we'll look up who they're
+	// with, so the operator knows which peer to act against.
+	sortInvalidChannels(invalidChannels)
+	sortInvalidChannels(notInGraph)
+	sortInvalidChannels(checkFailed)
+	endPeers := stats.phase(phasePeers)
+	checker.ResolvePeers(ctx, invalidChannels)
+	endPeers()
+	for _, channel := range notInGraph {
+		report.addNotInGraphChannel(channel)
+		logNotInGraphChannel(channel)
+	}
+	for _, channel := range checkFailed {
+		report.addCheckFailedChannel(channel)
+		logCheckFailedChannel(channel)
+	}
+	// If -limit caps how many invalid channels are printed, we'll hold off
+	// on logging them until we know which ones lost the most.
+	for _, channel := range invalidChannels {
+		report.addInvalidChannel(channel)
+		if *limit <= 0 {
+			logInvalidChannel(channel)
+		}
+		events.invalidChannel(channel)
+	}
+	report.setOpenDates(nodeInfo)
+
+	// If the scan was aborted midway, we'll report the partial results we
+	// obtained before bailing out.
+	if abortErr, ok := err.(*chanleak.ScanAbortedError); ok {
+		log.Warnf("Partial results: checked %v of %v channels, %v "+
+			"invalid and %v not in graph so far",
+			abortErr.NumChecked, abortErr.NumChannels,
+			len(invalidChannels), len(notInGraph))
+	}
+	if err != nil {
+		if *limit > 0 {
+			logInvalidChannels(invalidChannels, nil)
+		}
+		logScanStats(stats.report())
+		return nil, nil, scanFailure(ctx, interrupted, err)
+	}
+
+	// If the scan was restricted to a single channel, then not finding it
+	// is an error rather than a clean result.
+	if *channel != "" && scanResult.NumChannels == 0 {
+		log.Errorf("Channel %v not found among the node's open "+
+			"channels", *channel)
+		return nil, nil, exitCodeFailure
+	}
+
+	// Likewise, a peer we don't have any channels with can't be verified,
+	// which most likely means the wrong public key was given.
+	numSelected := scanResult.NumChannels + scanResult.NumPrivateSkipped
+	if *peer != "" && numSelected == 0 {
+		log.Errorf("No open channels with peer %v found", *peer)
+		return nil, nil, exitCodeFailure
+	}
+
+	events.scanCompleted(scanResult)
+
+	if scanResult.NumPrivateSkipped > 0 {
+		log.Infof("Skipped %v private channels, use -include-private "+
+			"to verify them on-chain", scanResult.NumPrivateSkipped)
+	}
+	if scanResult.NumAllowlisted > 0 {
+		log.Infof("Skipped %v allowlisted channels",
+			scanResult.NumAllowlisted)
+	}
+	if *includeClosed || *closedExplorer != "" {
+		log.Infof("Num closed channels verified: %v",
+			scanResult.NumClosedChecked)
+	}
+	if scanResult.NumClosedUnverified > 0 {
+		log.Warnf("Unable to verify %v closed channels as they were "+
+			"pruned from the graph, use -chainverify or "+
+			"-closedexplorer to verify them on-chain",
+			scanResult.NumClosedUnverified)
+	}
+	log.Infof("Num channels not found in graph: %v", len(notInGraph))
+	if len(checkFailed) > 0 {
+		log.Warnf("Num channels whose check failed: %v",
+			len(checkFailed))
+	}
+	if scanResult.IncompleteGraph != "" {
+		log.Warn("The channel graph looks incomplete, so the " +
+			"channels not found in it are most likely valid. " +
+			"Wait for the node to finish syncing the graph and " +
+			"scan again, or use -strict to fail such scans")
+
+		report.IncompleteGraph = scanResult.IncompleteGraph
+	}
+	log.Infof("Num invalid channels found: %v", len(invalidChannels))
+
+	// Channels sharing a short channel ID or outpoint can't all have been
+	// verified, so we'll report every one of them.
+	report.addCollisions(scanResult.Collisions)
+	for _, collision := range scanResult.Collisions {
+		logCollision(collision)
+	}
+
+	// To put a clean result into perspective, we'll also report how much
+	// of the node we were actually able to verify.
+	report.Coverage = newJSONCoverage(scanResult)
+	logCoverage(report.Coverage)
+
+	// If no invalid channels were found (yay!!!), then we're done here.
+	if len(invalidChannels) == 0 {
+		if len(scanResult.Collisions) == 0 && len(checkFailed) == 0 {
+			log.Infof("Your node was not affected by " +
+				"CVE-2019-12999!")
+		}
+
+		metrics.update(0, len(notInGraph), scanResult.NumChecked, 0)
+
+		report.Stats = stats.report()
+		logScanStats(report.Stats)
+
+		summary := newScanSummary(nodeInfo, started, scanResult)
+		exitCode := findingsExitCode(scanResult)
+		return report, summary, exitCode
+	}
+
+	log.Infof("Quantifying amount lost due to forwards over invalid channels...")
+
+	endLoss := stats.phase(phaseLoss)
+	lossReport, err := checker.QuantifyLoss(ctx, invalidChannels)
+	endLoss()
+	if abortErr, ok := err.(*chanleak.LossAbortedError); ok {
+		log.Warnf("Partial loss: %v over %v of %v fetched forwarding "+
+			"events so far, which is incomplete",
+			lossReport.TotalLoss, abortErr.NumProcessed,
+			abortErr.NumFetched)
+	}
+	switch {
+	// If the scan was interrupted or timed out in the meantime, we'll
+	// respect that rather than carry on. The invalid channels were found
+	// all the same, so we'll still print those -limit holds back.
+	case err != nil && ctx.Err() != nil:
+		if *limit > 0 {
+			logInvalidChannels(invalidChannels, nil)
+		}
+		logScanStats(stats.report())
+		return nil, nil, scanFailure(ctx, interrupted, err)
+
+	// Otherwise, the loss is merely a detail of the invalid channels we
+	// already found, so we'll still report them without it.
+	case err != nil:
+		log.Warnf("Unable to quantify the loss due to the invalid "+
+			"channels, reporting them without it: %v", err)
+		log.Warnf("Amount lost: %v (insufficient history)",
+			chanleak.LossUnavailable)
+		report.setLossError(err)
+		report.applySortOrder()
+		if *limit > 0 {
+			logInvalidChannels(invalidChannels, nil)
+		}
+
+		metrics.update(
+			len(invalidChannels), len(notInGraph),
+			scanResult.NumChecked, 0,
+		)
+		notifyWebhook(ctx, nodeInfo, report)
+
+		report.Stats = stats.report()
+		logScanStats(report.Stats)
+
+		summary := newScanSummary(nodeInfo, started, scanResult)
+		exitCode := findingsExitCode(scanResult)
+		return report, summary, exitCode
+	}
+
+	// If requested, we'll also express the losses in fiat.
+	var rate *fiatRate
+	if len(lossReport.ChannelLosses) > 0 {
+		rate = obtainFiatRate(ctx)
+	}
+
+	losses := lossReport.ChannelLosses
+	cids := sortedLossChannels(losses)
+	for _, chanID := range cids {
+		report.addChannelLoss(
+			chanID, losses[chanID],
+			lossReport.ChannelForwards[chanID],
+		)
+	}
+	if *limit > 0 {
+		logInvalidChannels(invalidChannels, report.channelLosses())
+	}
+
+	// Next, we'll print out each channel along with a breakdown for how
+	// many coins were lost as a result of it.
+	shown, numOmitted := displayedIndices(len(cids), func(i int) int64 {
+		return int64(losses[cids[i]])
+	})
+	for _, i := range shown {
+		chanID := cids[i]
+		logChannelLoss(
+			chanID, losses[chanID],
+			lossReport.ChannelForwards[chanID], rate,
+		)
+	}
+	if numOmitted > 0 {
+		log.Warnf("%v", omittedFooter(numOmitted))
+	}
+	report.setSeverities()
+	report.applySortOrder()
+	report.setLossStats()
+
+	// As a single peer may have opened several fake channels, we'll also
+	// report the losses rolled up by peer, ranked by loss.
+	report.addPeerLosses(lossReport.PeerLosses)
+	for _, peerLoss := range report.PeerLosses {
+		log.Warnf("Peer(%v) resulted in net loss of: %v",
+			peerLoss.RemotePubkey,
+			formatLoss(btcutil.Amount(peerLoss.Loss), rate))
+	}
+
+	// For the channels that have since been closed, we'll also note how
+	// they were resolved on-chain. The forwards only tell us how much
+	// could have been lost, while the close shows what was settled.
+	for _, chanID := range sortedCloseChannels(lossReport.ChannelCloses) {
+		chanClose := lossReport.ChannelCloses[chanID]
+		log.Warnf("FakeChannel(%v) was closed by %v at height %v in "+
+			"tx %v, settled balance: %v, time-locked balance: %v",
+			chanID, chanClose.CloseType, chanClose.CloseHeight,
+			chanClose.ClosingTxHash, chanClose.SettledBalance,
+			chanClose.TimeLockedBalance)
+
+		report.addChannelClose(chanID, chanClose)
+	}
+
+	report.addBalanceChecks(lossReport.BalanceChecks)
+
+	log.Warnf("Amount lost: %v", formatLoss(lossReport.TotalLoss, rate))
+	if len(report.InvalidChannels) > 1 {
+		logLossStats(report.LossStats, rate)
+		logMostSevere(report.mostSevere())
+	}
+	if report.lossIncomplete() {
+		log.Warnf("Loss figure may be incomplete, as the forwarding " +
+			"history doesn't account for the balance of every " +
+			"invalid channel")
+	}
+
+	metrics.update(
+		len(invalidChannels), len(notInGraph), scanResult.NumChecked,
+		lossReport.TotalLoss,
+	)
+
+	report.TotalLoss = int64(lossReport.TotalLoss)
+	report.setFiatRate(rate)
+	events.lossComputed(report)
+	notifyWebhook(ctx, nodeInfo, report)
+
+	report.Stats = stats.report()
+	logScanStats(report.Stats)
+
+	summary := newScanSummary(nodeInfo, started, scanResult)
+	exitCode := findingsExitCode(scanResult)
+	return report, summary, exitCode
+}
+
+// logCoverage logs how much of the node was verified by a scan.
+func logCoverage(coverage *chanleak.ReportCoverage) {
+	log.Infof("Scanned %v channels totaling %v, %v channels totaling %v "+
+		"verified (%.1f%% of channels, %.1f%% of capacity)",
+		coverage.NumChannels, btcutil.Amount(coverage.TotalCapacity),
+		coverage.NumVerified, btcutil.Amount(coverage.VerifiedCapacity),
+		coverage.VerifiedPercent, coverage.VerifiedCapacityPercent)
+
+	if coverage.NumVerified < coverage.NumChannels {
+		unverified := coverage.TotalCapacity - coverage.VerifiedCapacity
+		log.Warnf("%.1f%% of channels totaling %v couldn't be "+
+			"verified, as they're private, missing from the "+
+			"graph or their check failed",
+			100-coverage.VerifiedPercent,
+			btcutil.Amount(unverified))
+	}
+}
+
+// logNotInGraphChannel logs a channel that couldn't be found within the
+// channel graph. As this is expected for private and freshly opened channels,
+// it's only logged as a notice rather than as a fake channel.
+func logNotInGraphChannel(channel chanleak.InvalidChannel) {
+	log.Warnf("Unable to obtain graph channel for cid(%v), it may be "+
+		"private or not yet announced: %v", channel.ChanID,
+		channel.LookupErr)
+}
+
+// logCheckFailedChannel logs a channel whose lookup within the channel graph
+// kept failing, so it couldn't be checked.
+func logCheckFailedChannel(channel chanleak.InvalidChannel) {
+	log.Warnf("Unable to check cid(%v), its graph lookup failed: %v",
+		channel.ChanID, channel.LookupErr)
+}
+
+// logChannelLoss logs the amount lost over a single invalid channel, along with
+// each of the forwards it was attributed from.
+func logChannelLoss(chanID lnwire.ShortChannelID, amtLost btcutil.Amount,
+	forwards []chanleak.AttributedForward, rate *fiatRate) {
+
+	if amtLost < 0 {
+		log.Warnf("FakeChannel(%v) resulted in net recovery of: %v",
+			chanID, formatLoss(-amtLost, rate))
+	} else {
+		log.Warnf("FakeChannel(%v) resulted in loss of: %v", chanID,
+			formatLoss(amtLost, rate))
+	}
+
+	for _, forward := range forwards {
+		log.Infof("FakeChannel(%v) forward at %v: %v in over %v, %v "+
+			"out over %v, fee %v, counterparty %v, loss %v", chanID,
+			forward.Timestamp.UTC().Format(time.RFC3339),
+			forward.AmtIn, forward.ChanIn, forward.AmtOut,
+			forward.ChanOut, forward.Fee, forward.Counterparty,
+			forward.Loss)
+	}
+}
+
+// logLossStats logs the distribution of the losses across several invalid
+// channels, so the operator can tell a single large loss apart from many small
+// ones.
+func logLossStats(stats *chanleak.ReportLossStats, rate *fiatRate) {
+	log.Warnf("Loss per channel: mean %v, median %v, max %v, %v channels "+
+		"with a nonzero loss, %v without any forwards",
+		formatLoss(roundSats(stats.MeanLoss), rate),
+		formatLoss(roundSats(stats.MedianLoss), rate),
+		formatLoss(btcutil.Amount(stats.MaxLoss), rate),
+		stats.NumWithLoss, stats.NumWithoutForwards)
+}
+
+// logMostSevere logs the most severe of several invalid channels, so the
+// operator knows which one to act on first.
+func logMostSevere(channel *chanleak.ReportChannel) {
+	log.Warnf("Most severe finding: FakeChannel(%v) with %v, severity %v "+
+		"(mismatch %v)", channel.ShortChanID, channel.RemotePubkey,
+		channel.Severity, btcutil.Amount(channel.Mismatch))
+}
+
+// roundSats rounds the given amount in satoshis to the nearest satoshi.
+func roundSats(sats float64) btcutil.Amount {
+	return btcutil.Amount(math.Round(sats))
+}
+
+// logCollision logs several open channels sharing the same short channel ID
+// or funding outpoint.
+func logCollision(collision chanleak.ChannelCollision) {
+	log.Warnf("**** CHANNEL COLLISION FOUND ****")
+	log.Warnf("%v open channels share the %v %v", len(collision.Channels),
+		collisionKindName(collision.Kind), collision.Key)
+	for _, channel := range collision.Channels {
+		log.Warnf("Channel %v (chan_id=%v) with %v, channel point %v, "+
+			"capacity %v", channel.ChanID, channel.ChanID.ToUint64(),
+			channel.RemotePubkey, channel.ChannelPoint,
+			channel.Capacity)
+	}
+	log.Warnf("*********************************")
+}
+
+// collisionKindName returns a human readable name of what the channels of a
+// collision share.
+func collisionKindName(kind chanleak.CollisionKind) string {
+	if kind == chanleak.CollisionOutpoint {
+		return "funding outpoint"
+	}
+
+	return "short channel ID"
+}
+
+// logInvalidChannels logs the details of the invalid channels -limit allows to
+// be printed, those with the highest loss according to the given per-channel
+// losses first, followed by the number of channels left out. If the loss is
+// unavailable, those with the highest subjective capacity come first instead,
+// as they're the ones that could have drained the most.
+func logInvalidChannels(channels []chanleak.InvalidChannel,
+	losses map[uint64]int64) {
+
+	shown, numOmitted := displayedIndices(len(channels), func(i int) int64 {
+		if losses == nil {
+			return int64(channels[i].SubjectiveCapacity)
+		}
+
+		return losses[channels[i].ChanID.ToUint64()]
+	})
+	for _, i := range shown {
+		logInvalidChannel(channels[i])
+	}
+	if numOmitted > 0 {
+		log.Warnf("%v", omittedFooter(numOmitted))
+	}
+}
+
+// logInvalidChannel logs the details of a single confirmed invalid channel.
+func logInvalidChannel(channel chanleak.InvalidChannel) {
+	cid := channel.ChanID
+	if channel.CapacityMismatch {
+		log.Warnf("**** FAKE CHANNEL FOUND ****")
+		logChannelID(cid)
+		logPeer(channel)
+		logChannelState(channel)
+		log.Warnf("Actual channel value: %v", channel.GraphCapacity)
+		log.Warnf("Subjective channel value: %v",
+			channel.SubjectiveCapacity)
+		log.Warnf("****************************")
+	}
+
+	// A disagreement between the two graphs is reported with all three
+	// views of the channel, as any one of them may be the odd one out.
+	if channel.CrossMismatch {
+		log.Warnf("**** GRAPH DISAGREEMENT FOUND ****")
+		logChannelID(cid)
+		logPeer(channel)
+		logChannelState(channel)
+		log.Warnf("Subjective channel value: %v",
+			channel.SubjectiveCapacity)
+		log.Warnf("Graph channel value: %v", channel.GraphCapacity)
+		log.Warnf("Cross graph channel value: %v",
+			channel.CrossGraphCapacity)
+		log.Warnf("**********************************")
+	}
+
+	if mismatch := channel.ChainMismatch; mismatch != nil {
+		log.Warnf("**** CHAIN MISMATCH FOUND ****")
+		logChannelID(cid)
+		logPeer(channel)
+		logChannelState(channel)
+		log.Warnf("Funding outpoint: %v", mismatch.FundingOutpoint)
+		log.Warnf("Funding output state: %v", mismatch.State)
+		log.Warnf("On-chain channel value: %v", mismatch.ChainValue)
+		if !channel.InGraph {
+			log.Warnf("Subjective channel value: %v",
+				channel.SubjectiveCapacity)
+		} else {
+			log.Warnf("Graph channel value: %v",
+				channel.GraphCapacity)
+		}
+		log.Warnf("******************************")
+	}
+
+	if len(channel.PolicyMismatches) > 0 {
+		log.Warnf("**** POLICY MISMATCH FOUND ****")
+		logChannelID(cid)
+		logPeer(channel)
+		logChannelState(channel)
+		log.Warnf("Graph channel value: %v", channel.GraphCapacity)
+		for _, mismatch := range channel.PolicyMismatches {
+			log.Warnf("Max HTLC advertised by %v: %v",
+				mismatch.NodePubkey, mismatch.MaxHTLC)
+		}
+		log.Warnf("*******************************")
+	}
+
+	if channel.Malformed != "" {
+		log.Warnf("**** MALFORMED CHANNEL FOUND ****")
+		logChannelID(cid)
+		logPeer(channel)
+		logChannelState(channel)
+		log.Warnf("Graph channel value: %v", channel.GraphCapacity)
+		log.Warnf("Reason: %v", channel.Malformed)
+		log.Warnf("*********************************")
+	}
+}
+
+// logChannelID logs the short channel ID of a channel along with its decoded
+// components, so it can be cross-referenced against a block explorer.
+func logChannelID(cid lnwire.ShortChannelID) {
+	log.Warnf("CID: %v (chan_id=%v)", cid, cid.ToUint64())
+	log.Warnf("Funding block height: %v", cid.BlockHeight)
+	log.Warnf("Funding tx index: %v", cid.TxIndex)
+	log.Warnf("Funding output index: %v", cid.TxPosition)
+}
+
+// logPeer logs the remote peer of a channel, and which side opened the
+// channel. If the peer is known to the channel graph, its alias and addresses
+// are logged along with its public key.
+func logPeer(channel chanleak.InvalidChannel) {
+	if channel.PeerAlias == "" && len(channel.PeerAddresses) == 0 {
+		log.Warnf("Remote peer: %v", channel.RemotePubkey)
+	} else {
+		log.Warnf("Remote peer: %v (alias=%q)", channel.RemotePubkey,
+			channel.PeerAlias)
+		for _, addr := range channel.PeerAddresses {
+			log.Warnf("Remote peer address: %v", addr)
+		}
+	}
+
+	log.Warnf("Initiator: %v", channel.Initiator)
+}
+
+// logChannelState notes that a channel has since been closed, so the operator
+// doesn't go looking for it among the node's open channels, or that it's
+// currently inactive, as its peer may have to come back online first.
+func logChannelState(channel chanleak.InvalidChannel) {
+	switch {
+	case channel.Closed:
+		log.Warnf("Channel state: closed")
+
+	case channel.Inactive:
+		log.Warnf("Channel state: inactive")
+	}
+}
+
+// scanFailure logs the error that caused the scan to fail, and returns the
+// matching exit code. If the error was caused by the scan timing out, we'll
+// point the user at the timeout flag.
+func scanFailure(ctx context.Context, interrupted <-chan struct{},
+	err error) int {
+
+	select {
+	case <-interrupted:
+		log.Errorf("Scan interrupted: %v", err)
+		return exitCodeInterrupted
+	default:
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("Scan did not complete within %v, consider raising "+
+			"the -timeout flag: %v", *timeout, err)
+		return exitCodeTimeout
+	}
+
+	if isMsgSizeErr(err) {
+		log.Errorf("Response from lnd exceeded the maximum message "+
+			"size of %v MB, consider raising the -maxmsgsize "+
+			"flag: %v", *maxMsgSize, err)
+		return exitCodeFailure
+	}
+
+	log.Errorf("%v", err)
+	return exitCodeFailure
+}
+
+// emitReport writes the final report to stdout if the JSON output mode was
+// selected, and to the CSV and text report files if they were requested. In
+// text mode the results have already been logged, so only the canonical
+// result line is written to stdout, and the invalid channels are summarized
+// in a table on stderr if requested.
+func emitReport(report *jsonReport, summary *scanSummary) error {
+	// The history is only a record for later review, so failing to
+	// update it doesn't fail the scan.
+	if *dbPath != "" {
+		if err := recordScan(*dbPath, report, summary); err != nil {
+			log.Warnf("Unable to record scan: %v", err)
+		}
+	}
+
+	if *csvPath != "" {
+		if err := writeCSVReport(*csvPath, report); err != nil {
+			return err
+		}
+	}
+
+	if *reportPath != "" {
+		err := writeTextReport(*reportPath, report, summary)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The suggested commands go to stderr along with the logs, so they
+	// don't interfere with the results on stdout.
+	if *suggestActions {
+		if err := writeSuggestedActions(os.Stderr, report); err != nil {
+			return err
+		}
+	}
+
+	// In text mode, the invalid channels have been logged one by one. To
+	// make them easier to compare, we'll also summarize them in a table.
+	if *outputFormat != outputJSON {
+		if len(report.InvalidChannels) != 0 && useTable() {
+			err := writeTable(
+				os.Stderr, report, useColor(os.Stderr),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		// In quiet mode, the verdict takes the place of the result
+		// line, while a stream of JSON events would be corrupted by
+		// it.
+		if !*quiet && !*jsonStream {
+			color := outcomeColor(len(report.InvalidChannels))
+			fmt.Println(colorize(
+				resultLine(report, summary), color,
+				useColor(os.Stdout),
+			))
+		}
+
+		return nil
+	}
+
+	return writeJSONReport(report)
+}
+
+// resultLine returns the canonical line summarizing the outcome of a scan in
+// text mode, such as:
+//
+//	RESULT invalid=1 loss_sats=150000 scanned=42 node=<pubkey>
+//
+// Its format is stable, so scripts can scrape it no matter how the log lines
+// are worded, and new fields are only ever appended. If the loss couldn't be
+// quantified, loss_sats is unknown.
+func resultLine(report *jsonReport, summary *scanSummary) string {
+	loss := fmt.Sprint(report.TotalLoss)
+	if report.LossError != "" {
+		loss = "unknown"
+	}
+
+	var node string
+	if summary.nodeInfo != nil {
+		node = summary.nodeInfo.IdentityPubkey
+	}
+
+	return fmt.Sprintf("RESULT invalid=%v loss_sats=%v scanned=%v node=%v",
+		len(report.InvalidChannels), loss, summary.numChecked, node)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// captureLogs redirects the logger of the main package to the returned buffer.
+// The returned function restores the original logger.
+func captureLogs() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	origLog := log
+	log = btclog.NewBackend(&buf).Logger("CHLK")
+
+	return &buf, func() {
+		log = origLog
+	}
+}
+
+// TestLogInvalidChannelCID makes sure the short channel ID of a fake channel
+// is logged, along with its decoded components.
+func TestLogInvalidChannelCID(t *testing.T) {
+	buf, restore := captureLogs()
+	defer restore()
+
+	cid := lnwire.ShortChannelID{
+		BlockHeight: 590000,
+		TxIndex:     1,
+		TxPosition:  0,
+	}
+	logInvalidChannel(chanleak.InvalidChannel{
+		ChanID:             cid,
+		SubjectiveCapacity: 16000000,
+		GraphCapacity:      100000,
+		InGraph:            true,
+		CapacityMismatch:   true,
+	})
+
+	output := buf.String()
+	expected := []string{
+		"CID: 590000:1:0 (chan_id=648711860387905536)",
+		"Funding block height: 590000",
+		"Funding tx index: 1",
+		"Funding output index: 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Fatalf("expected log output to contain %q, got:\n%v",
+				line, output)
+		}
+	}
+	if strings.Contains(output, "%!") {
+		t.Fatalf("log output contains a formatting error:\n%v", output)
+	}
+}
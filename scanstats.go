@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+const (
+	// phaseCheck is the phase verifying the channels against the channel
+	// graph, and on-chain if requested.
+	phaseCheck = "check"
+
+	// phasePeers is the phase looking up the remote peers of the invalid
+	// channels.
+	phasePeers = "peers"
+
+	// phaseLoss is the phase quantifying the loss due to the invalid
+	// channels.
+	phaseLoss = "loss"
+)
+
+// scanStats tracks the wall-clock time a scan and each of its phases take,
+// along with the RPCs it issues to lnd, for performance diagnostics.
+type scanStats struct {
+	checker *chanleak.Checker
+
+	// started is the time the scan was started at.
+	started time.Time
+
+	// rpcsBefore is the number of RPCs the checker had issued before the
+	// scan, as a checker is reused across the scans of watch mode.
+	rpcsBefore chanleak.RPCCounts
+
+	phases []chanleak.ReportPhase
+}
+
+// newScanStats returns the stats of a scan carried out by the given checker
+// that was started at the given time.
+func newScanStats(checker *chanleak.Checker, started time.Time) *scanStats {
+	return &scanStats{
+		checker:    checker,
+		started:    started,
+		rpcsBefore: checker.RPCCounts(),
+	}
+}
+
+// phase starts timing the phase of the given name, and returns the closure
+// that ends it.
+func (s *scanStats) phase(name string) func() {
+	started := time.Now()
+
+	return func() {
+		s.phases = append(s.phases, chanleak.ReportPhase{
+			Name:       name,
+			DurationMs: millis(time.Since(started)),
+		})
+	}
+}
+
+// report returns the JSON representation of the stats of the scan so far.
+func (s *scanStats) report() *chanleak.ReportStats {
+	rpcs := s.checker.RPCCounts().Sub(s.rpcsBefore)
+
+	phases := s.phases
+	if phases == nil {
+		phases = []chanleak.ReportPhase{}
+	}
+
+	return &chanleak.ReportStats{
+		DurationMs: millis(time.Since(s.started)),
+		Phases:     phases,
+		RPCs: chanleak.ReportRPCCounts{
+			ListChannels:      rpcs.ListChannels,
+			ClosedChannels:    rpcs.ClosedChannels,
+			GetChanInfo:       rpcs.GetChanInfo,
+			DescribeGraph:     rpcs.DescribeGraph,
+			GetNodeInfo:       rpcs.GetNodeInfo,
+			ForwardingHistory: rpcs.ForwardingHistory,
+			Total:             rpcs.Total(),
+		},
+	}
+}
+
+// millis returns the given duration in whole milliseconds.
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// logScanStats logs how long a scan took and how many RPCs it issued, so a
+// report of a slow scan carries the data to act on.
+func logScanStats(stats *chanleak.ReportStats) {
+	phases := make([]string, 0, len(stats.Phases))
+	for _, phase := range stats.Phases {
+		phases = append(phases, phase.Name+" "+msString(phase.DurationMs))
+	}
+	log.Infof("Scan took %v (%v)", msString(stats.DurationMs),
+		strings.Join(phases, ", "))
+
+	rpcs := stats.RPCs
+	log.Infof("RPCs issued: %v total, %v ListChannels, %v GetChanInfo, "+
+		"%v DescribeGraph, %v GetNodeInfo, %v ForwardingHistory, %v "+
+		"ClosedChannels", rpcs.Total, rpcs.ListChannels,
+		rpcs.GetChanInfo, rpcs.DescribeGraph, rpcs.GetNodeInfo,
+		rpcs.ForwardingHistory, rpcs.ClosedChannels)
+}
+
+// msString formats the given number of milliseconds as a duration.
+func msString(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
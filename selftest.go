@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const (
+	// selfTestName is the name the self-test fixture is referred to by in
+	// the logs.
+	selfTestName = "self-test fixture"
+
+	// selfTestFakeChannel is the short channel ID of the fake channel of
+	// the self-test fixture.
+	selfTestFakeChannel = "590000:1:0"
+
+	// selfTestFakePeer is the public key of the remote peer of the fake
+	// channel of the self-test fixture.
+	selfTestFakePeer = "03bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	// selfTestExpectedLoss is the loss the self-test fixture must result
+	// in. Two forwards came in over the fake channel, which paid out
+	// 1,000,000 and 2,000,000 real sats over the valid channel, while one
+	// forward went out over it, which recovered 500,050 real sats.
+	selfTestExpectedLoss = 1000000 + 2000000 - 500050
+)
+
+// selfTestFixture is a snapshot of a node that fell for CVE-2019-12999: the
+// remote peer of channel 590000:1:0 claimed to fund it with 0.16 BTC, while
+// its funding output only holds 0.001 BTC, as the channel graph shows. The
+// peer then drained real coins from the node by forwarding fake ones over the
+// channel to a valid channel, 590001:2:0. Its local balance matches the
+// forwards, so the loss reconciles.
+const selfTestFixture = `{
+  "toolVersion": "selftest",
+  "createdAt": "2019-09-10T00:00:00Z",
+  "getInfo": {
+    "identity_pubkey": "02aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+    "alias": "victim",
+    "block_height": 593000,
+    "synced_to_chain": true,
+    "synced_to_graph": true,
+    "chains": [{"chain": "bitcoin", "network": "mainnet"}]
+  },
+  "listChannels": {
+    "channels": [
+      {
+        "active": true,
+        "remote_pubkey": "03bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+        "channel_point": "1111111111111111111111111111111111111111111111111111111111111111:0",
+        "chan_id": "648711860387905536",
+        "capacity": "16000000",
+        "local_balance": "2500300",
+        "remote_balance": "13499700",
+        "initiator": false
+      },
+      {
+        "active": true,
+        "remote_pubkey": "03cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
+        "channel_point": "2222222222222222222222222222222222222222222222222222222222222222:0",
+        "chan_id": "648712959899598848",
+        "capacity": "5000000",
+        "local_balance": "2999950",
+        "remote_balance": "2000050",
+        "initiator": true
+      }
+    ]
+  },
+  "describeGraph": {
+    "nodes": [
+      {
+        "pub_key": "03bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+        "alias": "attacker"
+      },
+      {
+        "pub_key": "03cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
+        "alias": "honest"
+      }
+    ],
+    "edges": [
+      {
+        "channel_id": "648711860387905536",
+        "chan_point": "1111111111111111111111111111111111111111111111111111111111111111:0",
+        "node1_pub": "02aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+        "node2_pub": "03bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+        "capacity": "100000"
+      },
+      {
+        "channel_id": "648712959899598848",
+        "chan_point": "2222222222222222222222222222222222222222222222222222222222222222:0",
+        "node1_pub": "02aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+        "node2_pub": "03cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
+        "capacity": "5000000"
+      }
+    ]
+  },
+  "forwardingEvents": [
+    {
+      "timestamp": "1567296000",
+      "chan_id_in": "648711860387905536",
+      "chan_id_out": "648712959899598848",
+      "amt_in": "1000100",
+      "amt_out": "1000000",
+      "fee": "100"
+    },
+    {
+      "timestamp": "1567299600",
+      "chan_id_in": "648711860387905536",
+      "chan_id_out": "648712959899598848",
+      "amt_in": "2000200",
+      "amt_out": "2000000",
+      "fee": "200"
+    },
+    {
+      "timestamp": "1567303200",
+      "chan_id_in": "648712959899598848",
+      "chan_id_out": "648711860387905536",
+      "amt_in": "500050",
+      "amt_out": "500000",
+      "fee": "50"
+    }
+  ]
+}`
+
+// runSelfTest scans the self-test fixture with the same pipeline as a regular
+// scan, and verifies that exactly its fake channel is found with the expected
+// loss. It returns the exit code the process should terminate with.
+func runSelfTest(ctx context.Context, interrupted <-chan struct{}) int {
+	client, err := decodeReplayClient(selfTestName, []byte(selfTestFixture))
+	if err != nil {
+		log.Errorf("Unable to load self-test fixture: %v", err)
+		return exitCodeFailure
+	}
+
+	nodeInfo, err := client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		log.Errorf("Unable to get node info: %v", err)
+		return exitCodeFailure
+	}
+
+	checker, err := chanleak.NewChecker(&chanleak.Config{
+		Client:    client,
+		GraphMode: chanleak.GraphModeDescribe,
+	})
+	if err != nil {
+		log.Errorf("unable to create checker: %v", err)
+		return exitCodeFailure
+	}
+
+	report, _, exitCode := scanNode(
+		ctx, interrupted, checker, &scanMetrics{}, nodeInfo, nil,
+	)
+	if err := verifySelfTest(report, exitCode); err != nil {
+		log.Errorf("Self-test failed: %v", err)
+		fmt.Println("FAIL")
+		return exitCodeFailure
+	}
+
+	fmt.Println("PASS")
+	return exitCodeClean
+}
+
+// verifySelfTest returns an error if the report and exit code of the scan of
+// the self-test fixture aren't the expected ones.
+func verifySelfTest(report *jsonReport, exitCode int) error {
+	if report == nil {
+		return fmt.Errorf("scan failed with exit code %v", exitCode)
+	}
+
+	if exitCode != exitCodeInvalidChannels {
+		return fmt.Errorf("expected exit code %v, got %v",
+			exitCodeInvalidChannels, exitCode)
+	}
+
+	if len(report.InvalidChannels) != 1 {
+		return fmt.Errorf("expected exactly 1 invalid channel, found %v",
+			len(report.InvalidChannels))
+	}
+
+	channel := report.InvalidChannels[0]
+	switch {
+	case channel.ShortChanID != selfTestFakeChannel:
+		return fmt.Errorf("expected channel %v to be invalid, found %v",
+			selfTestFakeChannel, channel.ShortChanID)
+
+	case channel.RemotePubkey != selfTestFakePeer:
+		return fmt.Errorf("expected remote peer %v, found %v",
+			selfTestFakePeer, channel.RemotePubkey)
+	}
+
+	if report.LossError != "" {
+		return fmt.Errorf("unable to quantify the loss: %v",
+			report.LossError)
+	}
+
+	if report.TotalLoss != selfTestExpectedLoss {
+		return fmt.Errorf("expected a loss of %v sats, computed %v",
+			selfTestExpectedLoss, report.TotalLoss)
+	}
+
+	if report.lossIncomplete() {
+		return fmt.Errorf("the forwards don't reconcile with the " +
+			"balance of the fake channel")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+)
+
+// TestSelfTest makes sure the self-test passes, finding exactly the fake
+// channel of its fixture with the expected loss.
+func TestSelfTest(t *testing.T) {
+	_, restore := captureLogs()
+	defer restore()
+
+	exitCode := runSelfTest(context.Background(), nil)
+	if exitCode != exitCodeClean {
+		t.Fatalf("expected the self-test to pass with exit code %v, "+
+			"got %v", exitCodeClean, exitCode)
+	}
+}
+
+// TestVerifySelfTest makes sure the self-test fails if the scan of its fixture
+// computes anything other than the expected loss.
+func TestVerifySelfTest(t *testing.T) {
+	report := &jsonReport{}
+	report.TotalLoss = selfTestExpectedLoss
+	report.InvalidChannels = []chanleak.ReportChannel{{
+		ShortChanID:  selfTestFakeChannel,
+		RemotePubkey: selfTestFakePeer,
+	}}
+	if err := verifySelfTest(report, exitCodeInvalidChannels); err != nil {
+		t.Fatalf("expected the self-test to pass: %v", err)
+	}
+
+	report.TotalLoss = selfTestExpectedLoss - 1
+	if err := verifySelfTest(report, exitCodeInvalidChannels); err == nil {
+		t.Fatalf("expected the self-test to fail for a wrong loss")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// serveShutdownTimeout is the maximum time we'll wait for in-flight requests
+// to complete once the API server is shutting down.
+const serveShutdownTimeout = 5 * time.Second
+
+// apiServer serves an HTTP JSON API that scans the target node on demand.
+// Only a single scan runs at a time, any request for a scan made while one is
+// in progress is rejected rather than queued.
+type apiServer struct {
+	rootCtx     context.Context
+	interrupted <-chan struct{}
+	checker     *chanleak.Checker
+	metrics     *scanMetrics
+	nodeInfo    *lnrpc.GetInfoResponse
+
+	// token, if set, is the bearer token every request for a scan must
+	// carry.
+	token string
+
+	mu       sync.Mutex
+	scanning bool
+}
+
+// apiError is the JSON body of a failed request.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// runServe serves the HTTP JSON API until the root context is canceled, and
+// returns the exit code the process should terminate with.
+func runServe(rootCtx context.Context, interrupted <-chan struct{},
+	checker *chanleak.Checker, metrics *scanMetrics,
+	nodeInfo *lnrpc.GetInfoResponse) int {
+
+	s := &apiServer{
+		rootCtx:     rootCtx,
+		interrupted: interrupted,
+		checker:     checker,
+		metrics:     metrics,
+		nodeInfo:    nodeInfo,
+		token:       *apiToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scan", s.handleScan)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+
+	server := &http.Server{
+		Addr:    *serveAddr,
+		Handler: mux,
+	}
+
+	// Once we're interrupted, we'll give in-flight requests a moment to
+	// complete before shutting down. Any scan in progress is aborted by
+	// the canceled root context anyway.
+	go func() {
+		<-rootCtx.Done()
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(), serveShutdownTimeout,
+		)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warnf("Unable to shut down API server: %v", err)
+		}
+	}()
+
+	if s.token == "" {
+		log.Warnf("No -api-token set, anyone able to reach %v can "+
+			"trigger scans", *serveAddr)
+	}
+	log.Infof("Serving scans on %v/scan", *serveAddr)
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("API server failed: %v", err)
+		return exitCodeFailure
+	}
+
+	log.Infof("API server shutting down")
+	return exitCodeClean
+}
+
+// handleHealthz reports that the server is alive. It doesn't require the API
+// token, so it can be used by liveness probes.
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(
+			w, http.StatusMethodNotAllowed, "method not allowed",
+		)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleScan carries out a fresh scan of the target node and responds with
+// its JSON report.
+func (s *apiServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(
+			w, http.StatusMethodNotAllowed, "method not allowed",
+		)
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "invalid api token")
+		return
+	}
+
+	// Scans are expensive for the node, so we'll refuse to stack them up
+	// if a dashboard polls faster than a scan completes.
+	if !s.acquire() {
+		writeAPIError(
+			w, http.StatusConflict, "scan already in progress",
+		)
+		return
+	}
+	defer s.release()
+
+	// The scan is aborted if the client goes away, as there'd be no one
+	// left to report its results to.
+	ctx, cancel := scanContext(s.rootCtx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report, summary, exitCode := scanNode(
+		ctx, s.interrupted, s.checker, s.metrics, s.nodeInfo, nil,
+	)
+	if report == nil {
+		status := http.StatusInternalServerError
+		if exitCode == exitCodeTimeout {
+			status = http.StatusGatewayTimeout
+		}
+		writeAPIError(
+			w, status, "scan failed, see the logs for details",
+		)
+		return
+	}
+
+	if err := emitReport(report, summary); err != nil {
+		log.Errorf("%v", err)
+	}
+
+	writeAPIResponse(w, http.StatusOK, report)
+}
+
+// authorized returns true if the request carries the configured API token, or
+// if no token is required.
+func (s *apiServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	token := strings.TrimPrefix(auth, prefix)
+
+	match := subtle.ConstantTimeCompare([]byte(token), []byte(s.token))
+
+	return match == 1
+}
+
+// acquire marks a scan as in progress, and returns false if one already is.
+func (s *apiServer) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.scanning {
+		return false
+	}
+	s.scanning = true
+
+	return true
+}
+
+// release marks the scan in progress as done.
+func (s *apiServer) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scanning = false
+}
+
+// writeAPIResponse writes the given value as the JSON body of a response with
+// the given status code.
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Debugf("Unable to write API response: %v", err)
+	}
+}
+
+// writeAPIError writes a JSON error response with the given status code.
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(w, status, &apiError{Error: msg})
+}
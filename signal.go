@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interceptSignals calls cancel once the process receives SIGINT or SIGTERM,
+// which aborts any outstanding RPCs. The returned channel is closed once such
+// a signal has been received. After the first signal, the default signal
+// handling is restored, so a second Ctrl-C force quits the program.
+func interceptSignals(ctx context.Context, cancel func()) <-chan struct{} {
+	interrupted := make(chan struct{})
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+		select {
+		case sig := <-sigChan:
+			log.Infof("Received %v, aborting scan (press Ctrl-C "+
+				"again to force quit)...", sig)
+
+			close(interrupted)
+			cancel()
+
+		case <-ctx.Done():
+		}
+	}()
+
+	return interrupted
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// snapshotFile is the on-disk format of a snapshot of all RPC responses a scan
+// relied on. Each response is stored in the JSON format of lnd's REST
+// interface, so snapshots can be inspected and edited by hand.
+type snapshotFile struct {
+	// ToolVersion is the version and build information of the
+	// chanleakcheck binary that recorded the snapshot.
+	ToolVersion string `json:"toolVersion"`
+
+	// CreatedAt is the time the snapshot was written at.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// GetInfo is the node's GetInfo response.
+	GetInfo json.RawMessage `json:"getInfo"`
+
+	// ListChannels is the node's ListChannels response.
+	ListChannels json.RawMessage `json:"listChannels,omitempty"`
+
+	// ClosedChannels is the node's ClosedChannels response.
+	ClosedChannels json.RawMessage `json:"closedChannels,omitempty"`
+
+	// DescribeGraph is the node's DescribeGraph response.
+	DescribeGraph json.RawMessage `json:"describeGraph,omitempty"`
+
+	// ChanInfo maps the compact channel ID of each channel looked up with
+	// GetChanInfo to the response. Channels that weren't found are
+	// omitted.
+	ChanInfo map[string]json.RawMessage `json:"chanInfo,omitempty"`
+
+	// NodeInfo maps the public key of each node looked up with
+	// GetNodeInfo to the response. Nodes that weren't found are omitted.
+	NodeInfo map[string]json.RawMessage `json:"nodeInfo,omitempty"`
+
+	// ForwardingEvents is the node's forwarding history, in the order lnd
+	// returned it.
+	ForwardingEvents []json.RawMessage `json:"forwardingEvents,omitempty"`
+}
+
+// marshalProto returns the JSON encoding of the given RPC message.
+func marshalProto(msg proto.Message) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	marshaler := jsonpb.Marshaler{OrigName: true}
+	if err := marshaler.Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalProto decodes the JSON encoding of an RPC message.
+func unmarshalProto(raw json.RawMessage, msg proto.Message) error {
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	return unmarshaler.Unmarshal(bytes.NewReader(raw), msg)
+}
+
+// recordingClient wraps a node client, recording every response so they can
+// be written to a snapshot once the scan completes.
+type recordingClient struct {
+	nodeClient
+
+	mu             sync.Mutex
+	getInfo        *lnrpc.GetInfoResponse
+	listChannels   *lnrpc.ListChannelsResponse
+	closedChannels *lnrpc.ClosedChannelsResponse
+	describeGraph  *lnrpc.ChannelGraph
+	chanInfo       map[uint64]*lnrpc.ChannelEdge
+	nodeInfo       map[string]*lnrpc.NodeInfo
+
+	// fwdEvents maps the index of each forwarding event within the
+	// forwarding history to the event, so paging through the history
+	// more than once doesn't record an event twice.
+	fwdEvents map[uint32]*lnrpc.ForwardingEvent
+}
+
+// newRecordingClient returns a client recording the responses of the given
+// client. The node info obtained while connecting to the node is recorded
+// right away.
+func newRecordingClient(client nodeClient,
+	nodeInfo *lnrpc.GetInfoResponse) *recordingClient {
+
+	return &recordingClient{
+		nodeClient: client,
+		getInfo:    nodeInfo,
+		chanInfo:   make(map[uint64]*lnrpc.ChannelEdge),
+		nodeInfo:   make(map[string]*lnrpc.NodeInfo),
+		fwdEvents:  make(map[uint32]*lnrpc.ForwardingEvent),
+	}
+}
+
+// GetInfo returns general information about the node.
+func (r *recordingClient) GetInfo(ctx context.Context,
+	in *lnrpc.GetInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+
+	resp, err := r.nodeClient.GetInfo(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.getInfo = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// ListChannels returns the set of currently open channels of the node.
+func (r *recordingClient) ListChannels(ctx context.Context,
+	in *lnrpc.ListChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	resp, err := r.nodeClient.ListChannels(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.listChannels = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+func (r *recordingClient) ClosedChannels(ctx context.Context,
+	in *lnrpc.ClosedChannelsRequest,
+	opts ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	resp, err := r.nodeClient.ClosedChannels(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.closedChannels = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (r *recordingClient) GetChanInfo(ctx context.Context,
+	in *lnrpc.ChanInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	resp, err := r.nodeClient.GetChanInfo(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.chanInfo[in.ChanId] = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// DescribeGraph returns the full channel graph of the node.
+func (r *recordingClient) DescribeGraph(ctx context.Context,
+	in *lnrpc.ChannelGraphRequest,
+	opts ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	resp, err := r.nodeClient.DescribeGraph(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.describeGraph = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (r *recordingClient) GetNodeInfo(ctx context.Context,
+	in *lnrpc.NodeInfoRequest,
+	opts ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	resp, err := r.nodeClient.GetNodeInfo(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.nodeInfo[in.PubKey] = resp
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// ForwardingHistory returns the set of HTLCs forwarded by the node.
+func (r *recordingClient) ForwardingHistory(ctx context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	opts ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	resp, err := r.nodeClient.ForwardingHistory(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	for i, event := range resp.ForwardingEvents {
+		r.fwdEvents[in.IndexOffset+uint32(i)] = event
+	}
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// writeSnapshot writes all recorded responses to a snapshot at the given path.
+func (r *recordingClient) writeSnapshot(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := &snapshotFile{
+		ToolVersion: versionString(),
+		CreatedAt:   time.Now().UTC(),
+		ChanInfo:    make(map[string]json.RawMessage),
+		NodeInfo:    make(map[string]json.RawMessage),
+	}
+
+	var err error
+	marshal := func(msg proto.Message) json.RawMessage {
+		if err != nil || msg == nil {
+			return nil
+		}
+
+		var raw json.RawMessage
+		raw, err = marshalProto(msg)
+		return raw
+	}
+
+	snapshot.GetInfo = marshal(r.getInfo)
+	if r.listChannels != nil {
+		snapshot.ListChannels = marshal(r.listChannels)
+	}
+	if r.closedChannels != nil {
+		snapshot.ClosedChannels = marshal(r.closedChannels)
+	}
+	if r.describeGraph != nil {
+		snapshot.DescribeGraph = marshal(r.describeGraph)
+	}
+	for chanID, edge := range r.chanInfo {
+		key := strconv.FormatUint(chanID, 10)
+		snapshot.ChanInfo[key] = marshal(edge)
+	}
+	for pubKey, info := range r.nodeInfo {
+		snapshot.NodeInfo[pubKey] = marshal(info)
+	}
+
+	indices := make([]uint32, 0, len(r.fwdEvents))
+	for index := range r.fwdEvents {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i] < indices[j]
+	})
+	for _, index := range indices {
+		snapshot.ForwardingEvents = append(
+			snapshot.ForwardingEvents, marshal(r.fwdEvents[index]),
+		)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to encode snapshot: %v", err)
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(snapshot)
+	})
+}
+
+// replayClient serves all RPCs of a scan from a snapshot rather than a live
+// node. Lookups of channels and nodes that aren't part of the snapshot fail
+// with the same NotFound error lnd would return.
+type replayClient struct {
+	getInfo        *lnrpc.GetInfoResponse
+	listChannels   *lnrpc.ListChannelsResponse
+	closedChannels *lnrpc.ClosedChannelsResponse
+	describeGraph  *lnrpc.ChannelGraph
+	edges          map[uint64]*lnrpc.ChannelEdge
+	nodeInfo       map[string]*lnrpc.NodeInfo
+	fwdEvents      []*lnrpc.ForwardingEvent
+}
+
+// A compile-time check to ensure replayClient satisfies nodeClient.
+var _ nodeClient = (*replayClient)(nil)
+
+// loadReplayClient returns a client serving the snapshot at the given path.
+func loadReplayClient(path string) (*replayClient, error) {
+	snapshotBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read snapshot: %v", err)
+	}
+
+	return decodeReplayClient(path, snapshotBytes)
+}
+
+// decodeReplayClient returns a client serving the given encoded snapshot,
+// which is referred to by the given name in errors and logs.
+func decodeReplayClient(name string,
+	snapshotBytes []byte) (*replayClient, error) {
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(snapshotBytes, &snapshot); err != nil {
+		return nil, fmt.Errorf("unable to decode snapshot %v: %v",
+			name, err)
+	}
+
+	if snapshot.GetInfo == nil {
+		return nil, fmt.Errorf("snapshot %v has no node info", name)
+	}
+
+	client := &replayClient{
+		getInfo:        &lnrpc.GetInfoResponse{},
+		listChannels:   &lnrpc.ListChannelsResponse{},
+		closedChannels: &lnrpc.ClosedChannelsResponse{},
+		edges:          make(map[uint64]*lnrpc.ChannelEdge),
+		nodeInfo:       make(map[string]*lnrpc.NodeInfo),
+	}
+
+	// We'll decode each of the recorded responses. Those that weren't
+	// recorded, as the scan didn't need them, are served as empty
+	// responses.
+	decode := func(field string, raw json.RawMessage,
+		msg proto.Message) error {
+
+		if raw == nil {
+			return nil
+		}
+		if err := unmarshalProto(raw, msg); err != nil {
+			return fmt.Errorf("unable to decode %v of snapshot "+
+				"%v: %v", field, name, err)
+		}
+
+		return nil
+	}
+
+	if err := decode("getInfo", snapshot.GetInfo, client.getInfo); err != nil {
+		return nil, err
+	}
+	err := decode("listChannels", snapshot.ListChannels, client.listChannels)
+	if err != nil {
+		return nil, err
+	}
+	err = decode(
+		"closedChannels", snapshot.ClosedChannels,
+		client.closedChannels,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The channel graph may have been recorded in full, or as individual
+	// lookups, depending on the graph mode of the recorded scan. To be
+	// able to replay either mode, we'll index the edges of both.
+	if snapshot.DescribeGraph != nil {
+		client.describeGraph = &lnrpc.ChannelGraph{}
+		err := decode(
+			"describeGraph", snapshot.DescribeGraph,
+			client.describeGraph,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, edge := range client.describeGraph.Edges {
+			client.edges[edge.ChannelId] = edge
+		}
+	}
+	for key, raw := range snapshot.ChanInfo {
+		edge := &lnrpc.ChannelEdge{}
+		if err := decode("chanInfo", raw, edge); err != nil {
+			return nil, err
+		}
+
+		chanID, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel ID %q in "+
+				"snapshot %v", key, name)
+		}
+		client.edges[chanID] = edge
+	}
+
+	for pubKey, raw := range snapshot.NodeInfo {
+		info := &lnrpc.NodeInfo{}
+		if err := decode("nodeInfo", raw, info); err != nil {
+			return nil, err
+		}
+		client.nodeInfo[pubKey] = info
+	}
+
+	for _, raw := range snapshot.ForwardingEvents {
+		event := &lnrpc.ForwardingEvent{}
+		if err := decode("forwardingEvents", raw, event); err != nil {
+			return nil, err
+		}
+		client.fwdEvents = append(client.fwdEvents, event)
+	}
+
+	log.Infof("Replaying snapshot %v recorded at %v by chanleakcheck %v",
+		name, snapshot.CreatedAt.Format(time.RFC3339),
+		snapshot.ToolVersion)
+
+	return client, nil
+}
+
+// GetInfo returns general information about the node.
+func (r *replayClient) GetInfo(_ context.Context, _ *lnrpc.GetInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+
+	return r.getInfo, nil
+}
+
+// ListChannels returns the set of open channels of the node.
+func (r *replayClient) ListChannels(_ context.Context,
+	_ *lnrpc.ListChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+
+	return r.listChannels, nil
+}
+
+// ClosedChannels returns the set of channels the node has closed in the past.
+func (r *replayClient) ClosedChannels(_ context.Context,
+	_ *lnrpc.ClosedChannelsRequest,
+	_ ...grpc.CallOption) (*lnrpc.ClosedChannelsResponse, error) {
+
+	return r.closedChannels, nil
+}
+
+// GetChanInfo returns the channel graph's view of a single channel.
+func (r *replayClient) GetChanInfo(_ context.Context,
+	in *lnrpc.ChanInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelEdge, error) {
+
+	edge, ok := r.edges[in.ChanId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "edge not found")
+	}
+
+	return edge, nil
+}
+
+// DescribeGraph returns the full channel graph of the node. If the snapshot
+// only recorded individual channel lookups, the graph is made up of those.
+func (r *replayClient) DescribeGraph(_ context.Context,
+	_ *lnrpc.ChannelGraphRequest,
+	_ ...grpc.CallOption) (*lnrpc.ChannelGraph, error) {
+
+	if r.describeGraph != nil {
+		return r.describeGraph, nil
+	}
+
+	graph := &lnrpc.ChannelGraph{}
+	for _, edge := range r.edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+
+	return graph, nil
+}
+
+// GetNodeInfo returns the channel graph's view of a single node.
+func (r *replayClient) GetNodeInfo(_ context.Context,
+	in *lnrpc.NodeInfoRequest,
+	_ ...grpc.CallOption) (*lnrpc.NodeInfo, error) {
+
+	info, ok := r.nodeInfo[in.PubKey]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "node not found")
+	}
+
+	return info, nil
+}
+
+// ForwardingHistory returns the recorded forwards within the requested time
+// range, paginated the same way lnd does.
+func (r *replayClient) ForwardingHistory(_ context.Context,
+	in *lnrpc.ForwardingHistoryRequest,
+	_ ...grpc.CallOption) (*lnrpc.ForwardingHistoryResponse, error) {
+
+	var events []*lnrpc.ForwardingEvent
+	for _, event := range r.fwdEvents {
+		if event.Timestamp < in.StartTime ||
+			event.Timestamp > in.EndTime {
+
+			continue
+		}
+		events = append(events, event)
+	}
+
+	resp := &lnrpc.ForwardingHistoryResponse{
+		LastOffsetIndex: in.IndexOffset,
+	}
+	if int(in.IndexOffset) >= len(events) {
+		return resp, nil
+	}
+
+	events = events[in.IndexOffset:]
+	if in.NumMaxEvents > 0 && len(events) > int(in.NumMaxEvents) {
+		events = events[:in.NumMaxEvents]
+	}
+
+	resp.ForwardingEvents = events
+	resp.LastOffsetIndex = in.IndexOffset + uint32(len(events))
+
+	return resp, nil
+}
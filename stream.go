@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// eventScanStarted is emitted once a scan of a node begins.
+	eventScanStarted = "scan_started"
+
+	// eventChannelChecked is emitted each time a channel was verified.
+	eventChannelChecked = "channel_checked"
+
+	// eventInvalidChannel is emitted for each confirmed invalid channel
+	// once the scan of the node's channels completes, with the details of
+	// its remote peer resolved.
+	eventInvalidChannel = "invalid_channel"
+
+	// eventScanCompleted is emitted once all channels were verified.
+	eventScanCompleted = "scan_completed"
+
+	// eventLossComputed is emitted once the loss due to the invalid
+	// channels has been quantified.
+	eventLossComputed = "loss_computed"
+)
+
+const (
+	// channelStatusValid marks a channel that was verified successfully.
+	channelStatusValid = "valid"
+
+	// channelStatusInvalid marks a channel confirmed to be invalid.
+	channelStatusInvalid = "invalid"
+
+	// channelStatusNotInGraph marks a channel that couldn't be found
+	// within the channel graph.
+	channelStatusNotInGraph = "not_in_graph"
+
+	// channelStatusCheckFailed marks a channel whose lookup within the
+	// channel graph kept failing, so it couldn't be checked.
+	channelStatusCheckFailed = "check_failed"
+)
+
+// streamEvent is a single event of the NDJSON stream. Only the fields
+// relevant to the event's type are set, the type itself tells them apart.
+type streamEvent struct {
+	// Type is the kind of event, one of the event* constants.
+	Type string `json:"type"`
+
+	// Time is the time the event was emitted at.
+	Time time.Time `json:"time"`
+
+	// NodePubkey is the public key of the scanned node. This is set for
+	// eventScanStarted.
+	NodePubkey string `json:"nodePubkey,omitempty"`
+
+	// ChanID is the compact uint64 form of the short channel ID of the
+	// checked channel. This is set for eventChannelChecked.
+	ChanID uint64 `json:"chanId,omitempty"`
+
+	// ShortChanID is the block:tx:output form of the short channel ID of
+	// the checked channel. This is set for eventChannelChecked.
+	ShortChanID string `json:"shortChanId,omitempty"`
+
+	// Status is the outcome of verifying the channel, one of the
+	// channelStatus* constants. This is set for eventChannelChecked.
+	Status string `json:"status,omitempty"`
+
+	// Channel holds the details of an invalid channel or a channel that
+	// couldn't be found within the graph. This is set for
+	// eventChannelChecked if the channel wasn't valid, and for
+	// eventInvalidChannel.
+	Channel *chanleak.ReportChannel `json:"channel,omitempty"`
+
+	// NumChecked is the number of channels that were verified. This is
+	// set for eventScanCompleted.
+	NumChecked *int `json:"numChecked,omitempty"`
+
+	// NumInvalid is the number of invalid channels found. This is set for
+	// eventScanCompleted.
+	NumInvalid *int `json:"numInvalid,omitempty"`
+
+	// NumNotInGraph is the number of channels that couldn't be found
+	// within the channel graph. This is set for eventScanCompleted.
+	NumNotInGraph *int `json:"numNotInGraph,omitempty"`
+
+	// ChannelLosses is the per-channel breakdown of the amount lost. This
+	// is set for eventLossComputed.
+	ChannelLosses []chanleak.ReportChannelLoss `json:"channelLosses,omitempty"`
+
+	// TotalLoss is the total amount lost in satoshis. This is set for
+	// eventLossComputed.
+	TotalLoss *int64 `json:"totalLoss,omitempty"`
+}
+
+// eventStream writes the events of a scan to a writer as newline-delimited
+// JSON, one self-contained object per line. A nil stream discards all events,
+// so callers don't need to check whether streaming was requested.
+type eventStream struct {
+	enc *json.Encoder
+}
+
+// events is the stream events are emitted to if -json-stream is set.
+var events *eventStream
+
+// newEventStream returns a stream writing events to the given writer.
+func newEventStream(w io.Writer) *eventStream {
+	return &eventStream{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// emit writes a single event to the stream. Failing to write an event
+// doesn't affect the scan, so errors are only logged.
+func (s *eventStream) emit(event *streamEvent) {
+	if s == nil {
+		return
+	}
+
+	event.Time = time.Now().UTC()
+	if err := s.enc.Encode(event); err != nil {
+		log.Errorf("Unable to write %v event: %v", event.Type, err)
+	}
+}
+
+// scanStarted emits the event marking the start of a scan of the given node.
+func (s *eventStream) scanStarted(nodePubkey string) {
+	s.emit(&streamEvent{
+		Type:       eventScanStarted,
+		NodePubkey: nodePubkey,
+	})
+}
+
+// channelChecked emits the event for a single verified channel. It matches
+// the signature of chanleak.Config.ChannelChecked.
+func (s *eventStream) channelChecked(cid lnwire.ShortChannelID,
+	channel *chanleak.InvalidChannel) {
+
+	event := &streamEvent{
+		Type:        eventChannelChecked,
+		ChanID:      cid.ToUint64(),
+		ShortChanID: cid.String(),
+		Status:      channelStatusValid,
+	}
+
+	if channel != nil {
+		event.Status = channelStatusInvalid
+
+		jsonChannel := newJSONInvalidChannel(*channel)
+		switch {
+		case channel.CheckFailed:
+			event.Status = channelStatusCheckFailed
+			jsonChannel.LookupError = channel.LookupErr.Error()
+
+		case channel.LookupErr != nil:
+			event.Status = channelStatusNotInGraph
+		}
+
+		event.Channel = &jsonChannel
+	}
+
+	s.emit(event)
+}
+
+// invalidChannel emits the event for a confirmed invalid channel.
+func (s *eventStream) invalidChannel(channel chanleak.InvalidChannel) {
+	jsonChannel := newJSONInvalidChannel(channel)
+	s.emit(&streamEvent{
+		Type:    eventInvalidChannel,
+		Channel: &jsonChannel,
+	})
+}
+
+// scanCompleted emits the event marking the completion of a scan.
+func (s *eventStream) scanCompleted(result *chanleak.ScanResult) {
+	numInvalid := len(result.InvalidChannels)
+	numNotInGraph := len(result.NotInGraph)
+	s.emit(&streamEvent{
+		Type:          eventScanCompleted,
+		NumChecked:    &result.NumChecked,
+		NumInvalid:    &numInvalid,
+		NumNotInGraph: &numNotInGraph,
+	})
+}
+
+// chanleak.LossComputed emits the event carrying the quantified loss.
+func (s *eventStream) lossComputed(report *jsonReport) {
+	totalLoss := report.TotalLoss
+	s.emit(&streamEvent{
+		Type:          eventLossComputed,
+		ChannelLosses: report.ChannelLosses,
+		TotalLoss:     &totalLoss,
+	})
+}
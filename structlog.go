@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btclog"
+)
+
+const (
+	// logFormatText is the default log format: human readable lines as
+	// written by btclog.
+	logFormatText = "text"
+
+	// logFormatLogfmt writes each log line as a set of key=value pairs.
+	logFormatLogfmt = "logfmt"
+
+	// logFormatJSON writes each log line as a single JSON object.
+	logFormatJSON = "json"
+
+	// structuredTimeLayout is the ISO8601 layout of the timestamp of
+	// each structured log line.
+	structuredTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// structuredLevelNames maps each log level to the name written to the level
+// field of structured log lines.
+var structuredLevelNames = map[btclog.Level]string{
+	btclog.LevelTrace:    "trace",
+	btclog.LevelDebug:    "debug",
+	btclog.LevelInfo:     "info",
+	btclog.LevelWarn:     "warn",
+	btclog.LevelError:    "error",
+	btclog.LevelCritical: "critical",
+}
+
+// structuredBackend writes the log lines of all its loggers to a single
+// writer in a structured format, such as logfmt or JSON, so they can be
+// ingested by log aggregators.
+type structuredBackend struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// newStructuredBackend returns a backend writing log lines in the given
+// format, which must be either logFormatLogfmt or logFormatJSON.
+func newStructuredBackend(w io.Writer, format string) *structuredBackend {
+	return &structuredBackend{
+		w:      w,
+		format: format,
+	}
+}
+
+// logger returns a new logger for the given subsystem. Like btclog's loggers,
+// it starts out at the info level.
+func (b *structuredBackend) logger(subsystem string) btclog.Logger {
+	return &structuredLogger{
+		backend:   b,
+		subsystem: subsystem,
+		level:     uint32(btclog.LevelInfo),
+	}
+}
+
+// write writes a single log line.
+func (b *structuredBackend) write(level btclog.Level, subsystem,
+	msg string) {
+
+	timestamp := time.Now().Format(structuredTimeLayout)
+	levelName := structuredLevelNames[level]
+
+	var line string
+	switch b.format {
+	case logFormatJSON:
+		lineBytes, err := json.Marshal(struct {
+			Time      string `json:"time"`
+			Level     string `json:"level"`
+			Subsystem string `json:"subsystem"`
+			Msg       string `json:"msg"`
+		}{timestamp, levelName, subsystem, msg})
+		if err != nil {
+			return
+		}
+		line = string(lineBytes)
+
+	default:
+		line = fmt.Sprintf("time=%v level=%v subsystem=%v msg=%v",
+			timestamp, levelName, subsystem, logfmtValue(msg))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintln(b.w, line)
+}
+
+// logfmtValue returns the given value as it's written in a logfmt line, which
+// is quoted if it's empty, or contains any spaces, equal signs or characters
+// that need to be escaped.
+func logfmtValue(value string) string {
+	quoted := strconv.Quote(value)
+	if value == "" || strings.ContainsAny(value, " =") ||
+		quoted[1:len(quoted)-1] != value {
+
+		return quoted
+	}
+
+	return value
+}
+
+// structuredLogger is a btclog.Logger writing to a structuredBackend.
+type structuredLogger struct {
+	backend   *structuredBackend
+	subsystem string
+
+	// level is the current level of the logger, accessed atomically.
+	level uint32
+}
+
+// A compile-time check to ensure structuredLogger satisfies btclog.Logger.
+var _ btclog.Logger = (*structuredLogger)(nil)
+
+// logf writes a formatted message at the given level, if it's enabled.
+func (l *structuredLogger) logf(level btclog.Level, format string,
+	params ...interface{}) {
+
+	if level < l.Level() {
+		return
+	}
+
+	l.backend.write(level, l.subsystem, fmt.Sprintf(format, params...))
+}
+
+// log writes the given values at the given level, if it's enabled.
+func (l *structuredLogger) log(level btclog.Level, v ...interface{}) {
+	if level < l.Level() {
+		return
+	}
+
+	l.backend.write(level, l.subsystem, fmt.Sprint(v...))
+}
+
+// Tracef formats a message and writes it at the trace level.
+func (l *structuredLogger) Tracef(format string, params ...interface{}) {
+	l.logf(btclog.LevelTrace, format, params...)
+}
+
+// Debugf formats a message and writes it at the debug level.
+func (l *structuredLogger) Debugf(format string, params ...interface{}) {
+	l.logf(btclog.LevelDebug, format, params...)
+}
+
+// Infof formats a message and writes it at the info level.
+func (l *structuredLogger) Infof(format string, params ...interface{}) {
+	l.logf(btclog.LevelInfo, format, params...)
+}
+
+// Warnf formats a message and writes it at the warn level.
+func (l *structuredLogger) Warnf(format string, params ...interface{}) {
+	l.logf(btclog.LevelWarn, format, params...)
+}
+
+// Errorf formats a message and writes it at the error level.
+func (l *structuredLogger) Errorf(format string, params ...interface{}) {
+	l.logf(btclog.LevelError, format, params...)
+}
+
+// Criticalf formats a message and writes it at the critical level.
+func (l *structuredLogger) Criticalf(format string, params ...interface{}) {
+	l.logf(btclog.LevelCritical, format, params...)
+}
+
+// Trace writes the given values at the trace level.
+func (l *structuredLogger) Trace(v ...interface{}) {
+	l.log(btclog.LevelTrace, v...)
+}
+
+// Debug writes the given values at the debug level.
+func (l *structuredLogger) Debug(v ...interface{}) {
+	l.log(btclog.LevelDebug, v...)
+}
+
+// Info writes the given values at the info level.
+func (l *structuredLogger) Info(v ...interface{}) {
+	l.log(btclog.LevelInfo, v...)
+}
+
+// Warn writes the given values at the warn level.
+func (l *structuredLogger) Warn(v ...interface{}) {
+	l.log(btclog.LevelWarn, v...)
+}
+
+// Error writes the given values at the error level.
+func (l *structuredLogger) Error(v ...interface{}) {
+	l.log(btclog.LevelError, v...)
+}
+
+// Critical writes the given values at the critical level.
+func (l *structuredLogger) Critical(v ...interface{}) {
+	l.log(btclog.LevelCritical, v...)
+}
+
+// Level returns the current level of the logger.
+func (l *structuredLogger) Level() btclog.Level {
+	return btclog.Level(atomic.LoadUint32(&l.level))
+}
+
+// SetLevel changes the level of the logger.
+func (l *structuredLogger) SetLevel(level btclog.Level) {
+	atomic.StoreUint32(&l.level, uint32(level))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/btcsuite/btcutil"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// useTable returns true if the invalid channels should be summarized in a
+// table, which is the case if -table is set or stderr is a terminal. In quiet
+// mode, the table is never shown, and neither is it with a structured log
+// format, as it would break up the structured lines on stderr.
+func useTable() bool {
+	if *quiet || *logFormat != logFormatText {
+		return false
+	}
+
+	return *table || terminal.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// writeTable writes a table of all invalid channels of the report to the given
+// writer, with the columns aligned and a final row holding the totals. If color
+// is true, all rows but the header are colored red. If -limit caps the number
+// of channels printed, only those with the largest loss are listed, while the
+// totals still cover all of them.
+func writeTable(w io.Writer, report *jsonReport, color bool) error {
+	losses := make(map[uint64]int64, len(report.ChannelLosses))
+	for _, channelLoss := range report.ChannelLosses {
+		losses[channelLoss.ChanID] = channelLoss.Loss
+	}
+
+	// The escape sequences would count towards the width of the cells
+	// they're part of, so we'll only color the rows once aligned.
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "CID\tREMOTE ALIAS\tINITIATOR\tSUBJECTIVE CAPACITY\t"+
+		"GRAPH CAPACITY\tNET LOSS\tSEVERITY\n")
+
+	var totalSubjective, totalGraph int64
+	for _, channel := range report.InvalidChannels {
+		totalSubjective += channel.SubjectiveCapacity
+		totalGraph += channel.GraphCapacity
+	}
+
+	channels := report.InvalidChannels
+	shown, numOmitted := displayedIndices(len(channels), func(i int) int64 {
+		return losses[channels[i].ChanID]
+	})
+	for _, i := range shown {
+		channel := channels[i]
+		alias := channel.PeerAlias
+		if alias == "" {
+			alias = "-"
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			channel.ShortChanID, alias, channel.Initiator,
+			btcutil.Amount(channel.SubjectiveCapacity),
+			btcutil.Amount(channel.GraphCapacity),
+			btcutil.Amount(losses[channel.ChanID]),
+			channel.Severity)
+	}
+
+	fmt.Fprintf(tw, "TOTAL\t\t\t%v\t%v\t%v\t\n",
+		btcutil.Amount(totalSubjective), btcutil.Amount(totalGraph),
+		btcutil.Amount(report.TotalLoss))
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write table: %v", err)
+	}
+
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, row := range rows {
+		// The totals row leaves the severity empty, which the
+		// tabwriter still pads.
+		row = strings.TrimRight(row, " ")
+		_, err := fmt.Fprintln(w, colorize(row, colorRed, color && i > 0))
+		if err != nil {
+			return fmt.Errorf("unable to write table: %v", err)
+		}
+	}
+
+	if numOmitted > 0 {
+		_, err := fmt.Fprintln(w, omittedFooter(numOmitted))
+		if err != nil {
+			return fmt.Errorf("unable to write table: %v", err)
+		}
+	}
+
+	return nil
+}
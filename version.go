@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// appVersion is the version of chanleakcheck.
+const appVersion = "0.1.0"
+
+var (
+	// commit is the git commit the binary was built from. It's injected
+	// at build time with -ldflags "-X main.commit=<commit>".
+	commit string
+
+	// buildDate is the date the binary was built at. It's injected at
+	// build time with -ldflags "-X main.buildDate=<date>".
+	buildDate string
+)
+
+// versionString returns a description of the build of chanleakcheck, so a
+// report can be traced back to the exact binary that produced it.
+func versionString() string {
+	buildCommit := commit
+	if buildCommit == "" {
+		buildCommit = "unknown"
+	}
+
+	date := buildDate
+	if date == "" {
+		date = "unknown"
+	}
+
+	return fmt.Sprintf("%v commit=%v go=%v built=%v", appVersion,
+		buildCommit, runtime.Version(), date)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// chanSet is a set of short channel IDs.
+type chanSet map[lnwire.ShortChannelID]struct{}
+
+// watcher repeatedly scans the target node, and only reports the results of a
+// scan if the set of invalid channels or the total loss changed since the
+// previous one, along with what changed.
+type watcher struct {
+	checker  *chanleak.Checker
+	metrics  *scanMetrics
+	nodeInfo *lnrpc.GetInfoResponse
+
+	// interval is the time between two scans, so a failed scan is
+	// retried after it.
+	interval time.Duration
+
+	// current is the set of invalid channels found by the latest
+	// successful scan.
+	current chanSet
+
+	// alerted is the set of all channels we've ever alerted on, so we
+	// don't repeat the details of a channel that keeps being flagged.
+	alerted chanSet
+
+	// totalLoss is the amount lost as last computed by a scan.
+	totalLoss btcutil.Amount
+}
+
+// runWatch scans the target node every interval until the root context is
+// canceled, and returns the exit code the process should terminate with.
+func runWatch(rootCtx context.Context, checker *chanleak.Checker,
+	metrics *scanMetrics, nodeInfo *lnrpc.GetInfoResponse) int {
+
+	w := newWatcher(checker, metrics, nodeInfo, *interval)
+
+	log.Infof("Watching for invalid channels every %v...", *interval)
+
+	for {
+		w.scan(rootCtx)
+
+		select {
+		case <-time.After(w.interval):
+
+		case <-rootCtx.Done():
+			log.Infof("Watcher shutting down")
+			return exitCodeClean
+		}
+	}
+}
+
+// newWatcher returns a watcher that scans the target node with the given
+// checker every interval.
+func newWatcher(checker *chanleak.Checker, metrics *scanMetrics,
+	nodeInfo *lnrpc.GetInfoResponse, interval time.Duration) *watcher {
+
+	return &watcher{
+		checker:  checker,
+		metrics:  metrics,
+		nodeInfo: nodeInfo,
+		interval: interval,
+		current:  make(chanSet),
+		alerted:  make(chanSet),
+	}
+}
+
+// scan carries out a single scan of the target node, and reports its results
+// if its findings changed.
+func (w *watcher) scan(rootCtx context.Context) {
+	ctx, cancel := scanContext(rootCtx)
+	defer cancel()
+
+	started := time.Now()
+	stats := newScanStats(w.checker, started)
+	events.scanStarted(w.nodeInfo.IdentityPubkey)
+	endCheck := stats.phase(phaseCheck)
+	scanResult, err := w.checker.CheckChannels(ctx)
+	endCheck()
+	if err != nil {
+		// If we're shutting down, there's no point in logging the
+		// aborted scan.
+		switch {
+		case rootCtx.Err() != nil:
+
+		case isMsgSizeErr(err):
+			log.Errorf("Scan failed, retrying in %v, consider "+
+				"raising the -maxmsgsize flag: %v", w.interval,
+				err)
+
+		default:
+			log.Errorf("Scan failed, retrying in %v: %v",
+				w.interval, err)
+		}
+		return
+	}
+
+	events.scanCompleted(scanResult)
+
+	invalidChannels := scanResult.InvalidChannels
+	latest := make(chanSet, len(invalidChannels))
+	for _, channel := range invalidChannels {
+		latest[channel.ChanID] = struct{}{}
+	}
+
+	// Every forward over an invalid channel adds to the loss, so we'll
+	// quantify it on each scan to notice it growing even if the set of
+	// invalid channels stayed the same. If that fails, we'll stick to the
+	// loss we last computed, as we don't know any better.
+	var (
+		lossReport chanleak.LossReport
+		lossErr    error
+	)
+	totalLoss := w.totalLoss
+	if len(invalidChannels) != 0 {
+		endLoss := stats.phase(phaseLoss)
+		lossReport, lossErr = w.checker.QuantifyLoss(
+			ctx, invalidChannels,
+		)
+		endLoss()
+		if lossErr != nil {
+			log.Warnf("Unable to quantify the loss due to the "+
+				"invalid channels: %v", lossErr)
+		} else {
+			totalLoss = lossReport.TotalLoss
+		}
+	} else {
+		totalLoss = 0
+	}
+
+	// If nothing changed since the last scan, then we'll stay quiet, and
+	// only refresh the metrics.
+	diff := w.diff(latest, totalLoss)
+	if diff.empty() {
+		w.metrics.update(
+			len(invalidChannels), len(scanResult.NotInGraph),
+			scanResult.NumChecked, w.totalLoss,
+		)
+		return
+	}
+
+	log.Warnf("Findings changed since the last scan: %v newly invalid, "+
+		"%v cleared, num invalid channels found: %v",
+		len(diff.newlyInvalid), len(diff.cleared), len(invalidChannels))
+
+	sortInvalidChannels(invalidChannels)
+	sortInvalidChannels(scanResult.NotInGraph)
+	sortInvalidChannels(scanResult.CheckFailed)
+	endPeers := stats.phase(phasePeers)
+	w.checker.ResolvePeers(ctx, invalidChannels)
+	endPeers()
+
+	report := newJSONReport()
+	report.Coverage = newJSONCoverage(scanResult)
+	report.WatchDiff = diff.report()
+	for _, channel := range scanResult.NotInGraph {
+		report.addNotInGraphChannel(channel)
+	}
+	for _, channel := range scanResult.CheckFailed {
+		report.addCheckFailedChannel(channel)
+	}
+	for _, channel := range invalidChannels {
+		report.addInvalidChannel(channel)
+		events.invalidChannel(channel)
+
+		// A channel that was already flagged by the previous scan
+		// doesn't tell the operator anything new. One that cleared in
+		// between had its details logged before, so we'll only note
+		// that it's back.
+		if _, ok := w.current[channel.ChanID]; ok {
+			continue
+		}
+		if _, ok := w.alerted[channel.ChanID]; ok {
+			log.Warnf("Channel %v is flagged as invalid again",
+				channel.ChanID)
+			continue
+		}
+
+		logInvalidChannel(channel)
+		w.alerted[channel.ChanID] = struct{}{}
+	}
+	report.addCollisions(scanResult.Collisions)
+	report.setOpenDates(w.nodeInfo)
+
+	for _, cid := range diff.cleared {
+		log.Warnf("Channel %v is no longer flagged as invalid", cid)
+	}
+
+	w.current = latest
+	w.totalLoss = totalLoss
+
+	if len(invalidChannels) != 0 {
+		if lossErr != nil {
+			report.setLossError(lossErr)
+		} else {
+			var rate *fiatRate
+			if len(lossReport.ChannelLosses) > 0 {
+				rate = obtainFiatRate(ctx)
+			}
+
+			losses := lossReport.ChannelLosses
+			forwards := lossReport.ChannelForwards
+			for _, chanID := range sortedLossChannels(losses) {
+				report.addChannelLoss(
+					chanID, losses[chanID],
+					forwards[chanID],
+				)
+			}
+			report.setSeverities()
+			report.applySortOrder()
+			report.setLossStats()
+			report.addPeerLosses(lossReport.PeerLosses)
+			closes := lossReport.ChannelCloses
+			for _, chanID := range sortedCloseChannels(closes) {
+				report.addChannelClose(chanID, closes[chanID])
+			}
+			report.addBalanceChecks(lossReport.BalanceChecks)
+			report.TotalLoss = int64(lossReport.TotalLoss)
+			report.setFiatRate(rate)
+			events.lossComputed(report)
+
+			if diff.lossChanged() {
+				log.Warnf("Amount lost: %v, previously %v",
+					formatLoss(diff.loss, rate),
+					formatLoss(diff.prevLoss, rate))
+			} else {
+				log.Warnf("Amount lost: %v",
+					formatLoss(diff.loss, rate))
+			}
+		}
+	}
+
+	notifyWebhook(ctx, w.nodeInfo, report)
+
+	w.metrics.update(
+		len(invalidChannels), len(scanResult.NotInGraph),
+		scanResult.NumChecked, w.totalLoss,
+	)
+
+	report.Stats = stats.report()
+	summary := newScanSummary(w.nodeInfo, started, scanResult)
+	if err := emitReport(report, summary); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// watchDiff is the change in the findings of a scan since the previous one.
+type watchDiff struct {
+	// newlyInvalid is the set of channels flagged as invalid that weren't
+	// flagged by the previous scan, ordered by short channel ID.
+	newlyInvalid []lnwire.ShortChannelID
+
+	// cleared is the set of channels flagged by the previous scan that no
+	// longer are, ordered by short channel ID.
+	cleared []lnwire.ShortChannelID
+
+	// prevLoss is the total loss as computed by the previous scan.
+	prevLoss btcutil.Amount
+
+	// loss is the total loss as computed by the latest scan.
+	loss btcutil.Amount
+}
+
+// diff returns how the given set of invalid channels and total loss differ
+// from those found by the previous scan.
+func (w *watcher) diff(latest chanSet, totalLoss btcutil.Amount) *watchDiff {
+	diff := &watchDiff{
+		prevLoss: w.totalLoss,
+		loss:     totalLoss,
+	}
+	for cid := range latest {
+		if _, ok := w.current[cid]; !ok {
+			diff.newlyInvalid = append(diff.newlyInvalid, cid)
+		}
+	}
+	for cid := range w.current {
+		if _, ok := latest[cid]; !ok {
+			diff.cleared = append(diff.cleared, cid)
+		}
+	}
+	sortChanIDs(diff.newlyInvalid)
+	sortChanIDs(diff.cleared)
+
+	return diff
+}
+
+// lossChanged returns true if the total loss changed since the previous scan.
+func (d *watchDiff) lossChanged() bool {
+	return d.loss != d.prevLoss
+}
+
+// empty returns true if nothing changed since the previous scan.
+func (d *watchDiff) empty() bool {
+	return len(d.newlyInvalid) == 0 && len(d.cleared) == 0 &&
+		!d.lossChanged()
+}
+
+// report returns the JSON representation of the diff.
+func (d *watchDiff) report() *chanleak.ReportWatchDiff {
+	jsonDiff := &chanleak.ReportWatchDiff{
+		NewlyInvalid:      make([]string, 0, len(d.newlyInvalid)),
+		Cleared:           make([]string, 0, len(d.cleared)),
+		PreviousTotalLoss: int64(d.prevLoss),
+		TotalLossChange:   int64(d.loss - d.prevLoss),
+	}
+	for _, cid := range d.newlyInvalid {
+		jsonDiff.NewlyInvalid = append(
+			jsonDiff.NewlyInvalid, cid.String(),
+		)
+	}
+	for _, cid := range d.cleared {
+		jsonDiff.Cleared = append(jsonDiff.Cleared, cid.String())
+	}
+
+	return jsonDiff
+}
+
+// sortChanIDs sorts the given short channel IDs in ascending order.
+func sortChanIDs(cids []lnwire.ShortChannelID) {
+	sort.Slice(cids, func(i, j int) bool {
+		return cids[i].ToUint64() < cids[j].ToUint64()
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lightninglabs/chanleakcheck/chanleak"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const (
+	// webhookTimeout is the maximum time we'll wait for the webhook to
+	// accept a notification.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookPayload is the JSON document POSTed to the webhook once invalid
+// channels are detected.
+type webhookPayload struct {
+	// NodePubkey is the public key of the node the invalid channels were
+	// found on.
+	NodePubkey string `json:"nodePubkey"`
+
+	// NodeAlias is the alias of the node the invalid channels were found
+	// on.
+	NodeAlias string `json:"nodeAlias"`
+
+	// InvalidChannels lists the block:tx:output form of the short channel
+	// ID of each invalid channel.
+	InvalidChannels []string `json:"invalidChannels"`
+
+	// TotalLoss is the total amount lost due to the invalid channels in
+	// satoshis.
+	TotalLoss int64 `json:"totalLoss"`
+
+	// LossStatus describes to what extent the loss could be quantified,
+	// as TotalLoss is zero if it couldn't be at all.
+	LossStatus chanleak.LossStatus `json:"lossStatus"`
+
+	// ToolVersion is the version and build information of the
+	// chanleakcheck binary that detected the channels.
+	ToolVersion string `json:"toolVersion"`
+}
+
+// notifyWebhook POSTs the invalid channels of the report to the webhook, if
+// one was configured. As the notification is only a courtesy to the operator,
+// failing to deliver it doesn't fail the scan, and is only logged.
+func notifyWebhook(ctx context.Context, nodeInfo *lnrpc.GetInfoResponse,
+	report *jsonReport) {
+
+	if *webhookURL == "" || len(report.InvalidChannels) == 0 {
+		return
+	}
+
+	payload := &webhookPayload{
+		NodePubkey:  nodeInfo.IdentityPubkey,
+		NodeAlias:   nodeInfo.Alias,
+		TotalLoss:   report.TotalLoss,
+		LossStatus:  report.LossStatus,
+		ToolVersion: report.ToolVersion,
+	}
+	for _, channel := range report.InvalidChannels {
+		payload.InvalidChannels = append(
+			payload.InvalidChannels, channel.ShortChanID,
+		)
+	}
+
+	if err := postWebhook(ctx, *webhookURL, payload); err != nil {
+		log.Warnf("Unable to notify webhook: %v", err)
+		return
+	}
+
+	log.Infof("Notified webhook of %v invalid channels",
+		len(payload.InvalidChannels))
+}
+
+// postWebhook POSTs the payload to the given URL as JSON.
+func postWebhook(ctx context.Context, url string,
+	payload *webhookPayload) error {
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %v", resp.Status)
+	}
+
+	return nil
+}